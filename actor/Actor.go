@@ -1,7 +1,10 @@
 package Actor
 
+import "context"
+
 type IActor interface {
 	Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error
+	TellContext(ctx context.Context, sender IActor, message *ActorMessage, reply ...**ActorMessage) error
 	ID() ActorID
 }
 
@@ -26,9 +29,14 @@ func (this *Actor) ID() ActorID {
 }
 
 func (this *Actor) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	return this.TellContext(context.Background(), sender, message, reply...)
+}
+
+func (this *Actor) TellContext(ctx context.Context, sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
 	messageInfo := &ActorMessageInfo{
 		Sender:  sender,
 		Message: message,
+		ctx:     ctx,
 	}
 	if len(reply) != 0 {
 		messageInfo.reply = reply[0]
@@ -1,13 +1,16 @@
 package Actor
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/3rd/iter"
 	"github.com/zllangct/rockgo/config"
 	"github.com/zllangct/rockgo/ecs"
 	"github.com/zllangct/rockgo/logger"
-	"github.com/zllangct/rockgo/timer"
 	"reflect"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -26,18 +29,72 @@ const (
 
 type ActorType int
 
+var ErrActorInactive = errors.New("this actor is inactive or destroyed")
+
 type ActorComponent struct {
 	ecs.ComponentBase
 	ActorType    ActorType
 	ActorID      ActorID                //Actor地址
+	Name         string                 //逻辑名，设置后登记到集群actor目录，迁移后仍可按名字找到
 	Proxy        *ActorProxyComponent   //Actor代理
 	queueReceive chan *ActorMessageInfo //接收消息队列
+	queueSystem  chan *ActorMessageInfo //系统消息队列，优先处理
 	close        chan bool              //关闭信号
 	active       int32                  //是否激活,0：未激活 1：激活
+	pending      int32                  //已接收未处理完的消息数
+	lastActive   int64                  //最近一次收到消息的时间，纳秒
+	dropped      int64                  //因邮箱溢出丢弃的消息数
+	schedules    sync.Map               //未完成的定时消息 [*Schedule,struct{}]
+	wake         chan struct{}          //有放回的暂存消息时唤醒dispatch
+
+	behaviorLocker sync.Mutex
+	behaviors      []actorBehavior     //行为栈，为空时为默认行为
+	stash          []*ActorMessageInfo //暂存的消息
+	unstashed      []*ActorMessageInfo //放回待处理的暂存消息
+
+	MailboxCapacity int             //邮箱容量，默认使用配置MailboxCapacity
+	MailboxOverflow MailboxOverflow //邮箱溢出策略，默认使用配置MailboxOverflow
+	MailboxTimeout  time.Duration   //溢出策略为block时的最长等待时间，0为一直等待，默认MAILBOX_TIMEOUT_CONFIG使用配置MailboxBlockTimeout
 }
 
 func NewActorComponent(actorType ActorType) *ActorComponent {
-	return &ActorComponent{ActorType: actorType}
+	return &ActorComponent{ActorType: actorType, MailboxTimeout: MAILBOX_TIMEOUT_CONFIG}
+}
+
+func (this *ActorComponent) New() ecs.IComponent {
+	return &ActorComponent{MailboxTimeout: MAILBOX_TIMEOUT_CONFIG}
+}
+
+//迁移时随实体序列化的状态
+type actorState struct {
+	ActorType       ActorType
+	Name            string
+	MailboxCapacity int
+	MailboxOverflow MailboxOverflow
+	MailboxTimeout  time.Duration
+}
+
+func (this *ActorComponent) Serialize() (interface{}, error) {
+	return ecs.SerializeState(&actorState{
+		ActorType:       this.ActorType,
+		Name:            this.Name,
+		MailboxCapacity: this.MailboxCapacity,
+		MailboxOverflow: this.MailboxOverflow,
+		MailboxTimeout:  this.MailboxTimeout,
+	})
+}
+
+func (this *ActorComponent) Deserialize(data interface{}) error {
+	state := &actorState{}
+	if err := ecs.DeserializeState(state, data); err != nil {
+		return err
+	}
+	this.ActorType = state.ActorType
+	this.Name = state.Name
+	this.MailboxCapacity = state.MailboxCapacity
+	this.MailboxOverflow = state.MailboxOverflow
+	this.MailboxTimeout = state.MailboxTimeout
+	return nil
 }
 
 func (this *ActorComponent) GetRequire() map[*ecs.Object][]reflect.Type {
@@ -55,8 +112,9 @@ func (this *ActorComponent) IsUnique() int {
 }
 
 func (this *ActorComponent) Initialize() error {
-	this.queueReceive = make(chan *ActorMessageInfo, 20)
+	this.initMailbox()
 	this.close = make(chan bool)
+	this.wake = make(chan struct{}, 1)
 	//初始化actor类型
 	if this.ActorType == ACTOR_TYPE_DEFAULT {
 		this.ActorType = ACTOR_TYPE_ASYNC
@@ -75,10 +133,17 @@ func (this *ActorComponent) Initialize() error {
 		logger.Error(err)
 		return err
 	}
+	atomic.StoreInt64(&this.lastActive, time.Now().UnixNano())
 	//初始化消息分发器
 	go this.dispatch()
 	//设置Actor状态为激活
 	atomic.StoreInt32(&this.active, 1)
+	//登记到actor目录
+	if this.Name != "" {
+		if err = this.Proxy.RegisterName(this.Name, this); err != nil {
+			logger.Error(err)
+		}
+	}
 	return nil
 }
 
@@ -90,19 +155,82 @@ func (this *ActorComponent) UnregisterService(service string) {
 }
 
 func (this *ActorComponent) Destroy(ctx *ecs.Context) {
+	//取消定时消息
+	this.cancelSchedules()
 	this.close <- true
 	//在ActorProxy取消注册
 	this.Proxy.Unregister(this)
+	//注销该actor的服务，重启后可重新注册
+	this.Proxy.unregisterServices(this)
+	//退订所有主题
+	go this.Proxy.unsubscribeAll(this)
+	if this.Name != "" {
+		go this.Proxy.UnregisterName(this.Name, this)
+	}
 }
 
-func (this *ActorComponent) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
-	if atomic.LoadInt32(&this.active) == 0 {
-		return errors.New("this actor is inactive or destroyed")
+//停机钩子：等待已接收的消息处理完成
+func (this *ActorComponent) Shutdown(ctx context.Context) {
+	this.waitIdle(ctx)
+}
+
+//空闲时长，有未处理完的消息时为0
+func (this *ActorComponent) idle() time.Duration {
+	if atomic.LoadInt32(&this.pending) > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&this.lastActive)))
+}
+
+//等待已接收的消息处理完成
+func (this *ActorComponent) waitIdle(ctx context.Context) error {
+	for atomic.LoadInt32(&this.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 10):
+		}
 	}
+	return nil
+}
 
+//停止接收新消息并等待已接收的消息处理完，销毁实体前调用，避免处理中的消息与销毁同时访问实体的组件
+func (this *ActorComponent) deactivate(ctx context.Context) error {
+	atomic.StoreInt32(&this.active, 0)
+	return this.waitIdle(ctx)
+}
+
+//停止实体及其子实体上的actor，等待处理中的消息完成后销毁实体
+func destroyActor(object *ecs.Object) error {
+	var actors []*ActorComponent
+	collect := func(components iter.Iter) {
+		for val, err := components.Next(); err == nil; val, err = components.Next() {
+			actors = append(actors, val.(*ActorComponent))
+		}
+	}
+	typ := reflect.TypeOf(&ActorComponent{})
+	collect(object.GetComponents(typ))
+	collect(object.GetComponentsInChildren(typ))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(config.Config.ClusterConfig.RpcCallTimeout))
+	defer cancel()
+	for _, actor := range actors {
+		if err := actor.deactivate(ctx); err != nil {
+			logger.Warn(fmt.Sprintf("actor [ %s ] is still handling messages: %s", actor.ID().String(), err.Error()))
+		}
+	}
+	return object.Destroy()
+}
+
+func (this *ActorComponent) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	return this.TellContext(context.Background(), sender, message, reply...)
+}
+
+//ctx无截止时间时，等待回复使用RpcCallTimeout
+func (this *ActorComponent) TellContext(ctx context.Context, sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
 	messageInfo := &ActorMessageInfo{
 		Sender:  sender,
 		Message: message,
+		ctx:     ctx,
 	}
 
 	if len(reply) > 0 {
@@ -112,16 +240,48 @@ func (this *ActorComponent) Tell(sender IActor, message *ActorMessage, reply ...
 		messageInfo.NeedReply(false)
 	}
 
-	this.queueReceive <- messageInfo
+	if err := this.receive(ctx, messageInfo); err != nil {
+		return err
+	}
 
 	if messageInfo.IsNeedReply() {
+		var timeout <-chan time.Time
+		if _, ok := ctx.Deadline(); !ok {
+			t := time.NewTimer(time.Duration(config.Config.ClusterConfig.RpcCallTimeout) * time.Millisecond)
+			defer t.Stop()
+			timeout = t.C
+		}
 		select {
-		case <-timer.After(time.Duration(config.Config.ClusterConfig.RpcCallTimeout) * time.Millisecond):
-			messageInfo.err = ErrTimeout
+		case <-timeout:
+			return ErrTimeout
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-messageInfo.done:
 		}
+		return messageInfo.err
+	}
+	//无需回复时处理者可能仍在处理，不读取其结果
+	return nil
+}
+
+//接收消息到邮箱
+func (this *ActorComponent) receive(ctx context.Context, messageInfo *ActorMessageInfo) error {
+	//先计数再检查状态，deactivate等待空闲后不会再有消息进入处理
+	atomic.AddInt32(&this.pending, 1)
+	if atomic.LoadInt32(&this.active) == 0 {
+		atomic.AddInt32(&this.pending, -1)
+		this.Proxy.deadLetter(this.ActorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_INACTIVE, ErrActorInactive)
+		return ErrActorInactive
+	}
+	atomic.StoreInt64(&this.lastActive, time.Now().UnixNano())
+	if err := this.post(ctx, messageInfo); err != nil {
+		atomic.AddInt32(&this.pending, -1)
+		if reason := deadLetterReason(err); reason != "" {
+			this.Proxy.deadLetter(this.ActorID, messageInfo.Sender, messageInfo.Message, reason, err)
+		}
+		return err
 	}
-	return messageInfo.err
+	return nil
 }
 
 func (this *ActorComponent) Emit() {
@@ -134,30 +294,66 @@ func (this *ActorComponent) ID() ActorID {
 
 func (this *ActorComponent) dispatch() {
 	var messageInfo *ActorMessageInfo
-	var ok bool
 	for {
+		//系统消息优先，其次是放回的暂存消息
+		select {
+		case messageInfo = <-this.queueSystem:
+			this.deliver(messageInfo)
+			continue
+		default:
+		}
+		if messageInfo = this.popUnstashed(); messageInfo != nil {
+			this.deliver(messageInfo)
+			continue
+		}
 		select {
 		case <-this.close:
 			atomic.StoreInt32(&this.active, 0)
-			close(this.queueReceive)
 			//收到关闭信号后会继续处理完剩余消息
-		case messageInfo, ok = <-this.queueReceive:
-			if !ok {
-				return
-			}
-			switch this.ActorType {
-			case ACTOR_TYPE_SYNC:
-				this.handle(messageInfo)
-			case ACTOR_TYPE_ASYNC:
-				go this.handle(messageInfo)
-			default:
-
+			for {
+				if messageInfo = this.popUnstashed(); messageInfo == nil {
+					select {
+					case messageInfo = <-this.queueSystem:
+					case messageInfo = <-this.queueReceive:
+					default:
+						this.discardStash()
+						return
+					}
+				}
+				this.deliver(messageInfo)
 			}
+		case messageInfo = <-this.queueSystem:
+			this.deliver(messageInfo)
+		case <-this.wake:
+		case messageInfo = <-this.queueReceive:
+			this.deliver(messageInfo)
 		}
 	}
 }
 
+func (this *ActorComponent) deliver(messageInfo *ActorMessageInfo) {
+	switch this.ActorType {
+	case ACTOR_TYPE_SYNC:
+		this.handle(messageInfo)
+	case ACTOR_TYPE_ASYNC:
+		go this.handle(messageInfo)
+	default:
+
+	}
+}
+
 func (this *ActorComponent) handle(messageInfo *ActorMessageInfo) {
+	defer atomic.AddInt32(&this.pending, -1)
+	//切换了行为时只交给当前行为处理
+	if handlers := this.behavior(); handlers != nil {
+		if handler, ok := handlers[messageInfo.Message.Service]; ok {
+			this.Catch(handler, messageInfo)
+			return
+		}
+		this.Proxy.deadLetter(this.ActorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_UNHANDLED, ErrUnhandledService)
+		messageInfo.replyError(ErrUnhandledService)
+		return
+	}
 	cps := this.Parent().AllComponents()
 	var err error = nil
 	var val interface{}
@@ -165,6 +361,10 @@ func (this *ActorComponent) handle(messageInfo *ActorMessageInfo) {
 		if messageHandler, ok := val.(IActorMessageHandler); ok {
 			if handler, ok := messageHandler.MessageHandlers()[messageInfo.Message.Service]; ok {
 				this.Catch(handler, messageInfo)
+				//已暂存的消息不再交给其他组件
+				if messageInfo.stashed {
+					return
+				}
 			}
 		}
 	}
@@ -183,9 +383,17 @@ func (this *ActorComponent) Catch(handler func(message *ActorMessageInfo) error,
 			err := errors.New(str + string(debug.Stack()))
 			logger.Error(err)
 			m.replyError(err)
+			//通知监督者
+			if supervisor := supervisorOf(this.Parent()); supervisor != nil {
+				go supervisor.childFailed(this.Parent(), err)
+			}
 		}
 	})()
 	err := handler(m)
+	//暂存的消息在放回处理后再回复
+	if m.stashed {
+		return
+	}
 	if err != nil {
 		m.replyError(err)
 	} else {
@@ -1,8 +1,17 @@
 package Actor
 
+import (
+	"errors"
+	"github.com/ugorji/go/codec"
+)
+
+var ErrNoPayload = errors.New("actor message has no typed payload")
+
 type ActorMessage struct {
 	Service string
 	Data    []interface{}
+	System  bool   //系统消息，走actor邮箱的优先通道
+	Payload []byte //类型化数据，msgpack编码
 }
 
 func NewActorMessage(service string, args ...interface{}) *ActorMessage {
@@ -11,3 +20,32 @@ func NewActorMessage(service string, args ...interface{}) *ActorMessage {
 		Data:    args,
 	}
 }
+
+//系统消息，先于用户消息处理
+func NewSystemActorMessage(service string, args ...interface{}) *ActorMessage {
+	return &ActorMessage{
+		Service: service,
+		Data:    args,
+		System:  true,
+	}
+}
+
+//携带类型化数据的消息
+func NewTypedActorMessage(service string, v interface{}) (*ActorMessage, error) {
+	payload, err := encodePayload(v)
+	if err != nil {
+		return nil, err
+	}
+	return &ActorMessage{
+		Service: service,
+		Payload: payload,
+	}, nil
+}
+
+//将类型化数据解码到out
+func (this *ActorMessage) Decode(out interface{}) error {
+	if this == nil || len(this.Payload) == 0 {
+		return ErrNoPayload
+	}
+	return codec.NewDecoderBytes(this.Payload, payloadHandle).Decode(out)
+}
@@ -1,6 +1,7 @@
 package Actor
 
 import (
+	"context"
 	"errors"
 )
 
@@ -14,19 +15,32 @@ type ActorMessageInfo struct {
 	Sender  IActor
 	Message *ActorMessage
 	reply   **ActorMessage
+	ctx     context.Context
 	done    chan struct{}
 	isDone  bool
 	err     error
+	onReply func(reply *ActorMessage, err error) //Ask的回复回调
+	answer  *ActorMessage                        //Ask的回复
+	stashed bool                                 //已被处理者暂存
 }
 
 func (this *ActorMessageInfo) NeedReply(isReply bool) {
 	if isReply {
-		this.done = make(chan struct{})
+		//带缓冲，发送方超时放弃后处理者不会阻塞
+		this.done = make(chan struct{}, 1)
 	}
 }
 
+//消息的上下文，处理者可据此判断发送方是否已放弃等待
+func (this *ActorMessageInfo) Context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+	return this.ctx
+}
+
 func (this *ActorMessageInfo) IsNeedReply() bool {
-	return this.done != nil
+	return this.done != nil || this.onReply != nil
 }
 
 func (this *ActorMessageInfo) Reply(args ...interface{}) error {
@@ -34,6 +48,13 @@ func (this *ActorMessageInfo) Reply(args ...interface{}) error {
 }
 
 func (this *ActorMessageInfo) ReplyWithService(service string, args ...interface{}) error {
+	if this.onReply != nil {
+		this.answer = &ActorMessage{
+			Service: service,
+			Data:    args,
+		}
+		return nil
+	}
 	if this.done != nil {
 		**this.reply = ActorMessage{
 			Service: service,
@@ -45,8 +66,29 @@ func (this *ActorMessageInfo) ReplyWithService(service string, args ...interface
 	}
 }
 
+//以类型化数据回复，发送方用Future.Await或ActorMessage.Decode解码
+func (this *ActorMessageInfo) ReplyTyped(v interface{}) error {
+	payload, err := encodePayload(v)
+	if err != nil {
+		return err
+	}
+	if this.onReply != nil {
+		this.answer = &ActorMessage{Payload: payload}
+		return nil
+	}
+	if this.done != nil {
+		**this.reply = ActorMessage{Payload: payload}
+		return nil
+	}
+	return errors.New("this message invalid")
+}
+
 func (this *ActorMessageInfo) replyError(err error) {
 	this.err = err
+	if this.onReply != nil && !this.isDone {
+		this.isDone = true
+		this.onReply(nil, err)
+	}
 	if this.done != nil && !this.isDone {
 		this.done <- struct{}{}
 		this.isDone = true
@@ -54,6 +96,10 @@ func (this *ActorMessageInfo) replyError(err error) {
 }
 
 func (this *ActorMessageInfo) replySuccess() {
+	if this.onReply != nil && !this.isDone {
+		this.isDone = true
+		this.onReply(this.answer, nil)
+	}
 	if this.done != nil && !this.isDone {
 		this.done <- struct{}{}
 		this.isDone = true
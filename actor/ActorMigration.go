@@ -0,0 +1,296 @@
+package Actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/cluster"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/rpc"
+	"github.com/zllangct/rockgo/utils/UUID"
+	"time"
+)
+
+/*
+	actor迁移
+	把actor所在的实体序列化后在目标节点重新插入，旧地址留下墓碑，在ActorTombstoneTTL内把发往旧地址的消息转发到新地址。
+	实体上的组件需在两个节点的Runtime.Factory()中注册，需要迁移的状态通过ecs.IPersist序列化。
+	设置了Name的actor在新节点重新登记到actor目录，发送方通过GetActorByName取得新地址。
+	迁移调用超时或连接断开时无法确定目标节点是否已插入实体，源节点向目标节点确认：已插入时按迁移成功处理，
+	未插入时目标节点作废本次迁移，之后到达的迁移请求不再插入，原actor继续运行。
+	节点因超时被master移出后，其登记的逻辑名被清除，节点重新加入时重新登记。
+*/
+
+var ErrMigrateAborted = errors.New("migration aborted")
+
+type actorTombstone struct {
+	to     ActorID
+	expire time.Time
+}
+
+type ActorMigrateArgs struct {
+	ID       string  //本次迁移的ID
+	From     ActorID //旧地址
+	Template []byte  //实体模板，json
+}
+
+//目标节点上的迁移记录，用于确认超时的迁移
+type migrationRecord struct {
+	done   chan struct{} //插入完成时关闭，作废的迁移创建时即关闭
+	id     ActorID       //插入的actor地址，插入失败或已作废时为空
+	expire time.Time
+}
+
+//迁移actor所在实体到目标节点，返回新地址
+func (this *ActorProxyComponent) Migrate(object *ecs.Object, target string) (ActorID, error) {
+	return this.MigrateContext(context.Background(), object, target)
+}
+
+//迁移期间发往该actor的消息等待迁移完成后转发，迁移失败时仍由原actor处理
+func (this *ActorProxyComponent) MigrateContext(ctx context.Context, object *ecs.Object, target string) (ActorID, error) {
+	var actor *ActorComponent
+	if err := object.Find(&actor); err != nil {
+		return nil, err
+	}
+	if target == this.nodeID {
+		return nil, ErrMigrateToSelf
+	}
+	from := actor.ID()
+	done := make(chan struct{})
+	this.migrating.Store(from.String(), done)
+	defer func() {
+		this.migrating.Delete(from.String())
+		close(done)
+	}()
+
+	//等待已接收的消息处理完，避免迁移后丢失状态修改
+	if err := actor.waitIdle(ctx); err != nil {
+		return nil, err
+	}
+	template, err := this.Runtime().Factory().Serialize(object)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ecs.ObjectTemplateAsJson(template)
+	if err != nil {
+		return nil, err
+	}
+	client, err := this.nodeComponent.GetNodeClient(target)
+	if err != nil {
+		return nil, err
+	}
+	args := &ActorMigrateArgs{ID: UUID.Next(), From: from, Template: data}
+	var to ActorID
+	err = client.CallContext(ctx, "ActorProxyService.Migrate", args, &to)
+	if _, remote := err.(rpc.ServerError); err != nil && !remote {
+		//目标节点可能已插入实体，确认结果或作废本次迁移
+		to, err = this.confirmMigration(target, args.ID, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	this.cleanTombstones()
+	ttl := time.Millisecond * time.Duration(config.Config.ClusterConfig.ActorTombstoneTTL)
+	this.tombstones.Store(from.String(), &actorTombstone{to: to, expire: time.Now().Add(ttl)})
+	if err = object.Destroy(); err != nil {
+		return to, err
+	}
+	return to, nil
+}
+
+//向目标节点确认迁移结果，已插入时返回新地址，未插入时返回迁移调用的错误
+func (this *ActorProxyComponent) confirmMigration(target string, id string, cause error) (ActorID, error) {
+	ctx, cancel := this.timeoutContext()
+	defer cancel()
+	client, err := this.nodeComponent.GetNodeClient(target)
+	if err == nil {
+		var to ActorID
+		if err = client.CallContext(ctx, "ActorProxyService.ConfirmMigrate", id, &to); err == nil {
+			if to == nil {
+				return nil, cause
+			}
+			return to, nil
+		}
+	}
+	logger.Error(fmt.Sprintf("confirm migration [ %s ] to [ %s ] failed, the actor may run on both nodes: %s", id, target, err.Error()))
+	return nil, cause
+}
+
+//在本节点插入迁入的实体，返回其actor地址；迁移已作废时不插入
+func (this *ActorProxyComponent) insertMigrated(args *ActorMigrateArgs) (ActorID, error) {
+	record := &migrationRecord{done: make(chan struct{})}
+	if args.ID != "" {
+		this.migrationLocker.Lock()
+		this.cleanMigrations()
+		if _, ok := this.migrations[args.ID]; ok {
+			//已作废或重复的请求
+			this.migrationLocker.Unlock()
+			return nil, ErrMigrateAborted
+		}
+		record.expire = time.Now().Add(time.Millisecond * time.Duration(config.Config.ClusterConfig.ActorTombstoneTTL))
+		this.migrations[args.ID] = record
+		this.migrationLocker.Unlock()
+	}
+	defer close(record.done)
+
+	template, err := ecs.ObjectTemplateFromJson(string(args.Template))
+	if err != nil {
+		return nil, err
+	}
+	object, err := this.Runtime().Insert(template, this.Runtime().Root())
+	if err != nil {
+		return nil, err
+	}
+	var actor *ActorComponent
+	if err = object.Find(&actor); err != nil {
+		object.Destroy()
+		return nil, err
+	}
+	record.id = actor.ID()
+	return actor.ID(), nil
+}
+
+//确认迁移结果：已插入时返回新地址，插入中时等待，未收到时作废该迁移并返回空
+func (this *ActorProxyComponent) confirmMigrated(id string) ActorID {
+	this.migrationLocker.Lock()
+	this.cleanMigrations()
+	record, ok := this.migrations[id]
+	if !ok {
+		record = &migrationRecord{
+			done:   make(chan struct{}),
+			expire: time.Now().Add(time.Millisecond * time.Duration(config.Config.ClusterConfig.ActorTombstoneTTL)),
+		}
+		close(record.done)
+		this.migrations[id] = record
+	}
+	this.migrationLocker.Unlock()
+	<-record.done
+	return record.id
+}
+
+//清除过期的迁移记录，调用方持有migrationLocker
+func (this *ActorProxyComponent) cleanMigrations() {
+	if this.migrations == nil {
+		this.migrations = make(map[string]*migrationRecord)
+	}
+	now := time.Now()
+	for id, record := range this.migrations {
+		if now.After(record.expire) {
+			delete(this.migrations, id)
+		}
+	}
+}
+
+//查询本节点上已迁出actor的新地址，迁移中的actor等待迁移结束
+func (this *ActorProxyComponent) forward(ctx context.Context, actorID ActorID) (ActorID, bool) {
+	if v, ok := this.migrating.Load(actorID.String()); ok {
+		select {
+		case <-v.(chan struct{}):
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+	v, ok := this.tombstones.Load(actorID.String())
+	if !ok {
+		return nil, false
+	}
+	tombstone := v.(*actorTombstone)
+	if time.Now().After(tombstone.expire) {
+		this.tombstones.Delete(actorID.String())
+		return nil, false
+	}
+	return tombstone.to, true
+}
+
+//actor的当前地址，跟随本节点的墓碑
+func (this *ActorProxyComponent) Resolve(actorID ActorID) ActorID {
+	if to, ok := this.forward(context.Background(), actorID); ok {
+		return to
+	}
+	return actorID
+}
+
+func (this *ActorProxyComponent) cleanTombstones() {
+	now := time.Now()
+	this.tombstones.Range(func(key, value interface{}) bool {
+		if now.After(value.(*actorTombstone).expire) {
+			this.tombstones.Delete(key)
+		}
+		return true
+	})
+}
+
+/*
+	actor目录
+*/
+
+//按逻辑名登记actor
+func (this *ActorProxyComponent) RegisterName(name string, actor IActor) error {
+	ctx, cancel := this.timeoutContext()
+	defer cancel()
+	return this.nodeComponent.RegisterActor(ctx, name, actor.ID().String())
+}
+
+//注销逻辑名，名字已指向其他地址时不注销
+func (this *ActorProxyComponent) UnregisterName(name string, actor IActor) error {
+	ctx, cancel := this.timeoutContext()
+	defer cancel()
+	return this.nodeComponent.UnregisterActor(ctx, name, actor.ID().String())
+}
+
+//本节点被master移出后重新加入时，重新登记本地actor
+func (this *ActorProxyComponent) membershipChanged(event *Cluster.MembershipEvent) {
+	if event.Node == nil || event.Node.Address != this.nodeID || event.Type == Cluster.MEMBER_EVENT_LEAVE {
+		return
+	}
+	//回调逐个调用，joined无需加锁
+	if event.Node.Joined == this.joined {
+		return
+	}
+	first := this.joined == 0
+	this.joined = event.Node.Joined
+	if !first {
+		go this.rejoined()
+	}
+}
+
+//重新登记本地actor的逻辑名、订阅的主题和grain激活
+func (this *ActorProxyComponent) rejoined() {
+	logger.Info("node rejoined the cluster, registering local actors again")
+	this.localActors.Range(func(key, value interface{}) bool {
+		actor, ok := value.(*ActorComponent)
+		if !ok || actor.Name == "" {
+			return true
+		}
+		if err := this.RegisterName(actor.Name, actor); err != nil {
+			logger.Warn(fmt.Sprintf("register actor name [ %s ] failed: %s", actor.Name, err.Error()))
+		}
+		return true
+	})
+	this.resubscribeTopics()
+	this.reclaimGrains()
+}
+
+//按逻辑名获取actor
+func (this *ActorProxyComponent) GetActorByName(name string) (IActor, error) {
+	return this.GetActorByNameContext(context.Background(), name)
+}
+
+func (this *ActorProxyComponent) GetActorByNameContext(ctx context.Context, name string) (IActor, error) {
+	addr, err := this.nodeComponent.ResolveActor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	id := EmptyActorID()
+	if err = id.Parse(addr); err != nil {
+		return nil, err
+	}
+	return NewActor(id, this), nil
+}
+
+func (this *ActorProxyComponent) timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Millisecond*time.Duration(config.Config.ClusterConfig.RpcCallTimeout))
+}
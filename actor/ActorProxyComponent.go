@@ -1,6 +1,7 @@
 package Actor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/zllangct/rockgo/cluster"
@@ -15,15 +16,39 @@ import (
 
 var ErrNoThisService = errors.New("no this service")
 var ErrNoThisActor = errors.New("no this actor")
+var ErrMigrateToSelf = errors.New("actor is already on this node")
 
 type ActorProxyComponent struct {
 	ecs.ComponentBase
-	locker        sync.RWMutex
-	nodeID        string
-	localActors   sync.Map //本地actor [Target,actor]
-	service       sync.Map // [service,[]actor]
-	nodeComponent *Cluster.NodeComponent
-	location      *rpc.TcpClient
+	locker          sync.RWMutex
+	nodeID          string
+	localActors     sync.Map //本地actor [Target,actor]
+	tombstones      sync.Map //已迁出的actor [旧地址,*actorTombstone]
+	migrating       sync.Map //迁移中的actor [地址,chan struct{}]，迁移完成时关闭
+	migrationLocker sync.Mutex
+	migrations      map[string]*migrationRecord //迁入本节点的迁移 [迁移ID,记录]
+	joined          int64                       //本节点加入集群的时间，变化时说明曾被master移出
+	grainLocker     sync.Mutex
+	grainTypes      map[string]*GrainType       //已注册的grain类型
+	grains          map[string]*grainActivation //本节点上的grain激活 [grain key,激活]
+	grainStore      GrainStore                  //grain状态存储
+	grainCache      sync.Map                    //grain激活地址缓存 [grain key,ActorID]
+	grainSweeper    sync.Once
+	grainSweepStop  chan struct{}                //关闭时停止空闲检查，由grainLocker保护
+	singletons      map[string]*singletonManager //本节点竞争租约的单例 [单例名,管理者]
+	singletonCache  sync.Map                     //单例地址缓存 [单例名,*singletonAddress]
+	journal         Journal                      //actor持久化日志
+	asks            sync.Map                     //等待回复的远程Ask [请求ID,*Future]
+	askSeq          uint64
+	topicLocker     sync.Mutex
+	topics          map[string]map[string]IActor //本地订阅者 [主题,[actor地址,actor]]
+	topicSyncLocker sync.Mutex
+	topicRegistered map[string]bool //已在master登记本节点的主题，由topicSyncLocker保护
+	topicCache      sync.Map        //订阅主题的节点缓存 [主题,*topicNodes]
+	deadLetters     deadLetterBox   //死信
+	service         sync.Map        // [service,[]actor]
+	nodeComponent   *Cluster.NodeComponent
+	location        *rpc.TcpClient
 	//isActorMode   bool
 	isOnline bool
 }
@@ -49,6 +74,11 @@ func (this *ActorProxyComponent) Initialize() error {
 	if err != nil {
 		return err
 	}
+	//actor迁移时需要反序列化ActorComponent
+	this.Runtime().Factory().Register(&ActorComponent{})
+	//监督者重启子实体时需要反序列化SupervisorComponent
+	this.Runtime().Factory().Register(&SupervisorComponent{})
+	this.Runtime().Factory().Register(&PersistenceComponent{})
 	//注册ActorProxyService服务
 	s := new(ActorProxyService)
 	s.init(this)
@@ -56,6 +86,7 @@ func (this *ActorProxyComponent) Initialize() error {
 	if err != nil {
 		return err
 	}
+	this.nodeComponent.OnMembershipChange(this.membershipChanged)
 	logger.Info("ActorProxyComponent initialized.")
 	return nil
 }
@@ -67,7 +98,14 @@ func (this *ActorProxyComponent) IsOnline() bool {
 }
 
 func (this *ActorProxyComponent) Destroy(ctx *ecs.Context) {
+	this.deactivateGrains()
+	this.stopGrainSweeper()
+}
 
+//停机钩子：停止本节点上的单例，失活grain并保存状态
+func (this *ActorProxyComponent) Shutdown(ctx context.Context) {
+	this.releaseSingletons()
+	this.deactivateGrains()
 }
 
 //获取本地actor服务
@@ -87,6 +125,10 @@ func (this *ActorProxyComponent) GetLocalActorService(serviceName string) (*Acto
 
 //获取actor服务
 func (this *ActorProxyComponent) GetActorService(role string, serviceName string) (*ActorService, error) {
+	return this.GetActorServiceContext(context.Background(), role, serviceName)
+}
+
+func (this *ActorProxyComponent) GetActorServiceContext(ctx context.Context, role string, serviceName string) (*ActorService, error) {
 	var service *ActorService
 	var err error
 	//优先尝试本地服务
@@ -99,12 +141,12 @@ func (this *ActorProxyComponent) GetActorService(role string, serviceName string
 	if role == LOCAL_SERVICE {
 		return nil, errors.New("role is empty")
 	}
-	client, err := this.nodeComponent.GetNodeClientByRole(role)
+	client, err := this.nodeComponent.GetNodeClientByRoleContext(ctx, role)
 	if err != nil {
 		return nil, err
 	}
 	var reply ActorID
-	err = client.Call("ActorProxyService.ServiceInquiry", serviceName, &reply)
+	err = client.CallContext(ctx, "ActorProxyService.ServiceInquiry", serviceName, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -126,6 +168,16 @@ func (this *ActorProxyComponent) UnregisterService(service string) {
 	this.service.Delete(service)
 }
 
+//取消注册actor的所有服务
+func (this *ActorProxyComponent) unregisterServices(actor IActor) {
+	this.service.Range(func(key, value interface{}) bool {
+		if value.(*ActorService).actor == actor {
+			this.service.Delete(key)
+		}
+		return true
+	})
+}
+
 //注册本地actor
 func (this *ActorProxyComponent) Register(actor IActor) error {
 	id := actor.ID()
@@ -146,17 +198,47 @@ func (this *ActorProxyComponent) Unregister(actor IActor) {
 	}
 }
 
+//本地actor数量
+func (this *ActorProxyComponent) ActorCount() int {
+	count := 0
+	this.localActors.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+//本地actor邮箱中等待处理的消息总数
+func (this *ActorProxyComponent) MailboxDepth() int {
+	depth := 0
+	this.localActors.Range(func(key, value interface{}) bool {
+		if actor, ok := value.(*ActorComponent); ok {
+			depth += actor.MailboxDepth()
+		}
+		return true
+	})
+	return depth
+}
+
+//负载：本地actor数量、邮箱积压
+func (this *ActorProxyComponent) CollectLoad(info map[string]float32) {
+	info[Cluster.LOAD_FIELD_ACTOR] = float32(this.ActorCount())
+	info[Cluster.LOAD_FIELD_MAILBOX] = float32(this.MailboxDepth())
+}
+
 //发送本地消息
 func (this *ActorProxyComponent) LocalTell(actorID ActorID, messageInfo *ActorMessageInfo) error {
 	v, ok := this.localActors.Load(actorID.String())
 	if !ok {
+		this.deadLetter(actorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_NO_ACTOR, ErrNoThisActor)
 		return ErrNoThisActor
 	}
 	actor, ok := v.(IActor)
 	if !ok {
+		this.deadLetter(actorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_NO_ACTOR, ErrNoThisActor)
 		return ErrNoThisActor
 	}
-	return actor.Tell(messageInfo.Sender, messageInfo.Message, messageInfo.reply)
+	return actor.TellContext(messageInfo.Context(), messageInfo.Sender, messageInfo.Message, messageInfo.reply)
 }
 
 //通过actor id 发送消息
@@ -168,24 +250,31 @@ func (this *ActorProxyComponent) Emit(actorID ActorID, messageInfo *ActorMessage
 	logger.Debug(fmt.Sprintf("actor: [ %s ] send message [ %s ] to actor [ %s ]", senderID, messageInfo.Message.Service, actorID.String()))
 	nodeID := actorID.GetNodeID()
 
-	//本地消息不走网络
+	//本地消息不走网络，已迁出的actor转发到新地址
 	if nodeID == this.nodeID {
+		if to, ok := this.forward(messageInfo.Context(), actorID); ok {
+			return this.Emit(to, messageInfo)
+		}
 		return this.LocalTell(actorID, messageInfo)
 	}
 	//非本地消息走网络代理
 	client, err := this.nodeComponent.GetNodeClient(nodeID)
 	if err != nil {
+		this.deadLetter(actorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_UNREACHABLE, err)
 		return err
 	}
 	var sender ActorID
 	if messageInfo.Sender != nil {
 		sender = messageInfo.Sender.ID()
 	}
-	err = client.Call("ActorProxyService.Tell", &ActorRpcMessageInfo{
+	err = client.CallContext(messageInfo.Context(), "ActorProxyService.Tell", &ActorRpcMessageInfo{
 		Target:  actorID,
 		Sender:  sender,
 		Message: messageInfo.Message}, messageInfo.reply)
 	if err != nil {
+		if isUnreachable(err) {
+			this.deadLetter(actorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_UNREACHABLE, err)
+		}
 		return err
 	}
 	return nil
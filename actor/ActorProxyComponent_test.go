@@ -0,0 +1,192 @@
+package Actor
+
+import (
+	"github.com/zllangct/rockgo/cluster"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/utils/UUID"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testNodeOnce  sync.Once
+	testNodeProxy *ActorProxyComponent
+)
+
+func TestMain(m *testing.M) {
+	//配置、日志和持久化文件写到临时目录
+	dir, err := ioutil.TempDir("", "rockgo-actor")
+	if err != nil {
+		panic(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	logger.SetLevel(logger.ERROR)
+	code := m.Run()
+	os.Chdir(wd)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+//单节点集群，master、子节点和actor代理在同一运行时中，整个测试进程共用
+func testNode(t *testing.T) *ActorProxyComponent {
+	testNodeOnce.Do(func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+
+		runtime := ecs.NewRuntime(ecs.Config{ThreadPoolSize: 4})
+		runtime.UpdateFrameByInterval(time.Millisecond * 20)
+		root := runtime.Root()
+		root.AddComponent(&config.ConfigComponent{})
+		conf := config.Config.ClusterConfig
+		conf.LocalAddress = addr
+		conf.MasterAddress = addr
+		conf.Role = []string{"master", "room"}
+		conf.ReportInterval = 100
+		conf.RpcCallTimeout = 1000
+		conf.IsLocationMode = false
+		conf.LocationSyncInterval = 100
+
+		root.AddComponent(&Cluster.NodeComponent{})
+		testNodeProxy = &ActorProxyComponent{}
+		root.AddComponent(testNodeProxy)
+		root.AddComponent(&Cluster.MasterComponent{})
+		root.AddComponent(&Cluster.ChildComponent{})
+		waitFor(t, time.Second*5, "node joins the cluster", func() bool {
+			ctx, cancel := testNodeProxy.timeoutContext()
+			defer cancel()
+			_, err := testNodeProxy.nodeComponent.GetNodeClientByRoleContext(ctx, "room")
+			return err == nil
+		})
+	})
+	if testNodeProxy == nil {
+		t.Fatal("test node is not running")
+	}
+	return testNodeProxy
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+//按服务名处理消息的组件
+type testHandler struct {
+	ecs.ComponentBase
+	handlers map[string]func(message *ActorMessageInfo) error
+}
+
+func (this *testHandler) MessageHandlers() map[string]func(message *ActorMessageInfo) error {
+	return this.handlers
+}
+
+//在测试节点上创建actor
+func newTestActor(t *testing.T, proxy *ActorProxyComponent, actor *ActorComponent, handlers map[string]func(message *ActorMessageInfo) error) *ecs.Object {
+	object := ecs.NewObject("test actor")
+	components := []ecs.IComponent{actor}
+	if handlers != nil {
+		components = []ecs.IComponent{&testHandler{handlers: handlers}, actor}
+	}
+	if err := proxy.Runtime().Root().AddObjectWithComponents(object, components); err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+//master移出本节点，本节点下次上报时重新加入
+func evict(t *testing.T, proxy *ActorProxyComponent) {
+	t.Helper()
+	//等待本节点已从成员变化中看到自己
+	seen := make(chan struct{})
+	var once sync.Once
+	proxy.nodeComponent.OnMembershipChange(func(event *Cluster.MembershipEvent) {
+		if event.Node != nil && event.Node.Address == proxy.nodeID {
+			once.Do(func() { close(seen) })
+		}
+	})
+	select {
+	case <-seen:
+	case <-time.After(time.Second * 3):
+		t.Fatal("membership of this node is not watched")
+	}
+
+	ctx, cancel := proxy.timeoutContext()
+	defer cancel()
+	err := proxy.nodeComponent.CallMaster(ctx, "MasterService.ReportNodeClose", &Cluster.NodeCloseArgs{Address: proxy.nodeID}, new(bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRejoinRegistersNames(t *testing.T) {
+	proxy := testNode(t)
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	actor.Name = "rejoin"
+	object := newTestActor(t, proxy, actor, nil)
+	defer destroyActor(object)
+
+	resolved := func() bool {
+		a, err := proxy.GetActorByName("rejoin")
+		return err == nil && a.ID().Equal(actor.ID())
+	}
+	waitFor(t, time.Second, "the name is registered", resolved)
+	evict(t, proxy)
+	if _, err := proxy.GetActorByName("rejoin"); err == nil {
+		t.Fatal("names of a removed node should be dropped")
+	}
+	waitFor(t, time.Second*3, "the name is registered again after rejoining", resolved)
+}
+
+func TestMigrationConfirm(t *testing.T) {
+	proxy := testNode(t)
+	object := newTestActor(t, proxy, NewActorComponent(ACTOR_TYPE_SYNC), nil)
+	template, err := proxy.Runtime().Factory().Serialize(object)
+	destroyActor(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ecs.ObjectTemplateAsJson(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//确认先于迁移请求到达时作废该迁移
+	aborted := UUID.Next()
+	if id := proxy.confirmMigrated(aborted); id != nil {
+		t.Fatalf("nothing was inserted, got %v", id)
+	}
+	if _, err := proxy.insertMigrated(&ActorMigrateArgs{ID: aborted, Template: data}); err != ErrMigrateAborted {
+		t.Fatalf("expected the aborted migration to be refused, got %v", err)
+	}
+
+	//已插入时确认返回新地址
+	inserted := UUID.Next()
+	id, err := proxy.insertMigrated(&ActorMigrateArgs{ID: inserted, Template: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if v, ok := proxy.localActors.Load(id.String()); ok {
+			destroyActor(v.(*ActorComponent).Parent())
+		}
+	}()
+	if confirmed := proxy.confirmMigrated(inserted); !confirmed.Equal(id) {
+		t.Fatalf("expected the inserted actor %v, got %v", id, confirmed)
+	}
+}
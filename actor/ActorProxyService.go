@@ -16,6 +16,31 @@ func (this *ActorProxyService) Tell(args *ActorRpcMessageInfo, reply *ActorMessa
 	return this.proxy.Emit(args.Target, minfo)
 }
 
+//远程Ask，单向调用，消息投递后立即返回，回复通过AskReply发回
+func (this *ActorProxyService) Ask(args *ActorAskArgs, reply *bool) error {
+	this.proxy.answerAsk(args)
+	*reply = true
+	return nil
+}
+
+//远程Ask的回复，单向调用
+func (this *ActorProxyService) AskReply(args *ActorAskReply, reply *bool) error {
+	this.proxy.completeAsk(args)
+	*reply = true
+	return nil
+}
+
+//发布到本节点的订阅者，单向调用
+func (this *ActorProxyService) Publish(args *ActorTopicMessage, reply *bool) error {
+	var sender IActor
+	if args.Sender != nil {
+		sender = NewActor(args.Sender, this.proxy)
+	}
+	go this.proxy.publishLocal(sender, args.Topic, args.Message)
+	*reply = true
+	return nil
+}
+
 func (this *ActorProxyService) ServiceInquiry(service string, reply *ActorID) error {
 	r, err := this.proxy.GetLocalActorService(service)
 	if err != nil {
@@ -24,3 +49,31 @@ func (this *ActorProxyService) ServiceInquiry(service string, reply *ActorID) er
 	*reply = r.actor.ID()
 	return nil
 }
+
+//在本节点激活grain，返回激活地址
+func (this *ActorProxyService) ActivateGrain(key string, reply *ActorID) error {
+	ctx, cancel := this.proxy.timeoutContext()
+	defer cancel()
+	id, err := this.proxy.activateGrain(ctx, key)
+	if err != nil {
+		return err
+	}
+	*reply = id
+	return nil
+}
+
+//迁入actor，返回新地址
+func (this *ActorProxyService) Migrate(args *ActorMigrateArgs, reply *ActorID) error {
+	id, err := this.proxy.insertMigrated(args)
+	if err != nil {
+		return err
+	}
+	*reply = id
+	return nil
+}
+
+//确认迁移结果，返回新地址，未插入时为空且之后不再插入
+func (this *ActorProxyService) ConfirmMigrate(id string, reply *ActorID) error {
+	*reply = this.proxy.confirmMigrated(id)
+	return nil
+}
@@ -1,5 +1,7 @@
 package Actor
 
+import "context"
+
 type ActorService struct {
 	actor   IActor
 	Service string
@@ -10,9 +12,13 @@ func NewActorService(actor IActor, service string) *ActorService {
 }
 
 func (this *ActorService) Call(args ...interface{}) ([]interface{}, error) {
+	return this.CallContext(context.Background(), args...)
+}
+
+func (this *ActorService) CallContext(ctx context.Context, args ...interface{}) ([]interface{}, error) {
 	mes := NewActorMessage(this.Service, args...)
 	reply := &ActorMessage{}
-	err := this.actor.Tell(nil, mes, &reply)
+	err := this.actor.TellContext(ctx, nil, mes, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package Actor
 
 import (
+	"context"
 	"github.com/zllangct/rockgo/network"
 	"sync"
 )
@@ -30,11 +31,15 @@ func NewActorServiceCallerFromSession(sess *network.Session, proxy *ActorProxyCo
 }
 
 func (this *ActorServiceCaller) Call(role string, serviceName string, args ...interface{}) ([]interface{}, error) {
+	return this.CallContext(context.Background(), role, serviceName, args...)
+}
+
+func (this *ActorServiceCaller) CallContext(ctx context.Context, role string, serviceName string, args ...interface{}) ([]interface{}, error) {
 	var err error
 	//优先尝试缓存客户端，避免反复查询，尽量去中心化
 	service, ok := this.services[serviceName]
 	if ok {
-		res, err := service.Call(args...)
+		res, err := service.CallContext(ctx, args...)
 		if err != nil {
 			delete(this.services, serviceName)
 		} else {
@@ -42,12 +47,12 @@ func (this *ActorServiceCaller) Call(role string, serviceName string, args ...in
 		}
 	}
 	//无缓存，或者通过缓存调用失败，重新查询调用
-	service, err = this.proxy.GetActorService(role, serviceName)
+	service, err = this.proxy.GetActorServiceContext(ctx, role, serviceName)
 	if err != nil {
 		return nil, err
 	}
 	this.services[serviceName] = service
-	res, err := service.Call(args...)
+	res, err := service.CallContext(ctx, args...)
 	if err != nil {
 		delete(this.services, serviceName)
 	}
@@ -1,22 +1,22 @@
 package Actor
 
 import (
+	"context"
 	"errors"
 	"github.com/zllangct/rockgo/network"
 	"sync"
 )
 
-
 type ActorWithSession struct {
-	locker   sync.RWMutex
-	actorID 	     ActorID
-	proxy    *ActorProxyComponent
-	session  *network.Session
-	api      network.NetAPI
+	locker  sync.RWMutex
+	actorID ActorID
+	proxy   *ActorProxyComponent
+	session *network.Session
+	api     network.NetAPI
 }
 
 func NewActorWithSession(proxy *ActorProxyComponent, sess *network.Session) (*ActorWithSession, error) {
-	actor := &ActorWithSession{ actorID: EmptyActorID(), proxy: proxy, session: sess}
+	actor := &ActorWithSession{actorID: EmptyActorID(), proxy: proxy, session: sess}
 	err := proxy.Register(actor)
 	if err != nil {
 		return nil, err
@@ -32,7 +32,11 @@ func (this *ActorWithSession) ID() ActorID {
 	return this.actorID
 }
 
-func (this *ActorWithSession) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error{
+func (this *ActorWithSession) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	return this.TellContext(context.Background(), sender, message, reply...)
+}
+
+func (this *ActorWithSession) TellContext(ctx context.Context, sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
 	if len(message.Data) == 0 {
 		return errors.New("invalid message")
 	}
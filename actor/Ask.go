@@ -0,0 +1,206 @@
+package Actor
+
+import (
+	"context"
+	"fmt"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/rpc"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	远程Ask
+	发起方登记请求ID后单向发送ActorProxyService.Ask，不等待rpc回复；目标节点将消息投递到actor邮箱后立即返回，
+	处理者回复时再单向发送ActorProxyService.AskReply给发起方，按请求ID完成Future。
+	两端都不为等待回复占用rpc goroutine。
+*/
+
+type ActorAskArgs struct {
+	Target    ActorID
+	Sender    ActorID
+	Message   *ActorMessage
+	RequestID uint64
+	ReplyTo   string //发起方节点地址
+	Timeout   int64  //发送时的剩余时间(纳秒)，0表示无截止时间
+}
+
+type ActorAskReply struct {
+	RequestID uint64
+	Message   *ActorMessage
+	Error     string
+}
+
+//向target发送消息，返回回复的Future；ctx无截止时间时使用RpcCallTimeout
+func Ask(ctx context.Context, target IActor, message *ActorMessage) *Future {
+	return ask(ctx, nil, target, message)
+}
+
+//以该actor为发送方Ask
+func (this *ActorComponent) Ask(ctx context.Context, target IActor, message *ActorMessage) *Future {
+	return ask(ctx, this, target, message)
+}
+
+func ask(ctx context.Context, sender IActor, target IActor, message *ActorMessage) *Future {
+	switch t := target.(type) {
+	case *ActorComponent:
+		return t.Proxy.ask(ctx, sender, t.ID(), message)
+	case *Actor:
+		return t.proxy.ask(ctx, sender, t.actorID, message)
+	case *Grain:
+		return t.ask(ctx, sender, message)
+	case *Singleton:
+		return t.ask(ctx, sender, message)
+	}
+	//其他actor只支持同步Tell，在新goroutine中等待回复
+	f := newFuture()
+	go func() {
+		reply := &ActorMessage{}
+		if err := target.TellContext(ctx, sender, message, &reply); err != nil {
+			f.complete(nil, err)
+			return
+		}
+		f.complete(reply, nil)
+	}()
+	return f
+}
+
+//发送Ask，Future在回复、出错或ctx结束时完成
+func (this *ActorProxyComponent) ask(ctx context.Context, sender IActor, target ActorID, message *ActorMessage) *Future {
+	f := newFuture()
+	var cancel context.CancelFunc
+	_, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.Config.ClusterConfig.RpcCallTimeout)*time.Millisecond)
+	}
+	go func() {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			err := ctx.Err()
+			if !hasDeadline && err == context.DeadlineExceeded {
+				err = ErrTimeout
+			}
+			f.complete(nil, err)
+		}
+		cancel()
+	}()
+	this.askTo(ctx, f, sender, target, message)
+	return f
+}
+
+func (this *ActorProxyComponent) askTo(ctx context.Context, f *Future, sender IActor, target ActorID, message *ActorMessage) {
+	nodeID := target.GetNodeID()
+	if nodeID == this.nodeID {
+		if to, ok := this.forward(ctx, target); ok {
+			this.askTo(ctx, f, sender, to, message)
+			return
+		}
+		v, ok := this.localActors.Load(target.String())
+		if !ok {
+			this.deadLetter(target, sender, message, DEAD_LETTER_NO_ACTOR, ErrNoThisActor)
+			f.complete(nil, ErrNoThisActor)
+			return
+		}
+		actor, ok := v.(*ActorComponent)
+		if !ok {
+			//非ActorComponent的本地actor
+			ask(ctx, sender, v.(IActor), message).OnComplete(func(reply *ActorMessage, err error) {
+				f.complete(reply, err)
+			})
+			return
+		}
+		messageInfo := &ActorMessageInfo{
+			Sender:  sender,
+			Message: message,
+			ctx:     ctx,
+			onReply: func(reply *ActorMessage, err error) {
+				f.complete(reply, err)
+			},
+		}
+		if err := actor.receive(ctx, messageInfo); err != nil {
+			f.complete(nil, err)
+		}
+		return
+	}
+
+	client, err := this.nodeComponent.GetNodeClient(nodeID)
+	if err != nil {
+		this.deadLetter(target, sender, message, DEAD_LETTER_UNREACHABLE, err)
+		f.complete(nil, err)
+		return
+	}
+	id := atomic.AddUint64(&this.askSeq, 1)
+	this.asks.Store(id, f)
+	f.OnComplete(func(reply *ActorMessage, err error) {
+		this.asks.Delete(id)
+	})
+	args := &ActorAskArgs{
+		Target:    target,
+		Message:   message,
+		RequestID: id,
+		ReplyTo:   this.nodeID,
+	}
+	if sender != nil {
+		args.Sender = sender.ID()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		args.Timeout = int64(time.Until(deadline))
+		if args.Timeout <= 0 {
+			//已经过期，目标节点收到后立即超时
+			args.Timeout = 1
+		}
+	}
+	//单向发送，写入失败时立即返回错误
+	if call := client.Go("ActorProxyService.Ask", args, nil, nil); call.Error != nil {
+		this.deadLetter(target, sender, message, DEAD_LETTER_UNREACHABLE, call.Error)
+		f.complete(nil, call.Error)
+	}
+}
+
+//处理远程Ask，按发起方的剩余时间设置截止时间，回复时单向发送给发起方
+func (this *ActorProxyComponent) answerAsk(args *ActorAskArgs) {
+	var sender IActor
+	if args.Sender != nil {
+		sender = NewActor(args.Sender, this)
+	}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if args.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(args.Timeout))
+	}
+	this.ask(ctx, sender, args.Target, args.Message).OnComplete(func(reply *ActorMessage, err error) {
+		cancel()
+		answer := &ActorAskReply{RequestID: args.RequestID, Message: reply}
+		if err != nil {
+			answer.Error = err.Error()
+		}
+		//获取连接可能需要拨号，不阻塞处理者
+		go func() {
+			client, e := this.nodeComponent.GetNodeClient(args.ReplyTo)
+			if e == nil {
+				e = client.Go("ActorProxyService.AskReply", answer, nil, nil).Error
+			}
+			if e != nil {
+				logger.Warn(fmt.Sprintf("reply ask [ %d ] to [ %s ] failed: %s", args.RequestID, args.ReplyTo, e.Error()))
+			}
+		}()
+	})
+}
+
+//远程Ask的回复到达
+func (this *ActorProxyComponent) completeAsk(answer *ActorAskReply) {
+	v, ok := this.asks.Load(answer.RequestID)
+	if !ok {
+		//已超时
+		return
+	}
+	var err error
+	if answer.Error != "" {
+		//与Tell一致，远端返回的错误为rpc.ServerError
+		err = rpc.ServerError(answer.Error)
+	}
+	v.(*Future).complete(answer.Message, err)
+}
@@ -0,0 +1,121 @@
+package Actor
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+/*
+	行为切换与消息暂存
+	Become切换actor当前的消息处理集合，之后的消息只交给该集合处理，集合之外的服务以ErrUnhandledService回复并记录为死信。
+	Unbecome恢复上一个行为，
+	行为栈为空时恢复默认行为：分发给实体上所有IActorMessageHandler组件。
+	处理者可用Stash暂存当前消息，暂存的消息不会被回复；UnstashAll将暂存的消息按原顺序放回，
+	先于邮箱中的消息处理。用于加载中等过渡状态，例如：
+		加载时 Become(loading)，loading中对所有消息Stash；加载完成后 Unbecome() 并 UnstashAll()。
+*/
+
+var ErrStashDiscarded = errors.New("stashed message discarded")
+var ErrUnhandledService = errors.New("service is not handled by the current behavior")
+
+type actorBehavior = map[string]func(message *ActorMessageInfo) error
+
+//切换为新行为，替换当前行为
+func (this *ActorComponent) Become(handlers map[string]func(message *ActorMessageInfo) error) {
+	this.behaviorLocker.Lock()
+	defer this.behaviorLocker.Unlock()
+	if n := len(this.behaviors); n > 0 {
+		this.behaviors[n-1] = handlers
+		return
+	}
+	this.behaviors = append(this.behaviors, handlers)
+}
+
+//切换为新行为，保留当前行为，Unbecome时恢复
+func (this *ActorComponent) BecomeStacked(handlers map[string]func(message *ActorMessageInfo) error) {
+	this.behaviorLocker.Lock()
+	this.behaviors = append(this.behaviors, handlers)
+	this.behaviorLocker.Unlock()
+}
+
+//恢复上一个行为
+func (this *ActorComponent) Unbecome() {
+	this.behaviorLocker.Lock()
+	if n := len(this.behaviors); n > 0 {
+		this.behaviors[n-1] = nil
+		this.behaviors = this.behaviors[:n-1]
+	}
+	this.behaviorLocker.Unlock()
+}
+
+//当前行为，默认行为时为nil
+func (this *ActorComponent) behavior() actorBehavior {
+	this.behaviorLocker.Lock()
+	defer this.behaviorLocker.Unlock()
+	if n := len(this.behaviors); n > 0 {
+		return this.behaviors[n-1]
+	}
+	return nil
+}
+
+//暂存消息，只能在处理该消息时调用
+func (this *ActorComponent) Stash(message *ActorMessageInfo) {
+	this.behaviorLocker.Lock()
+	message.stashed = true
+	this.stash = append(this.stash, message)
+	this.behaviorLocker.Unlock()
+}
+
+//将暂存的消息按原顺序放回，先于邮箱中的消息处理
+func (this *ActorComponent) UnstashAll() {
+	this.behaviorLocker.Lock()
+	if len(this.stash) == 0 {
+		this.behaviorLocker.Unlock()
+		return
+	}
+	for _, message := range this.stash {
+		message.stashed = false
+	}
+	atomic.AddInt32(&this.pending, int32(len(this.stash)))
+	this.unstashed = append(this.stash, this.unstashed...)
+	this.stash = nil
+	this.behaviorLocker.Unlock()
+	//唤醒dispatch
+	select {
+	case this.wake <- struct{}{}:
+	default:
+	}
+}
+
+//暂存的消息数
+func (this *ActorComponent) StashSize() int {
+	this.behaviorLocker.Lock()
+	defer this.behaviorLocker.Unlock()
+	return len(this.stash)
+}
+
+//取出下一条放回的消息
+func (this *ActorComponent) popUnstashed() *ActorMessageInfo {
+	this.behaviorLocker.Lock()
+	defer this.behaviorLocker.Unlock()
+	if len(this.unstashed) == 0 {
+		return nil
+	}
+	message := this.unstashed[0]
+	this.unstashed[0] = nil
+	this.unstashed = this.unstashed[1:]
+	return message
+}
+
+//丢弃暂存的消息，actor销毁时调用
+func (this *ActorComponent) discardStash() {
+	this.behaviorLocker.Lock()
+	stash := this.stash
+	this.stash = nil
+	this.behaviorLocker.Unlock()
+	for _, message := range stash {
+		message.stashed = false
+		this.Proxy.deadLetter(this.ActorID, message.Sender, message.Message, DEAD_LETTER_STASH_DISCARDED, ErrStashDiscarded)
+		message.replyError(ErrStashDiscarded)
+	}
+}
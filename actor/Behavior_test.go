@@ -0,0 +1,155 @@
+package Actor
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//以name回复Hello的行为
+func replyWith(name string) map[string]func(message *ActorMessageInfo) error {
+	return map[string]func(message *ActorMessageInfo) error{
+		"Hello": func(message *ActorMessageInfo) error {
+			return message.Reply(name)
+		},
+	}
+}
+
+func hello(t *testing.T, actor IActor, service string) (string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	reply, err := Ask(ctx, actor, NewActorMessage(service)).Wait(ctx)
+	if err != nil {
+		return "", err
+	}
+	return reply.Data[0].(string), nil
+}
+
+func TestBecome(t *testing.T) {
+	proxy := testNode(t)
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	handlers := replyWith("default")
+	handlers["Other"] = handlers["Hello"]
+	defer destroyActor(newTestActor(t, proxy, actor, handlers))
+
+	expect := func(expected string) {
+		t.Helper()
+		if name, err := hello(t, actor, "Hello"); err != nil || name != expected {
+			t.Fatalf("expected the %s behavior, got %q %v", expected, name, err)
+		}
+	}
+	expect("default")
+	actor.Become(replyWith("a"))
+	expect("a")
+	//当前行为之外的服务不再交给默认处理者，以错误回复并记录为死信
+	before := proxy.DeadLetterCounts()[DEAD_LETTER_UNHANDLED]
+	if _, err := hello(t, actor, "Other"); err != ErrUnhandledService {
+		t.Fatalf("services outside the behavior should not be handled, got %v", err)
+	}
+	if n := proxy.DeadLetterCounts()[DEAD_LETTER_UNHANDLED] - before; n != 1 {
+		t.Fatalf("expected one more unhandled letter, got %d", n)
+	}
+
+	//Become替换当前行为，BecomeStacked保留当前行为
+	actor.Become(replyWith("b"))
+	expect("b")
+	actor.BecomeStacked(replyWith("c"))
+	expect("c")
+	actor.Unbecome()
+	expect("b")
+	actor.Unbecome()
+	expect("default")
+	actor.Unbecome()
+	expect("default")
+}
+
+func TestStashOrder(t *testing.T) {
+	proxy := testNode(t)
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	locker := sync.Mutex{}
+	var handled []string
+	ready := map[string]func(message *ActorMessageInfo) error{
+		"Msg": func(message *ActorMessageInfo) error {
+			locker.Lock()
+			handled = append(handled, message.Message.Data[0].(string))
+			locker.Unlock()
+			return message.Reply("handled")
+		},
+	}
+	defer destroyActor(newTestActor(t, proxy, actor, ready))
+	//加载中暂存所有消息，加载完成后按原顺序放回
+	actor.Become(map[string]func(message *ActorMessageInfo) error{
+		"Msg": func(message *ActorMessageInfo) error {
+			actor.Stash(message)
+			return nil
+		},
+		"Loaded": func(message *ActorMessageInfo) error {
+			actor.Unbecome()
+			actor.UnstashAll()
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	//暂存的消息在放回并处理后才回复
+	asked := Ask(ctx, actor, NewActorMessage("Msg", "1"))
+	for _, name := range []string{"2", "3"} {
+		if err := actor.Tell(nil, NewActorMessage("Msg", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, time.Second, "the messages are stashed", func() bool {
+		return actor.StashSize() == 3
+	})
+	select {
+	case <-asked.Done():
+		t.Fatal("stashed messages should not be replied")
+	default:
+	}
+
+	for _, message := range []*ActorMessage{NewActorMessage("Loaded"), NewActorMessage("Msg", "4")} {
+		if err := actor.Tell(nil, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply, err := asked.Wait(ctx); err != nil || reply.Data[0] != "handled" {
+		t.Fatalf("expected the stashed message to be replied after unstashing, got %v %v", reply, err)
+	}
+	waitFor(t, time.Second, "all messages are handled", func() bool {
+		locker.Lock()
+		defer locker.Unlock()
+		return len(handled) == 4
+	})
+	if !reflect.DeepEqual(handled, []string{"1", "2", "3", "4"}) {
+		t.Fatalf("unstashed messages should be handled first in order, got %v", handled)
+	}
+	if n := actor.StashSize(); n != 0 {
+		t.Fatalf("expected an empty stash, got %d", n)
+	}
+}
+
+func TestStashDiscarded(t *testing.T) {
+	proxy := testNode(t)
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	object := newTestActor(t, proxy, actor, map[string]func(message *ActorMessageInfo) error{
+		"Msg": func(message *ActorMessageInfo) error {
+			actor.Stash(message)
+			return nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	asked := Ask(ctx, actor, NewActorMessage("Msg"))
+	waitFor(t, time.Second, "the message is stashed", func() bool {
+		return actor.StashSize() == 1
+	})
+	//actor销毁时暂存的消息以ErrStashDiscarded回复
+	destroyActor(object)
+	if _, err := asked.Wait(ctx); err != ErrStashDiscarded {
+		t.Fatalf("expected ErrStashDiscarded, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package Actor
+
+import (
+	"context"
+	"fmt"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/rpc"
+	"sync"
+	"time"
+)
+
+/*
+	死信
+	无法投递的消息记录为死信：目标不存在、actor已失活、邮箱溢出、暂存被丢弃、当前行为不处理、目标节点不可达。
+	死信记录在消息丢失的节点上，远程目标不存在时记录在目标节点而非发送方节点。
+	最近的死信保存在有界环形缓冲区中供查看，并按原因计数；订阅者在记录时同步调用，不应阻塞。
+*/
+
+const (
+	DEAD_LETTER_NO_ACTOR        = "no_actor"        //目标actor不存在
+	DEAD_LETTER_INACTIVE        = "inactive"        //目标actor已失活或销毁
+	DEAD_LETTER_MAILBOX_FULL    = "mailbox_full"    //邮箱溢出被拒绝或丢弃
+	DEAD_LETTER_STASH_DISCARDED = "stash_discarded" //actor销毁时暂存的消息被丢弃
+	DEAD_LETTER_UNHANDLED       = "unhandled"       //当前行为不处理该服务
+	DEAD_LETTER_UNREACHABLE     = "unreachable"     //目标节点不可达
+)
+
+// DeadLetter is a message that could not be delivered to its target.
+type DeadLetter struct {
+	Target  ActorID
+	Sender  ActorID
+	Service string
+	Reason  string
+	Error   string
+	Time    int64 //纳秒
+}
+
+type deadLetterBox struct {
+	locker      sync.Mutex
+	ring        []*DeadLetter
+	next        int
+	counts      map[string]int64
+	subscribers map[uint64]func(letter *DeadLetter)
+	seq         uint64
+}
+
+//订阅死信，返回取消订阅的函数
+func (this *ActorProxyComponent) SubscribeDeadLetters(subscriber func(letter *DeadLetter)) (cancel func()) {
+	box := &this.deadLetters
+	box.locker.Lock()
+	defer box.locker.Unlock()
+	if box.subscribers == nil {
+		box.subscribers = make(map[uint64]func(letter *DeadLetter))
+	}
+	box.seq++
+	id := box.seq
+	box.subscribers[id] = subscriber
+	return func() {
+		box.locker.Lock()
+		delete(box.subscribers, id)
+		box.locker.Unlock()
+	}
+}
+
+//最近的死信，按时间从旧到新
+func (this *ActorProxyComponent) DeadLetters() []*DeadLetter {
+	box := &this.deadLetters
+	box.locker.Lock()
+	defer box.locker.Unlock()
+	letters := make([]*DeadLetter, 0, len(box.ring))
+	for i := 0; i < len(box.ring); i++ {
+		if letter := box.ring[(box.next+i)%len(box.ring)]; letter != nil {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+//按原因统计的死信数
+func (this *ActorProxyComponent) DeadLetterCounts() map[string]int64 {
+	box := &this.deadLetters
+	box.locker.Lock()
+	defer box.locker.Unlock()
+	counts := make(map[string]int64, len(box.counts))
+	for reason, count := range box.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+//记录死信
+func (this *ActorProxyComponent) deadLetter(target ActorID, sender IActor, message *ActorMessage, reason string, err error) {
+	if this == nil {
+		return
+	}
+	letter := &DeadLetter{
+		Target: target,
+		Reason: reason,
+		Time:   time.Now().UnixNano(),
+	}
+	if sender != nil {
+		letter.Sender = sender.ID()
+	}
+	if message != nil {
+		letter.Service = message.Service
+	}
+	if err != nil {
+		letter.Error = err.Error()
+	}
+	logger.Debug(fmt.Sprintf("dead letter: [ %s ] from [ %s ] to [ %s ], %s", letter.Service, letter.Sender.String(), target.String(), reason))
+
+	box := &this.deadLetters
+	box.locker.Lock()
+	if box.ring == nil {
+		size := config.Config.ClusterConfig.DeadLetterBufferSize
+		if size <= 0 {
+			size = 256
+		}
+		box.ring = make([]*DeadLetter, size)
+		box.counts = make(map[string]int64)
+	}
+	box.ring[box.next] = letter
+	box.next = (box.next + 1) % len(box.ring)
+	box.counts[reason]++
+	subscribers := make([]func(letter *DeadLetter), 0, len(box.subscribers))
+	for _, subscriber := range box.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	box.locker.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(letter)
+	}
+}
+
+//投递到本地actor失败的原因，不是投递失败时返回空
+func deadLetterReason(err error) string {
+	switch err {
+	case ErrNoThisActor:
+		return DEAD_LETTER_NO_ACTOR
+	case ErrActorInactive:
+		return DEAD_LETTER_INACTIVE
+	case ErrMailboxFull:
+		return DEAD_LETTER_MAILBOX_FULL
+	}
+	return ""
+}
+
+//远程调用失败是否为节点不可达，远端返回的错误由远端记录，超时无法确定是否已投递
+func isUnreachable(err error) bool {
+	if err == nil || err == rpc.ErrTimeout || err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	_, remote := err.(rpc.ServerError)
+	return !remote
+}
@@ -0,0 +1,108 @@
+package Actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/config"
+	"testing"
+	"time"
+)
+
+//环形缓冲区大小为size的代理，只用于记录死信
+func newDeadLetterProxy(t *testing.T, size int) *ActorProxyComponent {
+	testNode(t)
+	conf := config.Config.ClusterConfig
+	old := conf.DeadLetterBufferSize
+	conf.DeadLetterBufferSize = size
+	proxy := &ActorProxyComponent{}
+	//缓冲区在记录第一条死信时按配置创建
+	proxy.deadLetter(EmptyActorID(), nil, NewActorMessage("init"), DEAD_LETTER_NO_ACTOR, nil)
+	conf.DeadLetterBufferSize = old
+	return proxy
+}
+
+func TestDeadLetterRing(t *testing.T) {
+	proxy := newDeadLetterProxy(t, 3)
+	reasons := []string{DEAD_LETTER_INACTIVE, DEAD_LETTER_MAILBOX_FULL, DEAD_LETTER_MAILBOX_FULL, DEAD_LETTER_UNREACHABLE, DEAD_LETTER_MAILBOX_FULL}
+	for i, reason := range reasons {
+		proxy.deadLetter(EmptyActorID(), nil, NewActorMessage(fmt.Sprintf("s%d", i)), reason, errors.New(reason))
+	}
+
+	//只保留最近的死信，从旧到新
+	letters := proxy.DeadLetters()
+	if len(letters) != 3 {
+		t.Fatalf("expected the 3 latest letters, got %d", len(letters))
+	}
+	for i, letter := range letters {
+		if expected := fmt.Sprintf("s%d", i+2); letter.Service != expected || letter.Reason != reasons[i+2] || letter.Error != reasons[i+2] {
+			t.Errorf("letter %d: expected %s, got %+v", i, expected, letter)
+		}
+	}
+
+	//计数不受缓冲区大小影响
+	counts := proxy.DeadLetterCounts()
+	expected := map[string]int64{DEAD_LETTER_NO_ACTOR: 1, DEAD_LETTER_INACTIVE: 1, DEAD_LETTER_MAILBOX_FULL: 3, DEAD_LETTER_UNREACHABLE: 1}
+	for reason, n := range expected {
+		if counts[reason] != n {
+			t.Errorf("expected %d %s letters, got %d", n, reason, counts[reason])
+		}
+	}
+	counts[DEAD_LETTER_NO_ACTOR] = 100
+	if proxy.DeadLetterCounts()[DEAD_LETTER_NO_ACTOR] != 1 {
+		t.Fatal("counts should be returned as a copy")
+	}
+}
+
+func TestDeadLetterSubscribers(t *testing.T) {
+	proxy := newDeadLetterProxy(t, 3)
+	var first, second []string
+	cancelFirst := proxy.SubscribeDeadLetters(func(letter *DeadLetter) { first = append(first, letter.Service) })
+	cancelSecond := proxy.SubscribeDeadLetters(func(letter *DeadLetter) { second = append(second, letter.Service) })
+	defer cancelSecond()
+
+	proxy.deadLetter(EmptyActorID(), nil, NewActorMessage("a"), DEAD_LETTER_NO_ACTOR, nil)
+	//取消订阅后不再收到，可重复取消
+	cancelFirst()
+	cancelFirst()
+	proxy.deadLetter(EmptyActorID(), nil, NewActorMessage("b"), DEAD_LETTER_NO_ACTOR, nil)
+	if len(first) != 1 || first[0] != "a" {
+		t.Fatalf("expected the letter before cancelling, got %v", first)
+	}
+	if len(second) != 2 || second[0] != "a" || second[1] != "b" {
+		t.Fatalf("expected both letters, got %v", second)
+	}
+}
+
+func TestDeadLetterNoActor(t *testing.T) {
+	proxy := testNode(t)
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	defer destroyActor(newTestActor(t, proxy, actor, nil))
+	gone := actor.ID()
+	gone[2] = "gone"
+	letters := make(chan *DeadLetter, 10)
+	cancel := proxy.SubscribeDeadLetters(func(letter *DeadLetter) {
+		if letter.Target.Equal(gone) {
+			letters <- letter
+		}
+	})
+	defer cancel()
+	before := proxy.DeadLetterCounts()[DEAD_LETTER_NO_ACTOR]
+
+	ctx, cancelAsk := context.WithTimeout(context.Background(), time.Second)
+	defer cancelAsk()
+	if _, err := actor.Ask(ctx, NewActor(gone, proxy), NewActorMessage("Lost")).Wait(ctx); err != ErrNoThisActor {
+		t.Fatalf("expected ErrNoThisActor, got %v", err)
+	}
+	select {
+	case letter := <-letters:
+		if letter.Reason != DEAD_LETTER_NO_ACTOR || letter.Service != "Lost" || !letter.Sender.Equal(actor.ID()) {
+			t.Fatalf("unexpected dead letter %+v", letter)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the dead letter is not published to subscribers")
+	}
+	if n := proxy.DeadLetterCounts()[DEAD_LETTER_NO_ACTOR] - before; n != 1 {
+		t.Fatalf("expected one more no_actor letter, got %d", n)
+	}
+}
@@ -0,0 +1,157 @@
+package Actor
+
+import (
+	"context"
+	"github.com/ugorji/go/codec"
+	"sync"
+)
+
+/*
+	Ask/Future
+	Ask发送消息后立即返回Future，不阻塞调用方；回复到达、出错或ctx结束时Future完成。
+	Future可等待(Wait/Await)、注册回调(OnComplete/Then)或组合(WhenAll/WhenAny)。
+	类型化数据以msgpack编码在ActorMessage.Payload中：发送方用NewTypedActorMessage，处理者用
+	ActorMessage.Decode读取并用ActorMessageInfo.ReplyTyped回复，发送方用Future.Await解码回复。
+*/
+
+var payloadHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+func encodePayload(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, payloadHandle).Encode(v)
+	return data, err
+}
+
+// Future is the pending reply of an Ask.
+type Future struct {
+	locker    sync.Mutex
+	done      chan struct{}
+	reply     *ActorMessage
+	err       error
+	callbacks []func(reply *ActorMessage, err error)
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+//已完成的Future
+func completedFuture(reply *ActorMessage, err error) *Future {
+	f := newFuture()
+	f.complete(reply, err)
+	return f
+}
+
+//完成Future，只有第一次有效
+func (this *Future) complete(reply *ActorMessage, err error) bool {
+	this.locker.Lock()
+	select {
+	case <-this.done:
+		this.locker.Unlock()
+		return false
+	default:
+	}
+	this.reply, this.err = reply, err
+	callbacks := this.callbacks
+	this.callbacks = nil
+	close(this.done)
+	this.locker.Unlock()
+	for _, callback := range callbacks {
+		callback(reply, err)
+	}
+	return true
+}
+
+//完成时关闭
+func (this *Future) Done() <-chan struct{} {
+	return this.done
+}
+
+//等待回复
+func (this *Future) Wait(ctx context.Context) (*ActorMessage, error) {
+	select {
+	case <-this.done:
+		return this.reply, this.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//等待回复并将类型化数据解码到out
+func (this *Future) Await(ctx context.Context, out interface{}) error {
+	reply, err := this.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	return reply.Decode(out)
+}
+
+//完成时回调，已完成时立即回调；回调在完成Future的goroutine中执行，不应阻塞
+func (this *Future) OnComplete(callback func(reply *ActorMessage, err error)) {
+	this.locker.Lock()
+	select {
+	case <-this.done:
+		this.locker.Unlock()
+		callback(this.reply, this.err)
+		return
+	default:
+	}
+	this.callbacks = append(this.callbacks, callback)
+	this.locker.Unlock()
+}
+
+//成功时以回复调用fn，返回fn结果的Future；失败时直接传递错误
+func (this *Future) Then(fn func(reply *ActorMessage) (*ActorMessage, error)) *Future {
+	next := newFuture()
+	this.OnComplete(func(reply *ActorMessage, err error) {
+		if err != nil {
+			next.complete(nil, err)
+			return
+		}
+		next.complete(fn(reply))
+	})
+	return next
+}
+
+//全部完成时完成，有失败时以第一个错误完成，回复从各Future读取
+func WhenAll(futures ...*Future) *Future {
+	all := newFuture()
+	if len(futures) == 0 {
+		all.complete(nil, nil)
+		return all
+	}
+	locker := sync.Mutex{}
+	remain := len(futures)
+	for _, f := range futures {
+		f.OnComplete(func(reply *ActorMessage, err error) {
+			if err != nil {
+				all.complete(nil, err)
+				return
+			}
+			locker.Lock()
+			remain--
+			finished := remain == 0
+			locker.Unlock()
+			if finished {
+				all.complete(nil, nil)
+			}
+		})
+	}
+	return all
+}
+
+//以第一个完成的Future的结果完成
+func WhenAny(futures ...*Future) *Future {
+	first := newFuture()
+	for _, f := range futures {
+		f.OnComplete(func(reply *ActorMessage, err error) {
+			first.complete(reply, err)
+		})
+	}
+	return first
+}
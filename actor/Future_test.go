@@ -0,0 +1,194 @@
+package Actor
+
+import (
+	"context"
+	"errors"
+	"github.com/zllangct/rockgo/rpc"
+	"testing"
+	"time"
+)
+
+type testPoint struct {
+	X, Y int
+}
+
+func TestFutureComplete(t *testing.T) {
+	f := newFuture()
+	var calls int
+	f.OnComplete(func(reply *ActorMessage, err error) { calls++ })
+	if !f.complete(NewActorMessage("first"), nil) {
+		t.Fatal("the first completion should take effect")
+	}
+	if f.complete(nil, errors.New("second")) {
+		t.Fatal("a completed future can not be completed again")
+	}
+	reply, err := f.Wait(context.Background())
+	if err != nil || reply.Service != "first" {
+		t.Fatalf("expected the first result, got %v %v", reply, err)
+	}
+	//完成后注册的回调立即执行
+	f.OnComplete(func(reply *ActorMessage, err error) { calls++ })
+	if calls != 2 {
+		t.Fatalf("expected 2 callbacks, got %d", calls)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := newFuture().Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to end with ctx, got %v", err)
+	}
+}
+
+func TestFutureCompose(t *testing.T) {
+	failed := errors.New("failed")
+	//Then成功时传递回复，失败时跳过fn
+	next := completedFuture(NewActorMessage("a"), nil).Then(func(reply *ActorMessage) (*ActorMessage, error) {
+		return NewActorMessage(reply.Service + "b"), nil
+	})
+	if reply, err := next.Wait(context.Background()); err != nil || reply.Service != "ab" {
+		t.Fatalf("expected the chained reply, got %v %v", reply, err)
+	}
+	skipped := completedFuture(nil, failed).Then(func(reply *ActorMessage) (*ActorMessage, error) {
+		t.Fatal("fn should not be called after a failure")
+		return nil, nil
+	})
+	if _, err := skipped.Wait(context.Background()); err != failed {
+		t.Fatalf("expected the error to be passed on, got %v", err)
+	}
+
+	pending := newFuture()
+	all := WhenAll(completedFuture(nil, nil), pending)
+	select {
+	case <-all.Done():
+		t.Fatal("WhenAll should wait for every future")
+	default:
+	}
+	pending.complete(nil, nil)
+	if _, err := all.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WhenAll(newFuture(), completedFuture(nil, failed)).Wait(context.Background()); err != failed {
+		t.Fatalf("expected WhenAll to fail with the first error, got %v", err)
+	}
+
+	if reply, _ := WhenAny(newFuture(), completedFuture(NewActorMessage("any"), nil)).Wait(context.Background()); reply == nil || reply.Service != "any" {
+		t.Fatalf("expected the first completed reply, got %v", reply)
+	}
+}
+
+func TestAsk(t *testing.T) {
+	proxy := testNode(t)
+	actor := NewActorComponent(ACTOR_TYPE_ASYNC)
+	object := newTestActor(t, proxy, actor, map[string]func(message *ActorMessageInfo) error{
+		"Echo": func(message *ActorMessageInfo) error {
+			return message.Reply(message.Message.Data...)
+		},
+		"Move": func(message *ActorMessageInfo) error {
+			p := testPoint{}
+			if err := message.Message.Decode(&p); err != nil {
+				return err
+			}
+			return message.ReplyTyped(testPoint{X: p.X + 1, Y: p.Y + 1})
+		},
+		"Fail": func(message *ActorMessageInfo) error {
+			return errors.New("failed")
+		},
+		"Slow": func(message *ActorMessageInfo) error {
+			time.Sleep(time.Millisecond * 100)
+			return message.Reply()
+		},
+	})
+	defer destroyActor(object)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	//按地址引用和actor组件发送结果一致
+	for _, target := range []IActor{actor, NewActor(actor.ID(), proxy)} {
+		reply, err := Ask(ctx, target, NewActorMessage("Echo", "hello")).Wait(ctx)
+		if err != nil || reply.Data[0] != "hello" {
+			t.Fatalf("expected the echo, got %v %v", reply, err)
+		}
+	}
+
+	message, err := NewTypedActorMessage("Move", testPoint{X: 1, Y: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testPoint{}
+	if err := Ask(ctx, actor, message).Await(ctx, &p); err != nil || p != (testPoint{X: 2, Y: 3}) {
+		t.Fatalf("expected the typed reply, got %v %v", p, err)
+	}
+
+	if _, err := Ask(ctx, actor, NewActorMessage("Fail")).Wait(ctx); err == nil || err.Error() != "failed" {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancelShort()
+	if _, err := Ask(short, actor, NewActorMessage("Slow")).Wait(context.Background()); err != context.DeadlineExceeded {
+		t.Fatalf("expected the ask to end with ctx, got %v", err)
+	}
+
+	gone := actor.ID()
+	gone[2] = "gone"
+	if _, err := Ask(ctx, NewActor(gone, proxy), NewActorMessage("Echo")).Wait(ctx); err != ErrNoThisActor {
+		t.Fatalf("expected ErrNoThisActor, got %v", err)
+	}
+}
+
+func TestAskRemoteReply(t *testing.T) {
+	proxy := testNode(t)
+	//不可达节点上的actor立即失败
+	dead := EmptyActorID()
+	dead, _ = dead.SetNodeID("127.0.0.1:1")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := Ask(ctx, NewActor(dead, proxy), NewActorMessage("Echo")).Wait(ctx); err == nil || err == context.DeadlineExceeded {
+		t.Fatalf("expected the unreachable error, got %v", err)
+	}
+
+	//远端回复按请求ID完成Future，远端错误为rpc.ServerError
+	f := newFuture()
+	proxy.asks.Store(uint64(1<<63), f)
+	proxy.completeAsk(&ActorAskReply{RequestID: 1 << 63, Error: "failed"})
+	if _, err := f.Wait(ctx); err != rpc.ServerError("failed") {
+		t.Fatalf("expected a server error, got %v", err)
+	}
+	proxy.asks.Delete(uint64(1 << 63))
+	//已超时的请求忽略迟到的回复
+	proxy.completeAsk(&ActorAskReply{RequestID: 1 << 63})
+}
+
+func TestAskRemoteDeadline(t *testing.T) {
+	proxy := testNode(t)
+	deadlines := make(chan time.Time, 1)
+	actor := NewActorComponent(ACTOR_TYPE_ASYNC)
+	defer destroyActor(newTestActor(t, proxy, actor, map[string]func(message *ActorMessageInfo) error{
+		"Deadline": func(message *ActorMessageInfo) error {
+			deadline, _ := message.Context().Deadline()
+			deadlines <- deadline
+			return message.Reply()
+		},
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	//目标节点按发起方的剩余时间处理，而不是使用默认超时
+	f := newFuture()
+	proxy.asks.Store(uint64(1<<62), f)
+	defer proxy.asks.Delete(uint64(1 << 62))
+	start := time.Now()
+	proxy.answerAsk(&ActorAskArgs{
+		Target:    actor.ID(),
+		Message:   NewActorMessage("Deadline"),
+		RequestID: 1 << 62,
+		ReplyTo:   proxy.nodeID,
+		Timeout:   int64(time.Millisecond * 200),
+	})
+	if _, err := f.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if deadline := <-deadlines; deadline.Before(start) || deadline.After(start.Add(time.Millisecond*300)) {
+		t.Fatalf("expected the caller's deadline, got %v after the request", deadline.Sub(start))
+	}
+}
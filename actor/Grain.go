@@ -0,0 +1,441 @@
+package Actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/cluster"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/logger"
+	"strings"
+	"time"
+)
+
+/*
+	虚拟actor(grain)
+	grain由 类型/ID 标识，例如 Player/12345，无需手动创建。第一次收到消息时由放置策略选择节点激活，
+	空闲超过GrainIdleTimeout后失活，状态保存到GrainStore，下次激活时恢复。
+	同一grain在集群中只有一个激活，激活地址登记在actor目录中。
+	grain实体的名字即为grain key，实体上的组件需实现ecs.IPersist才能保存状态。
+*/
+
+var ErrUnknownGrain = errors.New("unknown grain type")
+var ErrGrainKeyWrongFormat = errors.New("grain key should be : Type/ID")
+
+// GrainType describes a kind of virtual actor. It must be registered on every
+// node that sends to or hosts grains of this type.
+type GrainType struct {
+	Name       string                  //类型名，grain key的前缀
+	Role       string                  //承载该类型grain的节点角色
+	Selector   Cluster.SelectorType    //放置策略，默认按grain key一致性哈希
+	ActorType  ActorType               //actor类型
+	Components []ecs.ComponentProvider //激活时添加到实体上的组件
+}
+
+type grainActivation struct {
+	ready  chan struct{}
+	object *ecs.Object
+	actor  *ActorComponent
+	id     ActorID
+	err    error
+}
+
+func GrainKey(typ string, id string) string {
+	return typ + "/" + id
+}
+
+//grain在actor目录中的名字
+func grainName(key string) string {
+	return "grain:" + key
+}
+
+//注册grain类型，组件同时注册到ObjectFactory
+func (this *ActorProxyComponent) RegisterGrain(grain *GrainType) {
+	if grain.Selector == "" {
+		grain.Selector = Cluster.SELECTOR_TYPE_HASH
+	}
+	for _, provider := range grain.Components {
+		this.Runtime().Factory().Register(provider)
+	}
+	this.grainLocker.Lock()
+	if this.grainTypes == nil {
+		this.grainTypes = make(map[string]*GrainType)
+		this.grains = make(map[string]*grainActivation)
+	}
+	this.grainTypes[grain.Name] = grain
+	this.grainLocker.Unlock()
+	this.grainSweeper.Do(func() {
+		stop := make(chan struct{})
+		this.grainLocker.Lock()
+		this.grainSweepStop = stop
+		this.grainLocker.Unlock()
+		go this.sweepGrains(stop)
+	})
+}
+
+//设置grain状态存储，默认为内存存储
+func (this *ActorProxyComponent) SetGrainStore(store GrainStore) {
+	this.grainLocker.Lock()
+	this.grainStore = store
+	this.grainLocker.Unlock()
+}
+
+func (this *ActorProxyComponent) getGrainStore() GrainStore {
+	this.grainLocker.Lock()
+	defer this.grainLocker.Unlock()
+	if this.grainStore == nil {
+		this.grainStore = NewMemoryGrainStore()
+	}
+	return this.grainStore
+}
+
+func (this *ActorProxyComponent) grainType(key string) (*GrainType, error) {
+	i := strings.Index(key, "/")
+	if i <= 0 || i == len(key)-1 {
+		return nil, ErrGrainKeyWrongFormat
+	}
+	this.grainLocker.Lock()
+	defer this.grainLocker.Unlock()
+	grain, ok := this.grainTypes[key[:i]]
+	if !ok {
+		return nil, ErrUnknownGrain
+	}
+	return grain, nil
+}
+
+//获取grain，消息发送时按需激活
+func (this *ActorProxyComponent) GetGrain(typ string, id string) IActor {
+	return &Grain{key: GrainKey(typ, id), proxy: this}
+}
+
+//查询grain的激活地址，未激活时按放置策略选择节点激活
+func (this *ActorProxyComponent) resolveGrain(ctx context.Context, key string) (ActorID, error) {
+	if v, ok := this.grainCache.Load(key); ok {
+		return v.(ActorID), nil
+	}
+	grain, err := this.grainType(key)
+	if err != nil {
+		return nil, err
+	}
+	var addr string
+	addr, err = this.nodeComponent.ResolveActor(ctx, grainName(key))
+	id := EmptyActorID()
+	switch err {
+	case nil:
+		err = id.Parse(addr)
+	case Cluster.ErrActorNotFound:
+		id, err = this.placeGrain(ctx, grain, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	this.grainCache.Store(key, id)
+	return id, nil
+}
+
+//选择节点并激活
+func (this *ActorProxyComponent) placeGrain(ctx context.Context, grain *GrainType, key string) (ActorID, error) {
+	node, err := this.nodeComponent.GetNodeContext(ctx, grain.Role, grain.Selector, key)
+	if err != nil {
+		return nil, err
+	}
+	if node.Addr == this.nodeID {
+		return this.activateGrain(ctx, key)
+	}
+	client, err := node.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	var id ActorID
+	err = client.CallContext(ctx, "ActorProxyService.ActivateGrain", key, &id)
+	return id, err
+}
+
+//激活本节点上的grain，返回激活地址；grain已在其他节点激活时返回其地址
+func (this *ActorProxyComponent) activateGrain(ctx context.Context, key string) (ActorID, error) {
+	grain, err := this.grainType(key)
+	if err != nil {
+		return nil, err
+	}
+	this.grainLocker.Lock()
+	if a, ok := this.grains[key]; ok {
+		this.grainLocker.Unlock()
+		<-a.ready
+		return a.id, a.err
+	}
+	a := &grainActivation{ready: make(chan struct{})}
+	this.grains[key] = a
+	this.grainLocker.Unlock()
+
+	a.err = this.doActivateGrain(ctx, grain, key, a)
+	if a.err != nil || a.object == nil {
+		this.grainLocker.Lock()
+		delete(this.grains, key)
+		this.grainLocker.Unlock()
+	}
+	close(a.ready)
+	return a.id, a.err
+}
+
+func (this *ActorProxyComponent) doActivateGrain(ctx context.Context, grain *GrainType, key string, a *grainActivation) error {
+	data, err := this.getGrainStore().Load(key)
+	if err != nil {
+		return err
+	}
+	var object *ecs.Object
+	if data != nil {
+		template, err := ecs.ObjectTemplateFromJson(string(data))
+		if err != nil {
+			return err
+		}
+		template.Name = key
+		if object, err = this.Runtime().Insert(template, this.Runtime().Root()); err != nil {
+			return err
+		}
+	} else {
+		components := []ecs.IComponent{NewActorComponent(grain.ActorType)}
+		for _, provider := range grain.Components {
+			components = append(components, provider.New())
+		}
+		object = ecs.NewObject(key)
+		if err = this.Runtime().Root().AddObjectWithComponents(object, components); err != nil {
+			return err
+		}
+	}
+	var actor *ActorComponent
+	if err = object.Find(&actor); err != nil {
+		object.Destroy()
+		return err
+	}
+
+	//同一grain只保留一个激活
+	owner, err := this.nodeComponent.ClaimActor(ctx, grainName(key), actor.ID().String())
+	if err == nil {
+		err = a.id.Parse(owner)
+	}
+	if err != nil || !a.id.Equal(actor.ID()) {
+		object.Destroy()
+		return err
+	}
+	a.object, a.actor = object, actor
+	logger.Debug(fmt.Sprintf("grain [ %s ] activated as [ %s ]", key, a.id.String()))
+	return nil
+}
+
+//重新声明本节点上的grain激活，已在其他节点激活时失活本地激活
+func (this *ActorProxyComponent) reclaimGrains() {
+	this.grainLocker.Lock()
+	activations := make(map[string]*grainActivation, len(this.grains))
+	for key, a := range this.grains {
+		select {
+		case <-a.ready:
+			activations[key] = a
+		default:
+		}
+	}
+	this.grainLocker.Unlock()
+	for key, a := range activations {
+		ctx, cancel := this.timeoutContext()
+		owner, err := this.nodeComponent.ClaimActor(ctx, grainName(key), a.id.String())
+		cancel()
+		if err != nil {
+			logger.Warn(fmt.Sprintf("reclaim grain [ %s ] failed: %s", key, err.Error()))
+			continue
+		}
+		if owner != a.id.String() {
+			logger.Warn(fmt.Sprintf("grain [ %s ] was activated on [ %s ] while this node was away", key, owner))
+			this.deactivateGrain(key)
+		}
+	}
+}
+
+//失活grain：保存状态、注销并销毁
+func (this *ActorProxyComponent) deactivateGrain(key string) error {
+	this.grainLocker.Lock()
+	a, ok := this.grains[key]
+	if !ok || a.object == nil {
+		this.grainLocker.Unlock()
+		return nil
+	}
+	delete(this.grains, key)
+	this.grainLocker.Unlock()
+
+	//等待处理中的消息完成后再保存和销毁
+	ctx, cancel := this.timeoutContext()
+	defer cancel()
+	if e := a.actor.deactivate(ctx); e != nil {
+		logger.Warn(fmt.Sprintf("grain [ %s ] is still handling messages: %s", key, e.Error()))
+	}
+	template, err := this.Runtime().Factory().Serialize(a.object)
+	if err == nil {
+		var data []byte
+		if data, err = ecs.ObjectTemplateAsJson(template); err == nil {
+			err = this.getGrainStore().Save(key, data)
+		}
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("save grain [ %s ] failed: %s", key, err.Error()))
+	}
+	ctx, cancel = this.timeoutContext()
+	defer cancel()
+	if e := this.nodeComponent.UnregisterActor(ctx, grainName(key), a.id.String()); e != nil {
+		logger.Warn(fmt.Sprintf("unregister grain [ %s ] failed: %s", key, e.Error()))
+	}
+	this.grainCache.Delete(key)
+	a.object.Destroy()
+	logger.Debug(fmt.Sprintf("grain [ %s ] deactivated", key))
+	return err
+}
+
+//失活所有grain，停机时调用
+func (this *ActorProxyComponent) deactivateGrains() {
+	this.grainLocker.Lock()
+	keys := make([]string, 0, len(this.grains))
+	for key := range this.grains {
+		keys = append(keys, key)
+	}
+	this.grainLocker.Unlock()
+	for _, key := range keys {
+		this.deactivateGrain(key)
+	}
+}
+
+//定期失活空闲的grain，直到stop关闭
+func (this *ActorProxyComponent) sweepGrains(stop chan struct{}) {
+	for {
+		timeout := time.Millisecond * time.Duration(config.Config.ClusterConfig.GrainIdleTimeout)
+		interval := timeout / 4
+		if interval < time.Second {
+			interval = time.Second
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+
+		idle := make([]string, 0)
+		this.grainLocker.Lock()
+		for key, a := range this.grains {
+			if a.actor != nil && a.actor.idle() > timeout {
+				idle = append(idle, key)
+			}
+		}
+		this.grainLocker.Unlock()
+		for _, key := range idle {
+			this.deactivateGrain(key)
+		}
+	}
+}
+
+//停止空闲检查，之后注册的grain类型不再启动检查
+func (this *ActorProxyComponent) stopGrainSweeper() {
+	this.grainSweeper.Do(func() {})
+	this.grainLocker.Lock()
+	defer this.grainLocker.Unlock()
+	if this.grainSweepStop != nil {
+		close(this.grainSweepStop)
+		this.grainSweepStop = nil
+	}
+}
+
+// Grain is a reference to a virtual actor. Messages sent to it activate the
+// actor if needed.
+type Grain struct {
+	key   string
+	proxy *ActorProxyComponent
+}
+
+func (this *Grain) Key() string {
+	return this.key
+}
+
+//当前激活地址，未解析过时为空
+func (this *Grain) ID() ActorID {
+	if v, ok := this.proxy.grainCache.Load(this.key); ok {
+		return v.(ActorID)
+	}
+	return EmptyActorID()
+}
+
+func (this *Grain) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	return this.TellContext(context.Background(), sender, message, reply...)
+}
+
+//激活已失效(失活、所在节点离开或不可达)时重新解析一次
+func (this *Grain) TellContext(ctx context.Context, sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	id, err := this.proxy.resolveGrain(ctx, this.key)
+	if err != nil {
+		return err
+	}
+	err = NewActor(id, this.proxy).TellContext(ctx, sender, message, reply...)
+	if !this.stale(id, err) {
+		return err
+	}
+	if id, err = this.reresolve(ctx, id, err); err != nil {
+		return err
+	}
+	return NewActor(id, this.proxy).TellContext(ctx, sender, message, reply...)
+}
+
+//Ask grain，激活已失效时重新解析一次
+func (this *Grain) ask(ctx context.Context, sender IActor, message *ActorMessage) *Future {
+	id, err := this.proxy.resolveGrain(ctx, this.key)
+	if err != nil {
+		return completedFuture(nil, err)
+	}
+	f := newFuture()
+	this.proxy.ask(ctx, sender, id, message).OnComplete(func(reply *ActorMessage, err error) {
+		if !this.stale(id, err) {
+			f.complete(reply, err)
+			return
+		}
+		//重新解析可能需要rpc，不在回调中阻塞
+		go func() {
+			id, err := this.reresolve(ctx, id, err)
+			if err != nil {
+				f.complete(nil, err)
+				return
+			}
+			this.proxy.ask(ctx, sender, id, message).OnComplete(func(reply *ActorMessage, err error) {
+				f.complete(reply, err)
+			})
+		}()
+	})
+	return f
+}
+
+//激活已不在该地址
+func grainGone(err error) bool {
+	return err.Error() == ErrNoThisActor.Error() || err.Error() == ErrActorInactive.Error()
+}
+
+//发送失败是否因为激活地址失效，本地处理者返回的错误不算
+func (this *Grain) stale(id ActorID, err error) bool {
+	if err == nil {
+		return false
+	}
+	return grainGone(err) || (id.GetNodeID() != this.proxy.nodeID && isUnreachable(err))
+}
+
+//丢弃失效的激活地址并重新解析。激活已不存在时从actor目录注销；节点不可达时只丢弃缓存，
+//目录中的地址在master移出该节点时清除，仍解析到同一地址时返回原错误
+func (this *Grain) reresolve(ctx context.Context, stale ActorID, cause error) (ActorID, error) {
+	this.proxy.grainCache.Delete(this.key)
+	gone := grainGone(cause)
+	if gone {
+		dctx, cancel := this.proxy.timeoutContext()
+		this.proxy.nodeComponent.UnregisterActor(dctx, grainName(this.key), stale.String())
+		cancel()
+	}
+	id, err := this.proxy.resolveGrain(ctx, this.key)
+	if err != nil {
+		return nil, err
+	}
+	if !gone && id.Equal(stale) {
+		this.proxy.grainCache.Delete(this.key)
+		return nil, cause
+	}
+	return id, nil
+}
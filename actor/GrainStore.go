@@ -0,0 +1,90 @@
+package Actor
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// GrainStore persists the state of virtual actors between activations. The
+// state is the json object template of the grain.
+type GrainStore interface {
+	// Load returns nil data when the grain has no saved state.
+	Load(key string) ([]byte, error)
+	Save(key string, data []byte) error
+	Delete(key string) error
+}
+
+//内存存储，进程内有效，用于测试和单节点
+type MemoryGrainStore struct {
+	locker sync.RWMutex
+	states map[string][]byte
+}
+
+func NewMemoryGrainStore() *MemoryGrainStore {
+	return &MemoryGrainStore{states: make(map[string][]byte)}
+}
+
+func (this *MemoryGrainStore) Load(key string) ([]byte, error) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	return this.states[key], nil
+}
+
+func (this *MemoryGrainStore) Save(key string, data []byte) error {
+	this.locker.Lock()
+	this.states[key] = data
+	this.locker.Unlock()
+	return nil
+}
+
+func (this *MemoryGrainStore) Delete(key string) error {
+	this.locker.Lock()
+	delete(this.states, key)
+	this.locker.Unlock()
+	return nil
+}
+
+//文件存储，每个grain一个文件，多节点时目录需共享
+type FileGrainStore struct {
+	dir string
+}
+
+func NewFileGrainStore(dir string) (*FileGrainStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileGrainStore{dir: dir}, nil
+}
+
+func (this *FileGrainStore) path(key string) string {
+	return filepath.Join(this.dir, url.PathEscape(key)+".json")
+}
+
+func (this *FileGrainStore) Load(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(this.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+//先写临时文件再重命名，避免写入中断留下不完整的状态
+func (this *FileGrainStore) Save(key string, data []byte) error {
+	path := this.path(key)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (this *FileGrainStore) Delete(key string) error {
+	err := os.Remove(this.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
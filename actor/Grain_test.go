@@ -0,0 +1,181 @@
+package Actor
+
+import (
+	"context"
+	"github.com/zllangct/rockgo/ecs"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//计数grain，状态随失活保存
+type testCounter struct {
+	ecs.ComponentBase
+	count int64
+}
+
+type testCounterState struct {
+	Count int64
+}
+
+//已激活的计数grain数
+var testCounterActivations int32
+
+func (this *testCounter) New() ecs.IComponent {
+	return &testCounter{}
+}
+
+func (this *testCounter) Initialize() error {
+	atomic.AddInt32(&testCounterActivations, 1)
+	return nil
+}
+
+func (this *testCounter) Serialize() (interface{}, error) {
+	return ecs.SerializeState(&testCounterState{Count: atomic.LoadInt64(&this.count)})
+}
+
+func (this *testCounter) Deserialize(data interface{}) error {
+	state := &testCounterState{}
+	if err := ecs.DeserializeState(state, data); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&this.count, state.Count)
+	return nil
+}
+
+func (this *testCounter) MessageHandlers() map[string]func(message *ActorMessageInfo) error {
+	return map[string]func(message *ActorMessageInfo) error{
+		"Add": func(message *ActorMessageInfo) error {
+			atomic.AddInt64(&this.count, 1)
+			return nil
+		},
+		"Get": func(message *ActorMessageInfo) error {
+			return message.Reply(atomic.LoadInt64(&this.count))
+		},
+	}
+}
+
+func getCount(t *testing.T, grain IActor) int64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := Ask(ctx, grain, NewActorMessage("Get")).Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply.Data[0].(int64)
+}
+
+func TestGrainActivation(t *testing.T) {
+	proxy := testNode(t)
+	proxy.RegisterGrain(&GrainType{
+		Name:       "Counter",
+		Role:       "room",
+		ActorType:  ACTOR_TYPE_SYNC,
+		Components: []ecs.ComponentProvider{&testCounter{}},
+	})
+	store := NewMemoryGrainStore()
+	proxy.SetGrainStore(store)
+	key := GrainKey("Counter", "1")
+	grain := proxy.GetGrain("Counter", "1")
+	activations := atomic.LoadInt32(&testCounterActivations)
+
+	//第一条消息激活grain
+	for i := 0; i < 2; i++ {
+		if err := grain.Tell(nil, NewActorMessage("Add"), new(*ActorMessage)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := getCount(t, grain); n != 2 {
+		t.Fatalf("expected count 2, got %d", n)
+	}
+	if n := atomic.LoadInt32(&testCounterActivations) - activations; n != 1 {
+		t.Fatalf("expected one activation, got %d", n)
+	}
+	first := grain.ID()
+
+	//失活时保存状态并注销
+	if err := proxy.deactivateGrain(key); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := store.Load(key); data == nil {
+		t.Fatal("state should be saved on deactivation")
+	}
+	waitFor(t, time.Second, "the deactivated grain is destroyed", func() bool {
+		_, ok := proxy.localActors.Load(first.String())
+		return !ok
+	})
+
+	//同一引用再次发送时在新地址重新激活并恢复状态
+	if err := grain.Tell(nil, NewActorMessage("Add"), new(*ActorMessage)); err != nil {
+		t.Fatal(err)
+	}
+	if n := getCount(t, grain); n != 3 {
+		t.Fatalf("expected restored count 3, got %d", n)
+	}
+	if n := atomic.LoadInt32(&testCounterActivations) - activations; n != 2 {
+		t.Fatalf("expected a second activation, got %d", n)
+	}
+	if grain.ID().Equal(first) {
+		t.Fatal("reactivated grain should have a new address")
+	}
+	proxy.deactivateGrain(key)
+	store.Delete(key)
+}
+
+func TestGrainUnreachable(t *testing.T) {
+	proxy := testNode(t)
+	proxy.RegisterGrain(&GrainType{
+		Name:       "Counter",
+		Role:       "room",
+		ActorType:  ACTOR_TYPE_SYNC,
+		Components: []ecs.ComponentProvider{&testCounter{}},
+	})
+	key := GrainKey("Counter", "2")
+	grain := proxy.GetGrain("Counter", "2")
+	if err := grain.Tell(nil, NewActorMessage("Add"), new(*ActorMessage)); err != nil {
+		t.Fatal(err)
+	}
+	live := grain.ID()
+
+	//缓存指向已不可达的节点时丢弃缓存，按actor目录重新解析
+	dead := EmptyActorID()
+	dead, _ = dead.SetNodeID("127.0.0.1:1")
+	dead[2] = "gone"
+	proxy.grainCache.Store(key, dead)
+	if err := grain.Tell(nil, NewActorMessage("Add"), new(*ActorMessage)); err != nil {
+		t.Fatal(err)
+	}
+	if !grain.ID().Equal(live) {
+		t.Fatalf("cache should be resolved to %v again, got %v", live, grain.ID())
+	}
+
+	proxy.grainCache.Store(key, dead)
+	if n := getCount(t, grain); n != 2 {
+		t.Fatalf("expected count 2 through ask, got %d", n)
+	}
+	proxy.deactivateGrain(key)
+	proxy.getGrainStore().Delete(key)
+}
+
+func TestGrainSweeperStop(t *testing.T) {
+	testNode(t)
+	proxy := &ActorProxyComponent{}
+	stopped := make(chan struct{})
+	proxy.grainSweeper.Do(func() {
+		stop := make(chan struct{})
+		proxy.grainSweepStop = stop
+		go func() {
+			proxy.sweepGrains(stop)
+			close(stopped)
+		}()
+	})
+	//销毁代理时停止空闲检查，可重复调用
+	proxy.stopGrainSweeper()
+	proxy.stopGrainSweeper()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the grain sweeper should stop")
+	}
+}
@@ -0,0 +1,166 @@
+package Actor
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JournalEvent is an event persisted by an actor. Seq starts at 1 and
+// increases by one for every event of the same persistence id.
+type JournalEvent struct {
+	PersistenceID string
+	Seq           uint64
+	Type          string
+	Data          []byte //msgpack编码，用Decode读取
+	Time          int64  //纳秒
+}
+
+//将事件数据解码到out
+func (this *JournalEvent) Decode(out interface{}) error {
+	return (&ActorMessage{Payload: this.Data}).Decode(out)
+}
+
+// JournalSnapshot is the state of an actor object after the event Seq. Data is
+// the json object template produced by ObjectFactory.Serialize.
+type JournalSnapshot struct {
+	PersistenceID string
+	Seq           uint64
+	Data          []byte
+	Time          int64
+}
+
+// Journal stores the events and snapshots of persistent actors.
+type Journal interface {
+	Append(event *JournalEvent) error
+	// Replay calls handler for the events after fromSeq in order.
+	Replay(persistenceID string, fromSeq uint64, handler func(event *JournalEvent) error) error
+	SaveSnapshot(snapshot *JournalSnapshot) error
+	// LoadSnapshot returns nil when there is no snapshot.
+	LoadSnapshot(persistenceID string) (*JournalSnapshot, error)
+}
+
+//文件日志，每个持久化id一个事件文件(每行一个json事件)和一个快照文件，用于测试和单节点
+type FileJournal struct {
+	locker sync.Mutex
+	dir    string
+}
+
+func NewFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileJournal{dir: dir}, nil
+}
+
+func (this *FileJournal) path(persistenceID string, ext string) string {
+	return filepath.Join(this.dir, url.PathEscape(persistenceID)+ext)
+}
+
+func (this *FileJournal) Append(event *JournalEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	file, err := os.OpenFile(this.path(event.PersistenceID, ".events"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	//确保事件落盘后才应用到状态
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (this *FileJournal) Replay(persistenceID string, fromSeq uint64, handler func(event *JournalEvent) error) error {
+	path := this.path(persistenceID, ".events")
+	this.locker.Lock()
+	file, err := os.Open(path)
+	this.locker.Unlock()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				//最后一行不完整时为写入中断，截掉以免之后追加的事件接在其后
+				return this.truncate(path, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		event := &JournalEvent{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return err
+		}
+		if event.Seq <= fromSeq {
+			continue
+		}
+		if err := handler(event); err != nil {
+			return err
+		}
+	}
+}
+
+//截掉写入中断的最后一行
+func (this *FileJournal) truncate(path string, size int64) error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return os.Truncate(path, size)
+}
+
+//先写临时文件再重命名，避免写入中断留下不完整的快照
+func (this *FileJournal) SaveSnapshot(snapshot *JournalSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	path := this.path(snapshot.PersistenceID, ".snapshot")
+	tmp := path + ".tmp"
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (this *FileJournal) LoadSnapshot(persistenceID string) (*JournalSnapshot, error) {
+	this.locker.Lock()
+	data, err := ioutil.ReadFile(this.path(persistenceID, ".snapshot"))
+	this.locker.Unlock()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &JournalSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
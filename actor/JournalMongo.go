@@ -0,0 +1,102 @@
+package Actor
+
+import (
+	"github.com/zllangct/rockgo/db/mongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	MONGO_JOURNAL_EVENTS    = "journal_events"
+	MONGO_JOURNAL_SNAPSHOTS = "journal_snapshots"
+)
+
+//mongodb日志，事件按 持久化id+序号 唯一，同一actor有两个激活同时写入时后写入者失败
+type MongoJournal struct {
+	db *mongo.DbOperate
+}
+
+type mongoJournalEvent struct {
+	PersistenceID string `bson:"pid"`
+	Seq           uint64 `bson:"seq"`
+	Type          string `bson:"type"`
+	Data          []byte `bson:"data"`
+	Time          int64  `bson:"time"`
+}
+
+type mongoJournalSnapshot struct {
+	Seq  uint64 `bson:"seq"`
+	Data []byte `bson:"data"`
+	Time int64  `bson:"time"`
+}
+
+//db需已OpenDB
+func NewMongoJournal(db *mongo.DbOperate) *MongoJournal {
+	return &MongoJournal{db: db}
+}
+
+//创建事件索引，可在OpenDB的set_index_func中调用
+func MongoJournalIndex(ms *mgo.Session) error {
+	return ms.DB("").C(MONGO_JOURNAL_EVENTS).EnsureIndex(mgo.Index{
+		Key:    []string{"pid", "seq"},
+		Unique: true,
+	})
+}
+
+func (this *MongoJournal) Append(event *JournalEvent) error {
+	return this.db.StrongInsert(MONGO_JOURNAL_EVENTS, &mongoJournalEvent{
+		PersistenceID: event.PersistenceID,
+		Seq:           event.Seq,
+		Type:          event.Type,
+		Data:          event.Data,
+		Time:          event.Time,
+	})
+}
+
+func (this *MongoJournal) Replay(persistenceID string, fromSeq uint64, handler func(event *JournalEvent) error) error {
+	cond := bson.M{"pid": persistenceID, "seq": bson.M{"$gt": fromSeq}}
+	return this.db.StrongDBFindAllEx(MONGO_JOURNAL_EVENTS, cond, func(q *mgo.Query) error {
+		iter := q.Sort("seq").Iter()
+		doc := &mongoJournalEvent{}
+		for iter.Next(doc) {
+			err := handler(&JournalEvent{
+				PersistenceID: doc.PersistenceID,
+				Seq:           doc.Seq,
+				Type:          doc.Type,
+				Data:          doc.Data,
+				Time:          doc.Time,
+			})
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			doc = &mongoJournalEvent{}
+		}
+		return iter.Close()
+	})
+}
+
+func (this *MongoJournal) SaveSnapshot(snapshot *JournalSnapshot) error {
+	return this.db.StrongUpdateInsert(MONGO_JOURNAL_SNAPSHOTS, bson.M{"_id": snapshot.PersistenceID}, &mongoJournalSnapshot{
+		Seq:  snapshot.Seq,
+		Data: snapshot.Data,
+		Time: snapshot.Time,
+	})
+}
+
+func (this *MongoJournal) LoadSnapshot(persistenceID string) (*JournalSnapshot, error) {
+	doc := &mongoJournalSnapshot{}
+	err := this.db.FindOne(MONGO_JOURNAL_SNAPSHOTS, bson.M{"_id": persistenceID}, doc)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &JournalSnapshot{
+		PersistenceID: persistenceID,
+		Seq:           doc.Seq,
+		Data:          doc.Data,
+		Time:          doc.Time,
+	}, nil
+}
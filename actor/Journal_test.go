@@ -0,0 +1,112 @@
+package Actor
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func replayAll(t *testing.T, journal Journal, persistenceID string, fromSeq uint64) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	err := journal.Replay(persistenceID, fromSeq, func(event *JournalEvent) error {
+		seqs = append(seqs, event.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestFileJournalReplay(t *testing.T) {
+	journal, err := NewFileJournal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs := replayAll(t, journal, "empty", 0); len(seqs) != 0 {
+		t.Fatalf("expected no events, got %v", seqs)
+	}
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := journal.Append(&JournalEvent{PersistenceID: "a/b", Seq: seq, Type: "Add"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seqs := replayAll(t, journal, "a/b", 1); len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 3 {
+		t.Fatalf("expected the events after 1, got %v", seqs)
+	}
+}
+
+func TestFileJournalTornTail(t *testing.T) {
+	journal, err := NewFileJournal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := journal.Append(&JournalEvent{PersistenceID: "torn", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//模拟写入中断
+	path := journal.path("torn", ".events")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"PersistenceID":"torn","Se`)
+	file.Close()
+
+	if seqs := replayAll(t, journal, "torn", 0); len(seqs) != 2 {
+		t.Fatalf("expected the complete events, got %v", seqs)
+	}
+	//截掉不完整的行后追加的事件可以正常重放
+	if err := journal.Append(&JournalEvent{PersistenceID: "torn", Seq: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := replayAll(t, journal, "torn", 0); len(seqs) != 3 || seqs[2] != 3 {
+		t.Fatalf("expected the event appended after the torn tail, got %v", seqs)
+	}
+}
+
+func TestFileJournalReadError(t *testing.T) {
+	journal, err := NewFileJournal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	//读取失败时返回错误，不当作日志结束
+	if err := os.Mkdir(journal.path("dir", ".events"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Replay("dir", 0, func(event *JournalEvent) error { return nil }); err == nil {
+		t.Fatal("expected the read error")
+	}
+
+	if err := ioutil.WriteFile(journal.path("corrupt", ".events"), []byte("{\n{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Replay("corrupt", 0, func(event *JournalEvent) error { return nil }); err == nil {
+		t.Fatal("expected the decode error of a corrupted event")
+	}
+}
+
+func TestFileJournalSnapshot(t *testing.T) {
+	journal, err := NewFileJournal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err := journal.LoadSnapshot("s"); snapshot != nil || err != nil {
+		t.Fatalf("expected no snapshot, got %v %v", snapshot, err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := journal.SaveSnapshot(&JournalSnapshot{PersistenceID: "s", Seq: seq, Data: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot, err := journal.LoadSnapshot("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Seq != 2 || string(snapshot.Data) != "{}" {
+		t.Fatalf("expected the latest snapshot, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,133 @@
+package Actor
+
+import (
+	"context"
+	"errors"
+	"github.com/zllangct/rockgo/config"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	actor邮箱
+	用户消息进入有界队列，队列满时按溢出策略处理：
+		block        阻塞等待，超过MailboxTimeout返回ErrMailboxFull，为0时一直等待，为MAILBOX_TIMEOUT_CONFIG时使用配置MailboxBlockTimeout
+		drop_newest  丢弃新消息，发送方收到ErrMailboxFull
+		drop_oldest  丢弃队列中最旧的消息，该消息如需回复，其发送方收到ErrMailboxFull
+		reject       立即返回ErrMailboxFull
+	系统消息(ActorMessage.System)走独立的优先通道，总是先于用户消息处理，不受用户消息溢出影响。
+*/
+
+const (
+	MAILBOX_OVERFLOW_BLOCK       MailboxOverflow = "block"
+	MAILBOX_OVERFLOW_DROP_NEWEST MailboxOverflow = "drop_newest"
+	MAILBOX_OVERFLOW_DROP_OLDEST MailboxOverflow = "drop_oldest"
+	MAILBOX_OVERFLOW_REJECT      MailboxOverflow = "reject"
+)
+
+const MAILBOX_SYSTEM_CAPACITY = 64
+
+//MailboxTimeout未设置，使用配置MailboxBlockTimeout
+const MAILBOX_TIMEOUT_CONFIG time.Duration = -1
+
+type MailboxOverflow string
+
+var ErrMailboxFull = errors.New("actor mailbox is full")
+
+//按actor设置及配置初始化邮箱
+func (this *ActorComponent) initMailbox() {
+	conf := config.Config.ClusterConfig
+	if this.MailboxCapacity <= 0 {
+		this.MailboxCapacity = conf.MailboxCapacity
+	}
+	if this.MailboxCapacity <= 0 {
+		this.MailboxCapacity = 20
+	}
+	if this.MailboxOverflow == "" {
+		this.MailboxOverflow = MailboxOverflow(conf.MailboxOverflow)
+	}
+	if this.MailboxOverflow == "" {
+		this.MailboxOverflow = MAILBOX_OVERFLOW_BLOCK
+	}
+	this.queueReceive = make(chan *ActorMessageInfo, this.MailboxCapacity)
+	this.queueSystem = make(chan *ActorMessageInfo, MAILBOX_SYSTEM_CAPACITY)
+}
+
+//投递消息到邮箱
+func (this *ActorComponent) post(ctx context.Context, messageInfo *ActorMessageInfo) error {
+	if messageInfo.Message.System {
+		select {
+		case this.queueSystem <- messageInfo:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case this.queueReceive <- messageInfo:
+		return nil
+	default:
+	}
+
+	switch this.MailboxOverflow {
+	case MAILBOX_OVERFLOW_REJECT:
+		return ErrMailboxFull
+	case MAILBOX_OVERFLOW_DROP_NEWEST:
+		atomic.AddInt64(&this.dropped, 1)
+		return ErrMailboxFull
+	case MAILBOX_OVERFLOW_DROP_OLDEST:
+		for {
+			select {
+			case this.queueReceive <- messageInfo:
+				return nil
+			default:
+			}
+			select {
+			case oldest := <-this.queueReceive:
+				this.drop(oldest)
+			default:
+			}
+		}
+	default:
+		var timeout <-chan time.Time
+		if d := this.mailboxTimeout(); d > 0 {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case this.queueReceive <- messageInfo:
+			return nil
+		case <-timeout:
+			return ErrMailboxFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//溢出策略为block时的最长等待时间，未设置时使用配置
+func (this *ActorComponent) mailboxTimeout() time.Duration {
+	if this.MailboxTimeout < 0 {
+		return time.Duration(config.Config.ClusterConfig.MailboxBlockTimeout) * time.Millisecond
+	}
+	return this.MailboxTimeout
+}
+
+//丢弃消息，需回复时通知发送方
+func (this *ActorComponent) drop(messageInfo *ActorMessageInfo) {
+	atomic.AddInt64(&this.dropped, 1)
+	atomic.AddInt32(&this.pending, -1)
+	this.Proxy.deadLetter(this.ActorID, messageInfo.Sender, messageInfo.Message, DEAD_LETTER_MAILBOX_FULL, ErrMailboxFull)
+	messageInfo.replyError(ErrMailboxFull)
+}
+
+//邮箱中等待处理的消息数
+func (this *ActorComponent) MailboxDepth() int {
+	return len(this.queueReceive) + len(this.queueSystem)
+}
+
+//因邮箱溢出丢弃的消息数
+func (this *ActorComponent) MailboxDropped() int64 {
+	return atomic.LoadInt64(&this.dropped)
+}
@@ -0,0 +1,203 @@
+package Actor
+
+import (
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/ecs"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//处理Block时阻塞到release关闭，按顺序记录处理过的消息
+type testMailbox struct {
+	actor   *ActorComponent
+	object  *ecs.Object
+	release chan struct{}
+	once    sync.Once
+	locker  sync.Mutex
+	handled []string
+}
+
+func newTestMailbox(t *testing.T, proxy *ActorProxyComponent, overflow MailboxOverflow, timeout time.Duration) *testMailbox {
+	box := &testMailbox{release: make(chan struct{})}
+	started := make(chan struct{})
+	record := func(message *ActorMessageInfo) error {
+		box.locker.Lock()
+		box.handled = append(box.handled, message.Message.Data[0].(string))
+		box.locker.Unlock()
+		return nil
+	}
+	box.actor = NewActorComponent(ACTOR_TYPE_SYNC)
+	box.actor.MailboxCapacity = 2
+	box.actor.MailboxOverflow = overflow
+	box.actor.MailboxTimeout = timeout
+	box.object = newTestActor(t, proxy, box.actor, map[string]func(message *ActorMessageInfo) error{
+		"Block": func(message *ActorMessageInfo) error {
+			close(started)
+			<-box.release
+			return nil
+		},
+		"Msg": record,
+		"Sys": record,
+	})
+	//阻塞处理者，之后的消息留在邮箱中
+	if err := box.actor.Tell(nil, NewActorMessage("Block")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	return box
+}
+
+//填满邮箱
+func (this *testMailbox) fill(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := this.actor.Tell(nil, NewActorMessage("Msg", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//放开阻塞的处理者
+func (this *testMailbox) unblock() {
+	this.once.Do(func() { close(this.release) })
+}
+
+//放开处理者，等待处理完并返回处理顺序
+func (this *testMailbox) drain(t *testing.T, n int) []string {
+	t.Helper()
+	this.unblock()
+	waitFor(t, time.Second, "the mailbox is drained", func() bool {
+		this.locker.Lock()
+		defer this.locker.Unlock()
+		return len(this.handled) >= n
+	})
+	destroyActor(this.object)
+	return this.handled
+}
+
+func TestMailboxReject(t *testing.T) {
+	box := newTestMailbox(t, testNode(t), MAILBOX_OVERFLOW_REJECT, 0)
+	box.fill(t, "a", "b")
+	if err := box.actor.Tell(nil, NewActorMessage("Msg", "c")); err != ErrMailboxFull {
+		t.Fatalf("expected the full mailbox to reject, got %v", err)
+	}
+	if n := box.actor.MailboxDropped(); n != 0 {
+		t.Fatalf("rejected messages are not counted as dropped, got %d", n)
+	}
+	if handled := box.drain(t, 2); !reflect.DeepEqual(handled, []string{"a", "b"}) {
+		t.Fatalf("unexpected handled messages %v", handled)
+	}
+}
+
+func TestMailboxDropNewest(t *testing.T) {
+	box := newTestMailbox(t, testNode(t), MAILBOX_OVERFLOW_DROP_NEWEST, 0)
+	box.fill(t, "a", "b")
+	if err := box.actor.Tell(nil, NewActorMessage("Msg", "c")); err != ErrMailboxFull {
+		t.Fatalf("expected the newest message to be dropped, got %v", err)
+	}
+	if n := box.actor.MailboxDropped(); n != 1 {
+		t.Fatalf("expected 1 dropped message, got %d", n)
+	}
+	if handled := box.drain(t, 2); !reflect.DeepEqual(handled, []string{"a", "b"}) {
+		t.Fatalf("unexpected handled messages %v", handled)
+	}
+}
+
+func TestMailboxDropOldest(t *testing.T) {
+	box := newTestMailbox(t, testNode(t), MAILBOX_OVERFLOW_DROP_OLDEST, 0)
+	//最旧的消息需要回复，被丢弃时其发送方收到ErrMailboxFull
+	oldest := make(chan error, 1)
+	go func() {
+		oldest <- box.actor.Tell(nil, NewActorMessage("Msg", "a"), new(*ActorMessage))
+	}()
+	waitFor(t, time.Second, "the oldest message is queued", func() bool {
+		return box.actor.MailboxDepth() == 1
+	})
+	box.fill(t, "b", "c")
+	select {
+	case err := <-oldest:
+		if err != ErrMailboxFull {
+			t.Fatalf("expected the sender of the dropped message to get ErrMailboxFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the sender of the dropped message is not notified")
+	}
+	if n := box.actor.MailboxDropped(); n != 1 {
+		t.Fatalf("expected 1 dropped message, got %d", n)
+	}
+	if handled := box.drain(t, 2); !reflect.DeepEqual(handled, []string{"b", "c"}) {
+		t.Fatalf("unexpected handled messages %v", handled)
+	}
+}
+
+func TestMailboxBlock(t *testing.T) {
+	box := newTestMailbox(t, testNode(t), MAILBOX_OVERFLOW_BLOCK, time.Millisecond*50)
+	box.fill(t, "a", "b")
+	start := time.Now()
+	if err := box.actor.Tell(nil, NewActorMessage("Msg", "c")); err != ErrMailboxFull {
+		t.Fatalf("expected the blocked send to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Fatalf("the send should block until the timeout, returned after %v", elapsed)
+	}
+
+	//邮箱有空位时阻塞的发送方继续投递
+	box.actor.MailboxTimeout = time.Second
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		box.unblock()
+	}()
+	if err := box.actor.Tell(nil, NewActorMessage("Msg", "d")); err != nil {
+		t.Fatal(err)
+	}
+	if handled := box.drain(t, 3); !reflect.DeepEqual(handled, []string{"a", "b", "d"}) {
+		t.Fatalf("unexpected handled messages %v", handled)
+	}
+}
+
+func TestMailboxSystemLane(t *testing.T) {
+	box := newTestMailbox(t, testNode(t), MAILBOX_OVERFLOW_REJECT, 0)
+	box.fill(t, "a", "b")
+	//用户消息已满时系统消息仍可投递，并先于用户消息处理
+	if err := box.actor.Tell(nil, NewSystemActorMessage("Sys", "sys")); err != nil {
+		t.Fatalf("system messages should bypass the full user mailbox, got %v", err)
+	}
+	if handled := box.drain(t, 3); !reflect.DeepEqual(handled, []string{"sys", "a", "b"}) {
+		t.Fatalf("expected the system message first, got %v", handled)
+	}
+}
+
+func TestMailboxTimeoutSetting(t *testing.T) {
+	proxy := testNode(t)
+	configured := time.Duration(config.Config.ClusterConfig.MailboxBlockTimeout) * time.Millisecond
+	for _, c := range []struct {
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{MAILBOX_TIMEOUT_CONFIG, configured},
+		{0, 0},
+		{time.Second, time.Second},
+	} {
+		actor := NewActorComponent(ACTOR_TYPE_SYNC)
+		actor.MailboxTimeout = c.timeout
+		object := newTestActor(t, proxy, actor, nil)
+		//显式设置的0不被配置覆盖，迁移后仍保持未设置或显式的值
+		if d := actor.mailboxTimeout(); d != c.expected {
+			t.Errorf("timeout %v: expected to wait %v, got %v", c.timeout, c.expected, d)
+		}
+		state, err := actor.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := actor.New().(*ActorComponent)
+		if err := restored.Deserialize(state); err != nil {
+			t.Fatal(err)
+		}
+		if restored.MailboxTimeout != c.timeout {
+			t.Errorf("timeout %v: expected to be kept when migrating, got %v", c.timeout, restored.MailboxTimeout)
+		}
+		destroyActor(object)
+	}
+}
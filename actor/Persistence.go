@@ -0,0 +1,248 @@
+package Actor
+
+import (
+	"fmt"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	actor持久化(事件溯源)
+	消息处理者不直接修改状态，而是调用PersistenceComponent.Persist产生事件：事件先交给实现IEventValidator的组件校验，
+	通过后追加到日志，再交给实体上实现IEventApplier的组件应用。actor启动时从最近的快照和之后的事件恢复状态。
+	已写入日志的事件在恢复时总会重放，应用可能失败的校验应放在ValidateEvent中，ApplyEvent对校验过的事件不应失败。
+	每SnapshotEvery个事件通过ObjectFactory.Serialize保存一次快照，快照恢复需要状态组件实现ecs.IPersist。
+	组件顺序：状态组件、PersistenceComponent、ActorComponent，开始接收消息前状态已恢复。
+*/
+
+//设置actor持久化日志，默认为JournalDir下的文件日志
+func (this *ActorProxyComponent) SetJournal(journal Journal) {
+	this.grainLocker.Lock()
+	this.journal = journal
+	this.grainLocker.Unlock()
+}
+
+func (this *ActorProxyComponent) getJournal() (Journal, error) {
+	this.grainLocker.Lock()
+	defer this.grainLocker.Unlock()
+	if this.journal == nil {
+		journal, err := NewFileJournal(config.Config.ClusterConfig.JournalDir)
+		if err != nil {
+			return nil, err
+		}
+		this.journal = journal
+	}
+	return this.journal, nil
+}
+
+// IEventApplier applies persisted events to the state of a component. It is
+// called both when an event is persisted and when it is replayed.
+type IEventApplier interface {
+	ApplyEvent(event *JournalEvent) error
+}
+
+// IEventValidator checks an event before it is persisted. An event rejected by
+// any validator is neither written to the journal nor applied.
+type IEventValidator interface {
+	ValidateEvent(event *JournalEvent) error
+}
+
+type PersistenceComponent struct {
+	ecs.ComponentBase
+	PersistenceID string //持久化id，默认为ActorComponent.Name，未设置时为实体名
+	SnapshotEvery int    //每多少个事件保存一次快照，默认使用配置JournalSnapshotInterval
+
+	locker        sync.Mutex
+	journal       Journal
+	seq           uint64 //已应用的最后一个事件序号
+	sinceSnapshot int
+}
+
+func NewPersistenceComponent(persistenceID string) *PersistenceComponent {
+	return &PersistenceComponent{PersistenceID: persistenceID}
+}
+
+func (this *PersistenceComponent) IsUnique() int {
+	return ecs.UNIQUE_TYPE_LOCAL
+}
+
+func (this *PersistenceComponent) New() ecs.IComponent {
+	return &PersistenceComponent{}
+}
+
+//随实体迁移时携带已应用的序号，恢复时只重放之后的事件
+type persistenceState struct {
+	PersistenceID string
+	SnapshotEvery int
+	Seq           uint64
+}
+
+func (this *PersistenceComponent) Serialize() (interface{}, error) {
+	return ecs.SerializeState(&persistenceState{
+		PersistenceID: this.PersistenceID,
+		SnapshotEvery: this.SnapshotEvery,
+		Seq:           atomic.LoadUint64(&this.seq),
+	})
+}
+
+func (this *PersistenceComponent) Deserialize(data interface{}) error {
+	state := &persistenceState{}
+	if err := ecs.DeserializeState(state, data); err != nil {
+		return err
+	}
+	this.PersistenceID = state.PersistenceID
+	this.SnapshotEvery = state.SnapshotEvery
+	atomic.StoreUint64(&this.seq, state.Seq)
+	return nil
+}
+
+func (this *PersistenceComponent) Initialize() error {
+	var proxy *ActorProxyComponent
+	err := this.Runtime().Root().Find(&proxy)
+	if err != nil {
+		return err
+	}
+	if this.journal, err = proxy.getJournal(); err != nil {
+		return err
+	}
+	if this.PersistenceID == "" {
+		var actor *ActorComponent
+		if this.Parent().Find(&actor) == nil && actor.Name != "" {
+			this.PersistenceID = actor.Name
+		} else {
+			this.PersistenceID = this.Parent().Name()
+		}
+	}
+	if this.SnapshotEvery <= 0 {
+		this.SnapshotEvery = config.Config.ClusterConfig.JournalSnapshotInterval
+	}
+	if err = this.recover(); err != nil {
+		logger.Error(fmt.Sprintf("recover [ %s ] failed: %s", this.PersistenceID, err.Error()))
+	}
+	return err
+}
+
+//从快照和日志恢复状态
+func (this *PersistenceComponent) recover() error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	snapshot, err := this.journal.LoadSnapshot(this.PersistenceID)
+	if err != nil {
+		return err
+	}
+	if snapshot != nil && snapshot.Seq > atomic.LoadUint64(&this.seq) {
+		template, err := ecs.ObjectTemplateFromJson(string(snapshot.Data))
+		if err != nil {
+			return err
+		}
+		if err = this.Runtime().Factory().Restore(this.Parent(), template); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&this.seq, snapshot.Seq)
+	}
+	return this.journal.Replay(this.PersistenceID, atomic.LoadUint64(&this.seq), func(event *JournalEvent) error {
+		if err := this.apply(event); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&this.seq, event.Seq)
+		return nil
+	})
+}
+
+//持久化事件并应用到状态，v以msgpack编码
+func (this *PersistenceComponent) Persist(eventType string, v interface{}) error {
+	data, err := encodePayload(v)
+	if err != nil {
+		return err
+	}
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	event := &JournalEvent{
+		PersistenceID: this.PersistenceID,
+		Seq:           atomic.LoadUint64(&this.seq) + 1,
+		Type:          eventType,
+		Data:          data,
+		Time:          time.Now().UnixNano(),
+	}
+	//写入日志前校验，写入后的事件恢复时会重放
+	if err = this.validate(event); err != nil {
+		return err
+	}
+	if err = this.journal.Append(event); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&this.seq, event.Seq)
+	if err = this.apply(event); err != nil {
+		logger.Error(fmt.Sprintf("apply persisted event [ %s ] %d of [ %s ] failed: %s", event.Type, event.Seq, this.PersistenceID, err.Error()))
+		return err
+	}
+	this.sinceSnapshot++
+	if this.sinceSnapshot >= this.SnapshotEvery {
+		if err := this.snapshot(); err != nil {
+			logger.Error(fmt.Sprintf("snapshot [ %s ] failed: %s", this.PersistenceID, err.Error()))
+		}
+	}
+	return nil
+}
+
+//立即保存快照
+func (this *PersistenceComponent) Snapshot() error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.snapshot()
+}
+
+//调用方持有锁
+func (this *PersistenceComponent) snapshot() error {
+	template, err := this.Runtime().Factory().Serialize(this.Parent())
+	if err != nil {
+		return err
+	}
+	data, err := ecs.ObjectTemplateAsJson(template)
+	if err != nil {
+		return err
+	}
+	err = this.journal.SaveSnapshot(&JournalSnapshot{
+		PersistenceID: this.PersistenceID,
+		Seq:           atomic.LoadUint64(&this.seq),
+		Data:          data,
+		Time:          time.Now().UnixNano(),
+	})
+	if err == nil {
+		this.sinceSnapshot = 0
+	}
+	return err
+}
+
+//已应用的最后一个事件序号
+func (this *PersistenceComponent) Seq() uint64 {
+	return atomic.LoadUint64(&this.seq)
+}
+
+func (this *PersistenceComponent) validate(event *JournalEvent) error {
+	cps := this.Parent().AllComponents()
+	for val, err := cps.Next(); err == nil; val, err = cps.Next() {
+		if validator, ok := val.(IEventValidator); ok {
+			if err := validator.ValidateEvent(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (this *PersistenceComponent) apply(event *JournalEvent) error {
+	cps := this.Parent().AllComponents()
+	for val, err := cps.Next(); err == nil; val, err = cps.Next() {
+		if applier, ok := val.(IEventApplier); ok {
+			if err := applier.ApplyEvent(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
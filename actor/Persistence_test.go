@@ -0,0 +1,138 @@
+package Actor
+
+import (
+	"errors"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/utils/UUID"
+	"testing"
+)
+
+var errInsufficient = errors.New("insufficient balance")
+
+//账户状态，余额只通过事件修改
+type testAccount struct {
+	ecs.ComponentBase
+	Balance int64
+	applied int //本实例应用过的事件数
+}
+
+type testAccountState struct {
+	Balance int64
+}
+
+func (this *testAccount) New() ecs.IComponent {
+	return &testAccount{}
+}
+
+func (this *testAccount) Serialize() (interface{}, error) {
+	return ecs.SerializeState(&testAccountState{Balance: this.Balance})
+}
+
+func (this *testAccount) Deserialize(data interface{}) error {
+	state := &testAccountState{}
+	if err := ecs.DeserializeState(state, data); err != nil {
+		return err
+	}
+	this.Balance = state.Balance
+	return nil
+}
+
+func (this *testAccount) ValidateEvent(event *JournalEvent) error {
+	var amount int64
+	if err := event.Decode(&amount); err != nil {
+		return err
+	}
+	if event.Type == "Withdraw" && amount > this.Balance {
+		return errInsufficient
+	}
+	return nil
+}
+
+func (this *testAccount) ApplyEvent(event *JournalEvent) error {
+	var amount int64
+	if err := event.Decode(&amount); err != nil {
+		return err
+	}
+	if event.Type == "Withdraw" {
+		amount = -amount
+	}
+	this.Balance += amount
+	this.applied++
+	return nil
+}
+
+//创建持久化actor，启动时从日志恢复
+func newTestAccount(t *testing.T, proxy *ActorProxyComponent, persistenceID string) (*testAccount, *PersistenceComponent, *ecs.Object) {
+	account := &testAccount{}
+	persistence := NewPersistenceComponent(persistenceID)
+	persistence.SnapshotEvery = 2
+	object := ecs.NewObject("account")
+	err := proxy.Runtime().Root().AddObjectWithComponents(object, []ecs.IComponent{account, persistence, NewActorComponent(ACTOR_TYPE_SYNC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account, persistence, object
+}
+
+func TestPersistValidate(t *testing.T) {
+	proxy := testNode(t)
+	id := "account-" + UUID.Next()
+	account, persistence, object := newTestAccount(t, proxy, id)
+	defer destroyActor(object)
+	if err := persistence.Persist("Deposit", int64(10)); err != nil {
+		t.Fatal(err)
+	}
+	//校验失败的事件不写入日志也不应用
+	if err := persistence.Persist("Withdraw", int64(20)); err != errInsufficient {
+		t.Fatalf("expected the event to be rejected, got %v", err)
+	}
+	if account.Balance != 10 || persistence.Seq() != 1 {
+		t.Fatalf("expected balance 10 at seq 1, got %d at seq %d", account.Balance, persistence.Seq())
+	}
+	journal, err := proxy.getJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs := replayAll(t, journal, id, 0); len(seqs) != 1 {
+		t.Fatalf("rejected events should not be journaled, got %v", seqs)
+	}
+}
+
+func TestPersistenceRecover(t *testing.T) {
+	proxy := testNode(t)
+	id := "account-" + UUID.Next()
+	account, persistence, object := newTestAccount(t, proxy, id)
+	for _, amount := range []int64{10, 20, 5} {
+		if err := persistence.Persist("Deposit", amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if account.Balance != 35 {
+		t.Fatalf("expected balance 35, got %d", account.Balance)
+	}
+	journal, err := proxy.getJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := journal.LoadSnapshot(id)
+	if err != nil || snapshot == nil || snapshot.Seq != 2 {
+		t.Fatalf("expected a snapshot after every 2 events, got %v %v", snapshot, err)
+	}
+	destroyActor(object)
+
+	//从快照恢复，只重放快照之后的事件
+	account, persistence, object = newTestAccount(t, proxy, id)
+	defer destroyActor(object)
+	if account.Balance != 35 || persistence.Seq() != 3 {
+		t.Fatalf("expected balance 35 at seq 3, got %d at seq %d", account.Balance, persistence.Seq())
+	}
+	if account.applied != 1 {
+		t.Fatalf("expected only the event after the snapshot to be replayed, replayed %d", account.applied)
+	}
+	if err := persistence.Persist("Withdraw", int64(15)); err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 20 || persistence.Seq() != 4 {
+		t.Fatalf("expected balance 20 at seq 4, got %d at seq %d", account.Balance, persistence.Seq())
+	}
+}
@@ -0,0 +1,236 @@
+package Actor
+
+import (
+	"fmt"
+	"github.com/zllangct/rockgo/logger"
+	"sync"
+	"time"
+)
+
+/*
+	发布订阅
+	actor可在任意节点订阅主题，节点上第一个订阅者加入时在master登记本节点，最后一个离开时注销。
+	发布时直接投递给本地订阅者，并向登记了该主题的其他节点各单向发送一次，由其分发给本地订阅者。
+	在master登记和注销不持有本地订阅者锁，节点被master移出后重新加入时重新登记。
+	actor销毁时自动退订。订阅节点列表在发布方缓存TOPIC_NODES_CACHE_TTL，新节点的订阅在此时长内可能收不到消息。
+*/
+
+const TOPIC_NODES_CACHE_TTL = time.Second
+
+type ActorTopicMessage struct {
+	Topic   string
+	Sender  ActorID
+	Message *ActorMessage
+}
+
+type topicNodes struct {
+	nodes  []string
+	expire time.Time
+}
+
+//订阅主题
+func (this *ActorProxyComponent) Subscribe(topic string, actor IActor) error {
+	id := actor.ID().String()
+	this.topicLocker.Lock()
+	if this.topics == nil {
+		this.topics = make(map[string]map[string]IActor)
+	}
+	subscribers, ok := this.topics[topic]
+	if !ok {
+		subscribers = make(map[string]IActor)
+		this.topics[topic] = subscribers
+	}
+	subscribers[id] = actor
+	this.topicLocker.Unlock()
+
+	if err := this.syncTopic(topic); err != nil {
+		this.topicLocker.Lock()
+		this.removeSubscriber(topic, id)
+		this.topicLocker.Unlock()
+		return err
+	}
+	return nil
+}
+
+//退订主题
+func (this *ActorProxyComponent) Unsubscribe(topic string, actor IActor) {
+	this.topicLocker.Lock()
+	emptied := this.removeSubscriber(topic, actor.ID().String())
+	this.topicLocker.Unlock()
+	if emptied {
+		this.unregisterTopic(topic)
+	}
+}
+
+//退订actor的所有主题
+func (this *ActorProxyComponent) unsubscribeAll(actor IActor) {
+	id := actor.ID().String()
+	var emptied []string
+	this.topicLocker.Lock()
+	for topic := range this.topics {
+		if this.removeSubscriber(topic, id) {
+			emptied = append(emptied, topic)
+		}
+	}
+	this.topicLocker.Unlock()
+	for _, topic := range emptied {
+		this.unregisterTopic(topic)
+	}
+}
+
+//移除本地订阅者，返回主题是否已没有本地订阅者，调用方持有锁
+func (this *ActorProxyComponent) removeSubscriber(topic string, id string) bool {
+	subscribers, ok := this.topics[topic]
+	if !ok {
+		return false
+	}
+	if _, ok := subscribers[id]; !ok {
+		return false
+	}
+	delete(subscribers, id)
+	if len(subscribers) > 0 {
+		return false
+	}
+	delete(this.topics, topic)
+	return true
+}
+
+func (this *ActorProxyComponent) unregisterTopic(topic string) {
+	if err := this.syncTopic(topic); err != nil {
+		logger.Warn(fmt.Sprintf("unsubscribe topic [ %s ] failed: %s", topic, err.Error()))
+	}
+}
+
+//按本地是否有订阅者在master登记或注销本节点
+//master调用不持有topicLocker，同一时间只有一个同步，并发的订阅和退订以最后的订阅者状态为准
+func (this *ActorProxyComponent) syncTopic(topic string) error {
+	this.topicSyncLocker.Lock()
+	defer this.topicSyncLocker.Unlock()
+	this.topicLocker.Lock()
+	subscribed := len(this.topics[topic]) > 0
+	this.topicLocker.Unlock()
+	if subscribed == this.topicRegistered[topic] {
+		return nil
+	}
+
+	ctx, cancel := this.timeoutContext()
+	defer cancel()
+	if subscribed {
+		if err := this.nodeComponent.SubscribeTopic(ctx, topic); err != nil {
+			return err
+		}
+		if this.topicRegistered == nil {
+			this.topicRegistered = make(map[string]bool)
+		}
+		this.topicRegistered[topic] = true
+		return nil
+	}
+	if err := this.nodeComponent.UnsubscribeTopic(ctx, topic); err != nil {
+		return err
+	}
+	delete(this.topicRegistered, topic)
+	return nil
+}
+
+//重新加入集群时master已删除本节点的订阅，重新登记有本地订阅者的主题
+func (this *ActorProxyComponent) resubscribeTopics() {
+	this.topicSyncLocker.Lock()
+	this.topicRegistered = nil
+	this.topicSyncLocker.Unlock()
+
+	this.topicLocker.Lock()
+	topics := make([]string, 0, len(this.topics))
+	for topic := range this.topics {
+		topics = append(topics, topic)
+	}
+	this.topicLocker.Unlock()
+	for _, topic := range topics {
+		if err := this.syncTopic(topic); err != nil {
+			logger.Warn(fmt.Sprintf("subscribe topic [ %s ] again failed: %s", topic, err.Error()))
+		}
+	}
+}
+
+//发布消息，sender可为空
+func (this *ActorProxyComponent) Publish(sender IActor, topic string, message *ActorMessage) error {
+	this.publishLocal(sender, topic, message)
+
+	nodes, err := this.topicNodes(topic)
+	if err != nil {
+		return err
+	}
+	args := &ActorTopicMessage{Topic: topic, Message: message}
+	if sender != nil {
+		args.Sender = sender.ID()
+	}
+	for _, node := range nodes {
+		if node == this.nodeID {
+			continue
+		}
+		client, err := this.nodeComponent.GetNodeClient(node)
+		if err == nil {
+			err = client.Go("ActorProxyService.Publish", args, nil, nil).Error
+		}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("publish topic [ %s ] to [ %s ] failed: %s", topic, node, err.Error()))
+		}
+	}
+	return nil
+}
+
+//投递给本地订阅者
+func (this *ActorProxyComponent) publishLocal(sender IActor, topic string, message *ActorMessage) {
+	this.topicLocker.Lock()
+	subscribers := make([]IActor, 0, len(this.topics[topic]))
+	for _, actor := range this.topics[topic] {
+		subscribers = append(subscribers, actor)
+	}
+	this.topicLocker.Unlock()
+
+	//并发投递，邮箱满而阻塞的订阅者不拖慢其他订阅者；等待全部投递完，同一发布方的消息对每个订阅者保持顺序
+	wg := sync.WaitGroup{}
+	for _, actor := range subscribers {
+		wg.Add(1)
+		go func(actor IActor) {
+			defer wg.Done()
+			ctx, cancel := this.timeoutContext()
+			defer cancel()
+			if err := actor.TellContext(ctx, sender, message); err != nil {
+				logger.Warn(fmt.Sprintf("publish topic [ %s ] to actor [ %s ] failed: %s", topic, actor.ID().String(), err.Error()))
+			}
+		}(actor)
+	}
+	wg.Wait()
+}
+
+//订阅了主题的节点，带缓存
+func (this *ActorProxyComponent) topicNodes(topic string) ([]string, error) {
+	if v, ok := this.topicCache.Load(topic); ok {
+		cache := v.(*topicNodes)
+		if time.Now().Before(cache.expire) {
+			return cache.nodes, nil
+		}
+	}
+	ctx, cancel := this.timeoutContext()
+	defer cancel()
+	nodes, err := this.nodeComponent.TopicNodes(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	this.topicCache.Store(topic, &topicNodes{nodes: nodes, expire: time.Now().Add(TOPIC_NODES_CACHE_TTL)})
+	return nodes, nil
+}
+
+//订阅主题
+func (this *ActorComponent) Subscribe(topic string) error {
+	return this.Proxy.Subscribe(topic, this)
+}
+
+func (this *ActorComponent) Unsubscribe(topic string) {
+	this.Proxy.Unsubscribe(topic, this)
+}
+
+//以该actor为发送方发布消息
+func (this *ActorComponent) Publish(topic string, message *ActorMessage) error {
+	return this.Proxy.Publish(this, topic, message)
+}
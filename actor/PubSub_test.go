@@ -0,0 +1,129 @@
+package Actor
+
+import (
+	"github.com/zllangct/rockgo/utils/UUID"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//主题是否登记了本节点
+func topicRegistered(t *testing.T, proxy *ActorProxyComponent, topic string) bool {
+	t.Helper()
+	ctx, cancel := proxy.timeoutContext()
+	defer cancel()
+	nodes, err := proxy.nodeComponent.TopicNodes(ctx, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if node == proxy.nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSubscribe(t *testing.T) {
+	proxy := testNode(t)
+	topic := "topic-" + UUID.Next()
+	var received int32
+	handlers := map[string]func(message *ActorMessageInfo) error{
+		"News": func(message *ActorMessageInfo) error {
+			atomic.AddInt32(&received, 1)
+			return nil
+		},
+	}
+	first := NewActorComponent(ACTOR_TYPE_SYNC)
+	second := NewActorComponent(ACTOR_TYPE_SYNC)
+	defer destroyActor(newTestActor(t, proxy, first, handlers))
+	defer destroyActor(newTestActor(t, proxy, second, handlers))
+
+	for _, actor := range []*ActorComponent{first, second} {
+		if err := actor.Subscribe(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !topicRegistered(t, proxy, topic) {
+		t.Fatal("the node should be registered for the topic")
+	}
+	if err := proxy.Publish(nil, topic, NewActorMessage("News")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "both subscribers receive the message", func() bool {
+		return atomic.LoadInt32(&received) == 2
+	})
+
+	//最后一个订阅者退订时注销本节点
+	first.Unsubscribe(topic)
+	if !topicRegistered(t, proxy, topic) {
+		t.Fatal("the node should stay registered while it has subscribers")
+	}
+	second.Unsubscribe(topic)
+	if topicRegistered(t, proxy, topic) {
+		t.Fatal("the node should be unregistered after the last subscriber left")
+	}
+}
+
+func TestPublishLocalConcurrent(t *testing.T) {
+	proxy := testNode(t)
+	topic := "topic-" + UUID.Next()
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	slow := NewActorComponent(ACTOR_TYPE_SYNC)
+	slow.MailboxCapacity = 1
+	slow.MailboxTimeout = time.Second * 5
+	defer destroyActor(newTestActor(t, proxy, slow, map[string]func(message *ActorMessageInfo) error{
+		"News": func(message *ActorMessageInfo) error {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+			return nil
+		},
+	}))
+	var received int32
+	fast := NewActorComponent(ACTOR_TYPE_SYNC)
+	defer destroyActor(newTestActor(t, proxy, fast, map[string]func(message *ActorMessageInfo) error{
+		"News": func(message *ActorMessageInfo) error {
+			atomic.AddInt32(&received, 1)
+			return nil
+		},
+	}))
+	for _, actor := range []*ActorComponent{slow, fast} {
+		if err := actor.Subscribe(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//慢订阅者处理中且邮箱已满
+	proxy.publishLocal(nil, topic, NewActorMessage("News"))
+	<-started
+	proxy.publishLocal(nil, topic, NewActorMessage("News"))
+	//再次发布时向慢订阅者投递会阻塞，不影响其他订阅者
+	go proxy.publishLocal(nil, topic, NewActorMessage("News"))
+	waitFor(t, time.Second, "the fast subscriber receives every message", func() bool {
+		return atomic.LoadInt32(&received) == 3
+	})
+}
+
+func TestRejoinSubscribesTopics(t *testing.T) {
+	proxy := testNode(t)
+	topic := "topic-" + UUID.Next()
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	defer destroyActor(newTestActor(t, proxy, actor, nil))
+	if err := actor.Subscribe(topic); err != nil {
+		t.Fatal(err)
+	}
+	defer actor.Unsubscribe(topic)
+
+	evict(t, proxy)
+	if topicRegistered(t, proxy, topic) {
+		t.Fatal("topics of a removed node should be dropped")
+	}
+	waitFor(t, time.Second*3, "the topic is registered again after rejoining", func() bool {
+		return topicRegistered(t, proxy, topic)
+	})
+}
@@ -0,0 +1,101 @@
+package Actor
+
+import (
+	"context"
+	"fmt"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/timer"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	actor定时消息
+	到期时将消息投递到actor自己的邮箱，与其他消息一样由dispatch分发，不需要在handler外另起goroutine。
+	定时基于timer包的时间轮，取消时从时间轮移除；actor销毁时自动取消。
+*/
+
+// Schedule is a pending scheduled message of an actor.
+type Schedule struct {
+	locker sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *timer.Timer
+	actor  *ActorComponent
+}
+
+//取消定时，可重复调用
+func (this *Schedule) Cancel() {
+	this.cancel()
+	this.locker.Lock()
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+	this.locker.Unlock()
+	this.actor.schedules.Delete(this)
+}
+
+//已取消或已完成时关闭
+func (this *Schedule) Done() <-chan struct{} {
+	return this.ctx.Done()
+}
+
+//在时间轮上等待下一次投递，已取消时不再加入
+func (this *Schedule) after(d time.Duration, f func()) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.ctx.Err() != nil {
+		return
+	}
+	this.timer = timer.AfterFunc(d, f)
+}
+
+//delay后向自己发送一次消息
+func (this *ActorComponent) ScheduleOnce(delay time.Duration, message *ActorMessage) *Schedule {
+	return this.schedule(delay, 0, message)
+}
+
+//delay后向自己发送消息，之后每隔interval发送一次，直到取消
+func (this *ActorComponent) ScheduleRepeat(delay time.Duration, interval time.Duration, message *ActorMessage) *Schedule {
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return this.schedule(delay, interval, message)
+}
+
+func (this *ActorComponent) schedule(delay time.Duration, interval time.Duration, message *ActorMessage) *Schedule {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Schedule{ctx: ctx, cancel: cancel, actor: this}
+	this.schedules.Store(s, struct{}{})
+	var fire func()
+	fire = func() {
+		if ctx.Err() != nil {
+			return
+		}
+		err := this.receive(ctx, &ActorMessageInfo{Sender: this, Message: message, ctx: context.Background()})
+		if err != nil && ctx.Err() == nil {
+			logger.Warn(fmt.Sprintf("actor [ %s ] scheduled message [ %s ] failed: %s", this.ID().String(), message.Service, err.Error()))
+			if atomic.LoadInt32(&this.active) == 0 {
+				s.Cancel()
+				return
+			}
+		}
+		if interval <= 0 {
+			s.Cancel()
+			return
+		}
+		s.after(interval, fire)
+	}
+	s.after(delay, fire)
+	return s
+}
+
+//取消所有定时，actor销毁时调用
+func (this *ActorComponent) cancelSchedules() {
+	this.schedules.Range(func(key, value interface{}) bool {
+		key.(*Schedule).Cancel()
+		return true
+	})
+}
+
@@ -0,0 +1,105 @@
+package Actor
+
+import (
+	"github.com/zllangct/rockgo/ecs"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//按服务名计数收到的定时消息
+func newTestScheduler(t *testing.T, proxy *ActorProxyComponent) (*ActorComponent, func(service string) int32, *ecs.Object) {
+	var once, repeat int32
+	actor := NewActorComponent(ACTOR_TYPE_SYNC)
+	object := newTestActor(t, proxy, actor, map[string]func(message *ActorMessageInfo) error{
+		"Once": func(message *ActorMessageInfo) error {
+			atomic.AddInt32(&once, 1)
+			return nil
+		},
+		"Repeat": func(message *ActorMessageInfo) error {
+			atomic.AddInt32(&repeat, 1)
+			return nil
+		},
+	})
+	count := func(service string) int32 {
+		if service == "Once" {
+			return atomic.LoadInt32(&once)
+		}
+		return atomic.LoadInt32(&repeat)
+	}
+	return actor, count, object
+}
+
+func pending(actor *ActorComponent) int {
+	n := 0
+	actor.schedules.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestScheduleOnce(t *testing.T) {
+	actor, count, object := newTestScheduler(t, testNode(t))
+	defer destroyActor(object)
+	start := time.Now()
+	s := actor.ScheduleOnce(time.Millisecond*50, NewActorMessage("Once"))
+	waitFor(t, time.Second, "the scheduled message is received", func() bool {
+		return count("Once") == 1
+	})
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Fatalf("the message should be sent after the delay, sent after %v", elapsed)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("a finished schedule should be done")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if n := count("Once"); n != 1 {
+		t.Fatalf("expected the message once, got %d", n)
+	}
+	if n := pending(actor); n != 0 {
+		t.Fatalf("finished schedules should be removed, %d left", n)
+	}
+}
+
+func TestScheduleRepeat(t *testing.T) {
+	actor, count, object := newTestScheduler(t, testNode(t))
+	defer destroyActor(object)
+	s := actor.ScheduleRepeat(0, time.Millisecond*10, NewActorMessage("Repeat"))
+	waitFor(t, time.Second, "the message is repeated", func() bool {
+		return count("Repeat") >= 3
+	})
+	//取消后不再发送
+	s.Cancel()
+	s.Cancel()
+	<-s.Done()
+	time.Sleep(time.Millisecond * 30)
+	n := count("Repeat")
+	time.Sleep(time.Millisecond * 50)
+	if count("Repeat") != n {
+		t.Fatal("a cancelled schedule should not send again")
+	}
+	waitFor(t, time.Second, "the cancelled schedule is removed", func() bool {
+		return pending(actor) == 0
+	})
+}
+
+func TestScheduleCancel(t *testing.T) {
+	actor, count, object := newTestScheduler(t, testNode(t))
+	s := actor.ScheduleOnce(time.Millisecond*50, NewActorMessage("Once"))
+	s.Cancel()
+	//actor销毁时取消所有定时
+	repeat := actor.ScheduleRepeat(time.Millisecond*50, time.Millisecond*10, NewActorMessage("Repeat"))
+	destroyActor(object)
+	select {
+	case <-repeat.Done():
+	case <-time.After(time.Second):
+		t.Fatal("schedules should be cancelled when the actor is destroyed")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if count("Once") != 0 || count("Repeat") != 0 {
+		t.Fatalf("cancelled schedules should not send, got %d and %d", count("Once"), count("Repeat"))
+	}
+}
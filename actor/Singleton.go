@@ -0,0 +1,387 @@
+package Actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/cluster"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/logger"
+	"sync"
+	"time"
+)
+
+/*
+	集群单例
+	全局匹配队列、排行榜等在集群中只能运行一个实例的actor。在可承载单例的节点上注册单例类型，
+	各节点通过master租约竞争，持有租约的节点创建单例actor，并以单例名登记到actor目录。
+	持有节点离开、排空或失去租约时单例停止，由最早加入的下一个节点重新创建。
+	单例状态不随之迁移，需要保留状态时在Components中加入状态组件和PersistenceComponent。
+	GetSingleton返回稳定的引用，消息按actor目录发送到单例当前所在的节点，单例重启中时等待其重新登记。
+	单例地址在发送方缓存SINGLETON_CACHE_TTL，单例所在节点失联时在此时长后重新解析。
+*/
+
+const (
+	SINGLETON_CACHE_TTL        = time.Second
+	SINGLETON_RESOLVE_INTERVAL = time.Millisecond * 100
+)
+
+var ErrSingletonUnavailable = errors.New("singleton is not running")
+
+// SingletonType describes a cluster singleton. It must be registered on every
+// node that may host the singleton.
+type SingletonType struct {
+	Name       string                  //单例名
+	Role       string                  //承载单例的节点角色，为空时不限角色
+	ActorType  ActorType               //actor类型
+	Components []ecs.ComponentProvider //创建时添加到实体上的组件，在ActorComponent之前
+}
+
+type singletonAddress struct {
+	id     ActorID
+	expire time.Time
+}
+
+type singletonManager struct {
+	locker   sync.Mutex
+	typ      *SingletonType
+	proxy    *ActorProxyComponent
+	object   *ecs.Object
+	deadline time.Time   //本地租约到期时间
+	expiry   *time.Timer //到期时停止单例
+	stop     chan struct{}
+	stopped  bool
+}
+
+//单例在actor目录中的名字
+func singletonName(name string) string {
+	return "singleton:" + name
+}
+
+//注册单例类型，本节点拥有Role角色时参与竞争租约
+func (this *ActorProxyComponent) RegisterSingleton(singleton *SingletonType) {
+	for _, provider := range singleton.Components {
+		this.Runtime().Factory().Register(provider)
+	}
+	if !hasRole(singleton.Role) {
+		return
+	}
+	this.grainLocker.Lock()
+	if this.singletons == nil {
+		this.singletons = make(map[string]*singletonManager)
+	}
+	if _, ok := this.singletons[singleton.Name]; ok {
+		this.grainLocker.Unlock()
+		return
+	}
+	m := &singletonManager{typ: singleton, proxy: this, stop: make(chan struct{})}
+	this.singletons[singleton.Name] = m
+	this.grainLocker.Unlock()
+	go m.run()
+}
+
+func hasRole(role string) bool {
+	if role == "" {
+		return true
+	}
+	for _, r := range config.Config.ClusterConfig.Role {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//获取单例的引用
+func (this *ActorProxyComponent) GetSingleton(name string) IActor {
+	return &Singleton{name: name, proxy: this}
+}
+
+//停止本节点上的单例并释放租约，停机时调用
+func (this *ActorProxyComponent) releaseSingletons() {
+	this.grainLocker.Lock()
+	managers := make([]*singletonManager, 0, len(this.singletons))
+	for _, m := range this.singletons {
+		managers = append(managers, m)
+	}
+	this.singletons = nil
+	this.grainLocker.Unlock()
+	for _, m := range managers {
+		m.shutdown()
+	}
+}
+
+//查询单例当前地址，单例未登记时等待其登记，ctx无截止时间时最多等待RpcCallTimeout
+func (this *ActorProxyComponent) resolveSingleton(ctx context.Context, name string) (ActorID, error) {
+	if v, ok := this.singletonCache.Load(name); ok {
+		cache := v.(*singletonAddress)
+		if time.Now().Before(cache.expire) {
+			return cache.id, nil
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.Config.ClusterConfig.RpcCallTimeout)*time.Millisecond)
+		defer cancel()
+	}
+	for {
+		addr, err := this.nodeComponent.ResolveActor(ctx, singletonName(name))
+		if err == nil {
+			id := EmptyActorID()
+			if err = id.Parse(addr); err != nil {
+				return nil, err
+			}
+			this.singletonCache.Store(name, &singletonAddress{id: id, expire: time.Now().Add(SINGLETON_CACHE_TTL)})
+			return id, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ErrSingletonUnavailable
+		}
+		if err != Cluster.ErrActorNotFound {
+			return nil, err
+		}
+		retry := time.NewTimer(SINGLETON_RESOLVE_INTERVAL)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return nil, ErrSingletonUnavailable
+		case <-retry.C:
+		}
+	}
+}
+
+//按租约启停单例，每三分之一租约时长续约一次
+func (this *singletonManager) run() {
+	ttl := time.Duration(config.Config.ClusterConfig.SingletonLeaseTTL) * time.Millisecond
+	if ttl <= 0 {
+		ttl = time.Second * 10
+	}
+	interval := ttl / 3
+	for {
+		sent := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		lease, err := this.proxy.nodeComponent.AcquireSingleton(ctx, this.typ.Name, ttl)
+		cancel()
+		if err != nil {
+			//续约失败时运行到本地租约到期
+			logger.Warn(fmt.Sprintf("acquire singleton [ %s ] failed: %s", this.typ.Name, err.Error()))
+		} else {
+			this.update(sent, lease)
+		}
+		next := time.NewTimer(interval - time.Since(sent))
+		select {
+		case <-this.stop:
+			next.Stop()
+			return
+		case <-next.C:
+		}
+	}
+}
+
+//按master返回的租约启停单例，sent为发出请求的时间
+func (this *singletonManager) update(sent time.Time, lease *Cluster.SingletonLease) {
+	//master返回收到请求时的剩余时长，本地从发出请求时起算，本地总是先到期
+	deadline := sent.Add(time.Duration(lease.Remaining))
+	switch {
+	case lease.Node != this.proxy.nodeID:
+		this.release()
+	case lease.Renewed:
+		this.hold(deadline)
+	default:
+		//仍持有但未被续约(排空中)，不延长本地租约，到期后由下一个节点接手
+		this.shorten(deadline)
+	}
+}
+
+//本地租约不晚于deadline到期，不创建单例
+func (this *singletonManager) shorten(deadline time.Time) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.stopped || this.object == nil || !deadline.Before(this.deadline) {
+		return
+	}
+	this.deadline = deadline
+	this.expiry.Reset(time.Until(deadline))
+}
+
+//持有租约到deadline，未运行时创建单例
+func (this *singletonManager) hold(deadline time.Time) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.stopped {
+		return
+	}
+	this.deadline = deadline
+	if this.expiry == nil {
+		this.expiry = time.AfterFunc(time.Until(deadline), this.expire)
+	} else {
+		this.expiry.Reset(time.Until(deadline))
+	}
+	if this.object == nil {
+		if err := this.start(); err != nil {
+			logger.Error(fmt.Sprintf("start singleton [ %s ] failed: %s", this.typ.Name, err.Error()))
+		}
+	}
+}
+
+//租约由其他节点持有或空缺
+func (this *singletonManager) release() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.expiry != nil {
+		this.expiry.Stop()
+	}
+	this.stopSingleton()
+}
+
+//本地租约到期
+func (this *singletonManager) expire() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if time.Now().Before(this.deadline) {
+		//到期前已续约
+		return
+	}
+	if this.object != nil {
+		logger.Warn(fmt.Sprintf("singleton [ %s ] lease expired", this.typ.Name))
+	}
+	this.stopSingleton()
+}
+
+//停止续约、停止单例并释放租约
+func (this *singletonManager) shutdown() {
+	this.locker.Lock()
+	if this.stopped {
+		this.locker.Unlock()
+		return
+	}
+	this.stopped = true
+	close(this.stop)
+	if this.expiry != nil {
+		this.expiry.Stop()
+	}
+	this.stopSingleton()
+	this.locker.Unlock()
+
+	ctx, cancel := this.proxy.timeoutContext()
+	defer cancel()
+	if err := this.proxy.nodeComponent.ReleaseSingleton(ctx, this.typ.Name); err != nil {
+		logger.Warn(fmt.Sprintf("release singleton [ %s ] failed: %s", this.typ.Name, err.Error()))
+	}
+}
+
+//创建单例actor，调用方持有锁
+func (this *singletonManager) start() error {
+	components := make([]ecs.IComponent, 0, len(this.typ.Components)+1)
+	for _, provider := range this.typ.Components {
+		components = append(components, provider.New())
+	}
+	//ActorComponent在最后，开始接收消息前其他组件已初始化，以单例名登记到actor目录
+	actor := NewActorComponent(this.typ.ActorType)
+	actor.Name = singletonName(this.typ.Name)
+	components = append(components, actor)
+	object := ecs.NewObject(singletonName(this.typ.Name))
+	if err := this.proxy.Runtime().Root().AddObjectWithComponents(object, components); err != nil {
+		return err
+	}
+	this.object = object
+	logger.Info(fmt.Sprintf("singleton [ %s ] started as [ %s ]", this.typ.Name, actor.ID().String()))
+	return nil
+}
+
+//停止单例actor，调用方持有锁
+func (this *singletonManager) stopSingleton() {
+	if this.object == nil {
+		return
+	}
+	if err := destroyActor(this.object); err != nil {
+		logger.Error(err)
+	}
+	this.object = nil
+	this.proxy.singletonCache.Delete(this.typ.Name)
+	logger.Info(fmt.Sprintf("singleton [ %s ] stopped", this.typ.Name))
+}
+
+// Singleton is a reference to a cluster singleton. Messages are sent to the
+// node that currently runs it.
+type Singleton struct {
+	name  string
+	proxy *ActorProxyComponent
+}
+
+func (this *Singleton) Name() string {
+	return this.name
+}
+
+//当前地址，未解析过时为空
+func (this *Singleton) ID() ActorID {
+	if v, ok := this.proxy.singletonCache.Load(this.name); ok {
+		return v.(*singletonAddress).id
+	}
+	return EmptyActorID()
+}
+
+func (this *Singleton) Tell(sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	return this.TellContext(context.Background(), sender, message, reply...)
+}
+
+//单例已停止时重新解析一次
+func (this *Singleton) TellContext(ctx context.Context, sender IActor, message *ActorMessage, reply ...**ActorMessage) error {
+	id, err := this.proxy.resolveSingleton(ctx, this.name)
+	if err != nil {
+		return err
+	}
+	err = NewActor(id, this.proxy).TellContext(ctx, sender, message, reply...)
+	if !singletonMoved(err) {
+		return err
+	}
+	if id, err = this.reresolve(ctx, id); err != nil {
+		return err
+	}
+	return NewActor(id, this.proxy).TellContext(ctx, sender, message, reply...)
+}
+
+//Ask单例，单例已停止时重新解析一次
+func (this *Singleton) ask(ctx context.Context, sender IActor, message *ActorMessage) *Future {
+	id, err := this.proxy.resolveSingleton(ctx, this.name)
+	if err != nil {
+		return completedFuture(nil, err)
+	}
+	f := newFuture()
+	this.proxy.ask(ctx, sender, id, message).OnComplete(func(reply *ActorMessage, err error) {
+		if !singletonMoved(err) {
+			f.complete(reply, err)
+			return
+		}
+		//重新解析可能需要等待，不在回调中阻塞
+		go func() {
+			id, err := this.reresolve(ctx, id)
+			if err != nil {
+				f.complete(nil, err)
+				return
+			}
+			this.proxy.ask(ctx, sender, id, message).OnComplete(func(reply *ActorMessage, err error) {
+				f.complete(reply, err)
+			})
+		}()
+	})
+	return f
+}
+
+//丢弃失效的地址并重新解析
+func (this *Singleton) reresolve(ctx context.Context, stale ActorID) (ActorID, error) {
+	this.proxy.singletonCache.Delete(this.name)
+	dctx, cancel := this.proxy.timeoutContext()
+	this.proxy.nodeComponent.UnregisterActor(dctx, singletonName(this.name), stale.String())
+	cancel()
+	return this.proxy.resolveSingleton(ctx, this.name)
+}
+
+//单例已不在原地址
+func singletonMoved(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err.Error() == ErrNoThisActor.Error() || err.Error() == ErrActorInactive.Error()
+}
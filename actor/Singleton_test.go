@@ -0,0 +1,75 @@
+package Actor
+
+import (
+	"github.com/zllangct/rockgo/cluster"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/utils/UUID"
+	"testing"
+	"time"
+)
+
+func (this *singletonManager) running() (bool, time.Time) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.object != nil, this.deadline
+}
+
+//排空中的持有者不被续约，本地单例在master租约到期前停止，之后才可能分配给其他节点
+func TestSingletonHandover(t *testing.T) {
+	proxy := testNode(t)
+	var master *Cluster.MasterComponent
+	if err := proxy.Runtime().Root().Find(&master); err != nil {
+		t.Fatal(err)
+	}
+	const ttl = time.Millisecond * 300
+	name := "singleton-" + UUID.Next()
+	m := &singletonManager{typ: &SingletonType{Name: name, ActorType: ACTOR_TYPE_SYNC}, proxy: proxy, stop: make(chan struct{})}
+	defer m.shutdown()
+
+	sent := time.Now()
+	lease, err := master.AcquireSingleton(name, proxy.nodeID, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.update(sent, lease)
+	if running, deadline := m.running(); !running || deadline.After(sent.Add(ttl)) {
+		t.Fatalf("the granted singleton should run until at most %v, running %v until %v", sent.Add(ttl), running, deadline)
+	}
+
+	//租约过半时本节点进入排空
+	time.Sleep(ttl / 2)
+	drain := func(draining bool) {
+		master.UpdateNodeInfo(&Cluster.NodeInfo{Address: proxy.nodeID, Role: config.Config.ClusterConfig.Role, Draining: draining})
+	}
+	defer drain(false)
+	waitFor(t, time.Second, "the draining holder is not renewed", func() bool {
+		drain(true)
+		sent = time.Now()
+		if lease, err = master.AcquireSingleton(name, proxy.nodeID, ttl); err != nil {
+			t.Fatal(err)
+		}
+		return !lease.Renewed
+	})
+	m.update(sent, lease)
+	held, _ := master.ResolveSingleton(name)
+	if held.Node != proxy.nodeID {
+		t.Fatalf("the lease should still be held by this node, got %+v", held)
+	}
+	expire := time.Unix(0, held.Expire)
+	if running, deadline := m.running(); !running || deadline.After(expire) {
+		t.Fatalf("the local lease should end by the master lease at %v, running %v until %v", expire, running, deadline)
+	}
+
+	waitFor(t, ttl, "the local singleton stops", func() bool {
+		running, _ := m.running()
+		return !running
+	})
+	if stopped := time.Now(); stopped.After(expire.Add(time.Millisecond * 50)) {
+		t.Fatalf("the local singleton should stop when the master lease expires at %v, stopped at %v", expire, stopped)
+	}
+	//未续约的租约不会重新启动单例
+	m.update(time.Now(), &Cluster.SingletonLease{Name: name, Node: proxy.nodeID, Remaining: int64(ttl)})
+	if running, _ := m.running(); running {
+		t.Fatal("a lease that is not renewed should not start the singleton")
+	}
+}
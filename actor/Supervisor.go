@@ -0,0 +1,240 @@
+package Actor
+
+import (
+	"fmt"
+	"github.com/zllangct/rockgo/ecs"
+	"github.com/zllangct/rockgo/logger"
+	"sync"
+	"time"
+)
+
+/*
+	监督树
+	SupervisorComponent挂载在父实体上，监督其子实体。子实体中的actor处理消息时panic，监督者按策略销毁并用
+	ObjectFactory从子实体初始模板重建，丢弃可能已损坏的状态。时间窗口内重启次数超过上限时上报给上一级监督者，
+	由其重建本实体；没有上一级监督者时停止失败的子实体。
+	子实体上的组件需在Runtime.Factory()中注册。
+*/
+
+const (
+	SUPERVISOR_ONE_FOR_ONE  SupervisorStrategy = iota //只重启失败的子实体
+	SUPERVISOR_ONE_FOR_ALL                            //重启所有子实体
+	SUPERVISOR_REST_FOR_ONE                           //重启失败的子实体及在其之后加入监督的子实体
+)
+
+type SupervisorStrategy int
+
+type supervisedChild struct {
+	object   *ecs.Object
+	template *ecs.ObjectTemplate
+}
+
+type SupervisorComponent struct {
+	ecs.ComponentBase
+	Strategy    SupervisorStrategy
+	MaxRestarts int           //时间窗口内最多重启次数，默认3
+	Within      time.Duration //时间窗口，默认5秒
+
+	locker   sync.Mutex
+	children []*supervisedChild
+	restarts []time.Time
+}
+
+func NewSupervisorComponent(strategy SupervisorStrategy, maxRestarts int, within time.Duration) *SupervisorComponent {
+	return &SupervisorComponent{Strategy: strategy, MaxRestarts: maxRestarts, Within: within}
+}
+
+func (this *SupervisorComponent) IsUnique() int {
+	return ecs.UNIQUE_TYPE_LOCAL
+}
+
+func (this *SupervisorComponent) New() ecs.IComponent {
+	return &SupervisorComponent{}
+}
+
+type supervisorState struct {
+	Strategy    SupervisorStrategy
+	MaxRestarts int
+	Within      time.Duration
+}
+
+func (this *SupervisorComponent) Serialize() (interface{}, error) {
+	return ecs.SerializeState(&supervisorState{Strategy: this.Strategy, MaxRestarts: this.MaxRestarts, Within: this.Within})
+}
+
+func (this *SupervisorComponent) Deserialize(data interface{}) error {
+	state := &supervisorState{}
+	if err := ecs.DeserializeState(state, data); err != nil {
+		return err
+	}
+	this.Strategy, this.MaxRestarts, this.Within = state.Strategy, state.MaxRestarts, state.Within
+	return nil
+}
+
+//监督已有的子实体
+func (this *SupervisorComponent) Awake(ctx *ecs.Context) {
+	if this.MaxRestarts <= 0 {
+		this.MaxRestarts = 3
+	}
+	if this.Within <= 0 {
+		this.Within = time.Second * 5
+	}
+	for _, child := range this.objects() {
+		if err := this.Supervise(child); err != nil {
+			logger.Error(fmt.Sprintf("supervise [ %s ] failed: %s", child.Name(), err.Error()))
+		}
+	}
+}
+
+//父实体当前的子实体，在父实体的锁内读取，避免与重启时增删子实体并发
+func (this *SupervisorComponent) objects() []*ecs.Object {
+	var objects []*ecs.Object
+	this.Parent().WithLock(func() error {
+		children := this.Parent().Objects()
+		for val, err := children.Next(); err == nil; val, err = children.Next() {
+			objects = append(objects, val.(*ecs.Object))
+		}
+		return nil
+	})
+	return objects
+}
+
+//监督子实体，记录其当前模板用于重启
+func (this *SupervisorComponent) Supervise(child *ecs.Object) error {
+	if child.Parent() != this.Parent() {
+		return ecs.ErrBadObject
+	}
+	template, err := this.Runtime().Factory().Serialize(child)
+	if err != nil {
+		return err
+	}
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	for _, c := range this.children {
+		if c.object == child {
+			c.template = template
+			return nil
+		}
+	}
+	this.children = append(this.children, &supervisedChild{object: child, template: template})
+	return nil
+}
+
+//被监督的子实体，按加入监督的顺序
+func (this *SupervisorComponent) Children() []*ecs.Object {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	this.prune()
+	children := make([]*ecs.Object, 0, len(this.children))
+	for _, c := range this.children {
+		children = append(children, c.object)
+	}
+	return children
+}
+
+//子实体失败，按策略重启，重启过于频繁时上报
+func (this *SupervisorComponent) childFailed(child *ecs.Object, reason error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	this.prune()
+	index := -1
+	for i, c := range this.children {
+		if c.object == child {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		//未被监督或已重启
+		return
+	}
+
+	now := time.Now()
+	restarts := this.restarts[:0]
+	for _, t := range this.restarts {
+		if now.Sub(t) < this.Within {
+			restarts = append(restarts, t)
+		}
+	}
+	this.restarts = restarts
+	if len(this.restarts) >= this.MaxRestarts {
+		this.escalate(index, reason)
+		return
+	}
+	this.restarts = append(this.restarts, now)
+
+	var targets []int
+	switch this.Strategy {
+	case SUPERVISOR_ONE_FOR_ALL:
+		for i := range this.children {
+			targets = append(targets, i)
+		}
+	case SUPERVISOR_REST_FOR_ONE:
+		for i := index; i < len(this.children); i++ {
+			targets = append(targets, i)
+		}
+	default:
+		targets = []int{index}
+	}
+	logger.Warn(fmt.Sprintf("supervisor [ %s ] restarts %d children after [ %s ] failed: %v", this.Parent().Name(), len(targets), child.Name(), reason))
+	for _, i := range targets {
+		this.restart(this.children[i])
+	}
+}
+
+//移除已不在父实体下的子实体(已销毁或迁出)，调用方持有锁
+func (this *SupervisorComponent) prune() {
+	current := make(map[*ecs.Object]bool)
+	for _, child := range this.objects() {
+		current[child] = true
+	}
+	supervised := this.children[:0]
+	for _, c := range this.children {
+		if current[c.object] {
+			supervised = append(supervised, c)
+		}
+	}
+	this.children = supervised
+}
+
+//销毁子实体并从模板重建，调用方持有锁
+func (this *SupervisorComponent) restart(child *supervisedChild) {
+	if err := destroyActor(child.object); err != nil {
+		logger.Error(err)
+	}
+	object, err := this.Runtime().Factory().Instantiate(child.template, this.Parent())
+	if err != nil {
+		logger.Error(fmt.Sprintf("restart [ %s ] failed: %s", child.template.Name, err.Error()))
+		return
+	}
+	child.object = object
+}
+
+//上报给上一级监督者，没有时停止失败的子实体，调用方持有锁
+func (this *SupervisorComponent) escalate(index int, reason error) {
+	if upper := supervisorOf(this.Parent()); upper != nil {
+		logger.Warn(fmt.Sprintf("supervisor [ %s ] restarts too often, escalate: %v", this.Parent().Name(), reason))
+		go upper.childFailed(this.Parent(), reason)
+		return
+	}
+	child := this.children[index]
+	logger.Error(fmt.Sprintf("supervisor [ %s ] restarts too often, stop [ %s ]: %v", this.Parent().Name(), child.object.Name(), reason))
+	this.children = append(this.children[:index], this.children[index+1:]...)
+	if err := destroyActor(child.object); err != nil {
+		logger.Error(err)
+	}
+}
+
+//实体的监督者，即父实体上的SupervisorComponent
+func supervisorOf(object *ecs.Object) *SupervisorComponent {
+	parent := object.Parent()
+	if parent == nil {
+		return nil
+	}
+	var supervisor *SupervisorComponent
+	if err := parent.Find(&supervisor); err != nil {
+		return nil
+	}
+	return supervisor
+}
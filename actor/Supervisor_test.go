@@ -0,0 +1,123 @@
+package Actor
+
+import (
+	"fmt"
+	"github.com/zllangct/rockgo/ecs"
+	"testing"
+	"time"
+)
+
+//处理Crash时panic的组件
+type testWorker struct {
+	ecs.ComponentBase
+}
+
+func (this *testWorker) New() ecs.IComponent {
+	return &testWorker{}
+}
+
+func (this *testWorker) MessageHandlers() map[string]func(message *ActorMessageInfo) error {
+	return map[string]func(message *ActorMessageInfo) error{
+		"Crash": func(message *ActorMessageInfo) error {
+			panic("crash")
+		},
+	}
+}
+
+//创建带n个子actor的监督者
+func newTestSupervisor(t *testing.T, proxy *ActorProxyComponent, supervisor *SupervisorComponent, n int) *ecs.Object {
+	proxy.Runtime().Factory().Register(&testWorker{})
+	parent := ecs.NewObject("supervisor")
+	if err := proxy.Runtime().Root().AddObjectWithComponents(parent, []ecs.IComponent{supervisor}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		child := ecs.NewObject(fmt.Sprintf("child%d", i))
+		if err := parent.AddObjectWithComponents(child, []ecs.IComponent{&testWorker{}, NewActorComponent(ACTOR_TYPE_SYNC)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := supervisor.Supervise(child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return parent
+}
+
+//被监督的子实体的actor地址 [实体名,地址]
+func childIDs(parent *ecs.Object) map[string]string {
+	var supervisor *SupervisorComponent
+	if err := parent.Find(&supervisor); err != nil {
+		panic(err)
+	}
+	ids := make(map[string]string)
+	for _, child := range supervisor.Children() {
+		var actor *ActorComponent
+		if child.Find(&actor) == nil {
+			ids[child.Name()] = actor.ID().String()
+		}
+	}
+	return ids
+}
+
+//让子实体处理消息时panic，等待监督者处理完
+func crash(t *testing.T, proxy *ActorProxyComponent, parent *ecs.Object, name string, changed func(ids map[string]string) bool) map[string]string {
+	t.Helper()
+	id := EmptyActorID()
+	if err := id.Parse(childIDs(parent)[name]); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewActor(id, proxy).Tell(nil, NewActorMessage("Crash"), new(*ActorMessage)); err == nil {
+		t.Fatal("expected the panic to be returned to the sender")
+	}
+	var ids map[string]string
+	waitFor(t, time.Second, "the supervisor handles the failure", func() bool {
+		ids = childIDs(parent)
+		return changed(ids)
+	})
+	return ids
+}
+
+func TestSupervisorStrategies(t *testing.T) {
+	proxy := testNode(t)
+	cases := []struct {
+		strategy  SupervisorStrategy
+		restarted map[string]bool
+	}{
+		{SUPERVISOR_ONE_FOR_ONE, map[string]bool{"child1": true}},
+		{SUPERVISOR_ONE_FOR_ALL, map[string]bool{"child0": true, "child1": true, "child2": true}},
+		{SUPERVISOR_REST_FOR_ONE, map[string]bool{"child1": true, "child2": true}},
+	}
+	for _, c := range cases {
+		parent := newTestSupervisor(t, proxy, NewSupervisorComponent(c.strategy, 3, time.Second*10), 3)
+		before := childIDs(parent)
+		after := crash(t, proxy, parent, "child1", func(ids map[string]string) bool {
+			return len(ids) == 3 && ids["child1"] != before["child1"]
+		})
+		//重启的子实体有新的actor地址，其他子实体不受影响
+		for name, id := range before {
+			if restarted := after[name] != id; restarted != c.restarted[name] {
+				t.Errorf("strategy %d: %s restarted %v, expected %v", c.strategy, name, restarted, c.restarted[name])
+			}
+		}
+		destroyActor(parent)
+	}
+}
+
+func TestSupervisorMaxRestarts(t *testing.T) {
+	proxy := testNode(t)
+	parent := newTestSupervisor(t, proxy, NewSupervisorComponent(SUPERVISOR_ONE_FOR_ONE, 2, time.Second*10), 2)
+	defer destroyActor(parent)
+	for i := 0; i < 2; i++ {
+		before := childIDs(parent)
+		crash(t, proxy, parent, "child0", func(ids map[string]string) bool {
+			return len(ids) == 2 && ids["child0"] != before["child0"]
+		})
+	}
+	//时间窗口内重启次数达到上限，没有上一级监督者时停止该子实体
+	ids := crash(t, proxy, parent, "child0", func(ids map[string]string) bool {
+		return len(ids) == 1
+	})
+	if _, ok := ids["child1"]; !ok {
+		t.Fatal("other children should keep running")
+	}
+}
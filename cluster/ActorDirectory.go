@@ -0,0 +1,143 @@
+package Cluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+/*
+	actor目录
+	master记录actor逻辑名到当前地址的映射，actor迁移后在新节点重新登记，调用方按名字查询即可找到actor。
+	目录随master状态在多master之间复制，节点离开时其上的actor登记被清除。
+*/
+
+var ErrActorNotFound = errors.New("actor not found in directory")
+
+type ActorRecord struct {
+	Name    string
+	ActorID string //IP:Port:LocalActorID
+}
+
+//登记actor，同名覆盖
+func (this *MasterComponent) RegisterActor(name string, actorID string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.actors == nil {
+		this.actors = make(map[string]string)
+	}
+	this.actors[name] = actorID
+	this.changed()
+}
+
+//注销actor，只有登记的仍是该地址时才注销，避免迁移后旧actor销毁时注销新地址
+func (this *MasterComponent) UnregisterActor(name string, actorID string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if current, ok := this.actors[name]; ok && current == actorID {
+		delete(this.actors, name)
+		this.changed()
+	}
+}
+
+//未登记时登记actor，返回该名字当前登记的地址，用于保证同名actor只激活一个
+func (this *MasterComponent) ClaimActor(name string, actorID string) string {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if current, ok := this.actors[name]; ok {
+		return current
+	}
+	if this.actors == nil {
+		this.actors = make(map[string]string)
+	}
+	this.actors[name] = actorID
+	this.changed()
+	return actorID
+}
+
+func (this *MasterComponent) ResolveActor(name string) (string, bool) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	actorID, ok := this.actors[name]
+	return actorID, ok
+}
+
+//清除节点上的actor登记，调用方持有锁
+func (this *MasterComponent) removeNodeActors(addr string) {
+	for name, actorID := range this.actors {
+		if strings.HasPrefix(actorID, addr+":") {
+			delete(this.actors, name)
+		}
+	}
+}
+
+func (this *MasterService) RegisterActor(args *ActorRecord, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	this.master.RegisterActor(args.Name, args.ActorID)
+	if err := this.master.commit(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+func (this *MasterService) UnregisterActor(args *ActorRecord, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	this.master.UnregisterActor(args.Name, args.ActorID)
+	if err := this.master.commit(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+func (this *MasterService) ClaimActor(args *ActorRecord, reply *string) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	*reply = this.master.ClaimActor(args.Name, args.ActorID)
+	return this.master.commit()
+}
+
+//查询actor地址，未登记时返回空字符串
+func (this *MasterService) ResolveActor(name string, reply *string) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	*reply, _ = this.master.ResolveActor(name)
+	return nil
+}
+
+//在actor目录中登记actor
+func (this *NodeComponent) RegisterActor(ctx context.Context, name string, actorID string) error {
+	var reply bool
+	return this.CallMaster(ctx, "MasterService.RegisterActor", &ActorRecord{Name: name, ActorID: actorID}, &reply)
+}
+
+func (this *NodeComponent) UnregisterActor(ctx context.Context, name string, actorID string) error {
+	var reply bool
+	return this.CallMaster(ctx, "MasterService.UnregisterActor", &ActorRecord{Name: name, ActorID: actorID}, &reply)
+}
+
+//名字未登记时登记为actorID，返回名字当前登记的地址
+func (this *NodeComponent) ClaimActor(ctx context.Context, name string, actorID string) (string, error) {
+	var reply string
+	err := this.CallMaster(ctx, "MasterService.ClaimActor", &ActorRecord{Name: name, ActorID: actorID}, &reply)
+	return reply, err
+}
+
+//按逻辑名查询actor当前地址
+func (this *NodeComponent) ResolveActor(ctx context.Context, name string) (string, error) {
+	var reply string
+	if err := this.CallMaster(ctx, "MasterService.ResolveActor", name, &reply); err != nil {
+		return "", err
+	}
+	if reply == "" {
+		return "", ErrActorNotFound
+	}
+	return reply, nil
+}
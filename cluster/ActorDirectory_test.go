@@ -0,0 +1,49 @@
+package Cluster
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestActorDirectory(t *testing.T) {
+	master := &MasterComponent{
+		locker:          &sync.RWMutex{},
+		Nodes:           make(map[string]*NodeInfo),
+		NodeLog:         &NodeLogs{BufferSize: 20},
+		timeoutChecking: make(map[string]int),
+		actors:          make(map[string]string),
+	}
+	master.RegisterActor("room-1", "127.0.0.1:6605:a")
+	master.RegisterActor("room-2", "127.0.0.1:6605:b")
+
+	// The actor migrates: the new address is registered before the old actor
+	// is destroyed, so the late unregister of the old address is ignored.
+	master.RegisterActor("room-1", "127.0.0.1:6606:c")
+	master.UnregisterActor("room-1", "127.0.0.1:6605:a")
+	if addr, ok := master.ResolveActor("room-1"); !ok || addr != "127.0.0.1:6606:c" {
+		t.Errorf("room-1: got %q %v", addr, ok)
+	}
+
+	// Actors of a node that left are removed.
+	master.locker.Lock()
+	master.NodeClose("127.0.0.1:6605")
+	master.locker.Unlock()
+	if _, ok := master.ResolveActor("room-2"); ok {
+		t.Error("room-2 should be removed with its node")
+	}
+	if _, ok := master.ResolveActor("room-1"); !ok {
+		t.Error("room-1 should survive")
+	}
+
+	if addr := master.ClaimActor("room-1", "127.0.0.1:6607:d"); addr != "127.0.0.1:6606:c" {
+		t.Errorf("claim of a registered name: got %q", addr)
+	}
+	if addr := master.ClaimActor("room-3", "127.0.0.1:6607:e"); addr != "127.0.0.1:6607:e" {
+		t.Errorf("claim of a free name: got %q", addr)
+	}
+
+	master.UnregisterActor("room-1", "127.0.0.1:6606:c")
+	if _, ok := master.ResolveActor("room-1"); ok {
+		t.Error("room-1 should be unregistered")
+	}
+}
@@ -1,6 +1,7 @@
 package Cluster
 
 import (
+	"context"
 	"fmt"
 	"github.com/zllangct/rockgo/config"
 	"github.com/zllangct/rockgo/ecs"
@@ -20,7 +21,10 @@ type ChildComponent struct {
 	rpcMaster       *rpc.TcpClient //master节点
 	nodeComponent   *NodeComponent
 	reportCollecter []func() (string, float32)
+	process         processCollector //进程cpu、内存与goroutine
 	close           bool
+	stop            chan struct{} //销毁时关闭，停止定期上报
+	draining        bool          //停机排空中
 }
 
 func (this *ChildComponent) GetRequire() map[*ecs.Object][]reflect.Type {
@@ -33,20 +37,41 @@ func (this *ChildComponent) GetRequire() map[*ecs.Object][]reflect.Type {
 }
 
 func (this *ChildComponent) Awake(ctx *ecs.Context) {
+	this.stop = make(chan struct{})
 	err := this.Parent().Root().Find(&this.nodeComponent)
 	if err != nil {
 		panic(err)
 	}
 
+	//不使用master的服务发现，直接注册本节点
+	if !this.useMaster() {
+		this.localAddr = config.Config.ClusterConfig.LocalAddress
+		if err = this.report(); err != nil {
+			panic(err)
+		}
+		return
+	}
 	go this.ConnectToMaster()
 	go this.DoReport()
 }
 
+func (this *ChildComponent) useMaster() bool {
+	_, ok := this.nodeComponent.Discovery().(*MasterDiscovery)
+	return ok
+}
+
 func (this *ChildComponent) Destroy(ctx *ecs.Context) {
 	this.locker.Lock()
 	defer this.locker.Unlock()
 
+	if !this.close && this.stop != nil {
+		close(this.stop)
+	}
 	this.close = true
+	if !this.useMaster() {
+		_ = this.nodeComponent.Discovery().Deregister(this.localAddr)
+		return
+	}
 	this.ReportClose(this.localAddr)
 }
 
@@ -64,34 +89,102 @@ func (this *ChildComponent) DoReport() {
 			defer this.locker.RUnlock()
 			return this.localAddr != ""
 		})
-	args := &NodeInfo{
-		Address: this.localAddr,
-		Role:    config.Config.ClusterConfig.Role,
-		AppName: config.Config.ClusterConfig.AppName,
-	}
-	var reply bool
 	var interval = time.Duration(config.Config.ClusterConfig.ReportInterval)
 	for {
-		reply = false
 		this.locker.RLock()
-		if this.close {
-			this.locker.RUnlock()
+		closed := this.close
+		this.locker.RUnlock()
+		if closed {
 			return
 		}
-		m := make(map[string]float32)
-		for _, collector := range this.reportCollecter {
-			f, d := collector()
-			m[f] = d
+		_ = this.report()
+		select {
+		case <-this.stop:
+			return
+		case <-time.After(time.Millisecond * interval):
 		}
-		args.Info = m
-		this.locker.RUnlock()
-		if this.rpcMaster != nil {
-			err := this.rpcMaster.Call("MasterService.ReportNodeInfo", args, &reply)
-			if err != nil {
+	}
+}
+
+//收集并上报一次节点信息
+func (this *ChildComponent) report() error {
+	this.locker.RLock()
+	args := &NodeInfo{
+		Address:  this.localAddr,
+		Role:     config.Config.ClusterConfig.Role,
+		AppName:  config.Config.ClusterConfig.AppName,
+		Info:     make(map[string]float32),
+		Draining: this.draining,
+	}
+	collectors := this.reportCollecter
+	master := this.rpcMaster
+	this.locker.RUnlock()
+
+	this.process.CollectLoad(args.Info)
+	this.collectComponents(args.Info)
+	for _, collector := range collectors {
+		f, d := collector()
+		args.Info[f] = d
+	}
 
+	if !this.useMaster() {
+		return this.nodeComponent.Discovery().Register(args)
+	}
+	if master == nil {
+		return ErrNodeOffline
+	}
+	if secret := config.Config.ClusterConfig.ClusterSecret; secret != "" {
+		args.Sign(secret)
+	}
+	var reply bool
+	err := master.Call("MasterService.ReportNodeInfo", args, &reply)
+	if _, ok := LeaderFromError(err); ok {
+		//master已不是leader，断开后重新寻找leader
+		master.Close()
+	}
+	return err
+}
+
+//收集节点上所有组件的负载
+//逐个实体在其锁内读取组件和子实体，避免与增删组件、销毁实体并发，收集在锁外进行
+func (this *ChildComponent) collectComponents(info map[string]float32) {
+	var collectors []ILoadCollector
+	objects := []*ecs.Object{this.Parent().Root()}
+	for len(objects) > 0 {
+		object := objects[0]
+		objects = objects[1:]
+		object.WithLock(func() error {
+			components := object.AllComponents()
+			for val, err := components.Next(); err == nil; val, err = components.Next() {
+				if collector, ok := val.(ILoadCollector); ok {
+					collectors = append(collectors, collector)
+				}
 			}
-		}
-		time.Sleep(time.Millisecond * interval)
+			children := object.Objects()
+			for val, err := children.Next(); err == nil; val, err = children.Next() {
+				objects = append(objects, val.(*ecs.Object))
+			}
+			return nil
+		})
+	}
+	for _, collector := range collectors {
+		collector.CollectLoad(info)
+	}
+}
+
+//进入排空状态并立即上报，之后本节点不再被查询选中
+func (this *ChildComponent) Drain() error {
+	this.locker.Lock()
+	this.draining = true
+	this.locker.Unlock()
+	logger.Info("Node is draining")
+	return this.report()
+}
+
+//停机钩子：通知master本节点排空
+func (this *ChildComponent) Shutdown(ctx context.Context) {
+	if err := this.Drain(); err != nil {
+		logger.Warn("report draining failed: " + err.Error())
 	}
 }
 
@@ -106,33 +199,49 @@ func (this *ChildComponent) AddReportInfo(field string, collectFunction func() (
 func (this *ChildComponent) ReportClose(addr string) {
 	var reply bool
 	if this.rpcMaster != nil {
-		_ = this.rpcMaster.Call("MasterService.ReportNodeClose", addr, &reply)
+		_ = this.rpcMaster.Call("MasterService.ReportNodeClose", newNodeCloseArgs(addr), &reply)
 	}
 }
 
-//连接到master
+//连接到master，多master时连接到leader
 func (this *ChildComponent) ConnectToMaster() {
-	addr := config.Config.ClusterConfig.MasterAddress
-	callback := func(event string, data ...interface{}) {
-		switch event {
-		case "close":
-			this.OnDropped()
-		}
-	}
 	logger.Info(" Looking for master ......")
-	var err error
-	for {
-		this.locker.Lock()
-		this.rpcMaster, err = this.nodeComponent.ConnectToNode(addr, callback)
-		if err == nil {
-			ip := strings.Split(this.rpcMaster.LocalAddr(), ":")[0]
+	var addr string
+	for connected := false; !connected; {
+		candidates := this.nodeComponent.masterCandidates()
+		for len(candidates) > 0 && !connected {
+			addr = candidates[0]
+			candidates = candidates[1:]
+			client, err := this.dialMaster(addr)
+			if err != nil {
+				continue
+			}
+			var reply bool
+			err = client.Call("MasterService.LeaderInquiry", "", &reply)
+			if leader, ok := LeaderFromError(err); ok {
+				client.Close()
+				if leader != "" && leader != addr {
+					this.nodeComponent.SetMasterLeader(leader)
+					candidates = append([]string{leader}, candidates...)
+				}
+				continue
+			}
+			if err != nil {
+				client.Close()
+				continue
+			}
+			this.locker.Lock()
+			this.rpcMaster = client
+			ip := strings.Split(client.LocalAddr(), ":")[0]
 			port := strings.Split(config.Config.ClusterConfig.LocalAddress, ":")[1]
 			this.localAddr = fmt.Sprintf("%s:%s", ip, port)
 			this.locker.Unlock()
-			break
+			this.nodeComponent.SetMasterLeader(addr)
+			connected = true
+		}
+		if !connected {
+			time.Sleep(time.Millisecond * 500)
 		}
-		this.locker.Unlock()
-		time.Sleep(time.Millisecond * 500)
 	}
 
 	this.nodeComponent.Locker().Lock()
@@ -142,6 +251,27 @@ func (this *ChildComponent) ConnectToMaster() {
 	logger.Info(fmt.Sprintf("Connected to master [ %s ]", addr))
 }
 
+//连接master，只有当前使用的连接断开时才重新连接
+func (this *ChildComponent) dialMaster(addr string) (*rpc.TcpClient, error) {
+	var client *rpc.TcpClient
+	ready := make(chan struct{})
+	callback := func(event string, data ...interface{}) {
+		switch event {
+		case "close":
+			<-ready
+			this.locker.RLock()
+			current := client != nil && this.rpcMaster == client
+			this.locker.RUnlock()
+			if current {
+				this.OnDropped()
+			}
+		}
+	}
+	client, err := this.nodeComponent.ConnectToNode(addr, callback)
+	close(ready)
+	return client, err
+}
+
 //当节点掉线
 func (this *ChildComponent) OnDropped() {
 	//重新连接 time.Now().Format("2006-01-02T 15:04:05")
@@ -0,0 +1,166 @@
+package Cluster
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/rpc"
+	"sync"
+	"time"
+)
+
+/*
+	节点连接池
+	到每个节点最多保持size个连接，调用时选择未完成调用最少的连接；
+	所有连接都有未完成调用时在后台补充新连接，同一时刻只有一个连接在建立。
+	连接失败后按指数退避，退避期间无可用连接的调用立即失败，不再轮询等待。
+	节点离开集群后连接池被关闭，关闭所有连接，之后的调用立即失败。
+*/
+
+const (
+	poolBackoffMin = time.Millisecond * 100
+	poolBackoffMax = time.Second * 10
+)
+
+var ErrPoolClosed = errors.New("client pool is closed")
+
+type clientPool struct {
+	addr    string
+	size    int
+	connect func(addr string) (*rpc.TcpClient, error)
+
+	locker   sync.Mutex
+	clients  []*rpc.TcpClient
+	dialing  *poolDial //正在建立的连接
+	failures int       //连续失败次数
+	retryAt  time.Time //退避结束时间
+	lastErr  error
+	closed   bool
+}
+
+//一次连接建立，等待者共享结果
+type poolDial struct {
+	done   chan struct{}
+	client *rpc.TcpClient
+	err    error
+}
+
+func newClientPool(addr string, size int, connect func(addr string) (*rpc.TcpClient, error)) *clientPool {
+	if size < 1 {
+		size = 1
+	}
+	return &clientPool{
+		addr:    addr,
+		size:    size,
+		connect: connect,
+	}
+}
+
+//获取一个连接
+func (this *clientPool) Get() (*rpc.TcpClient, error) {
+	this.locker.Lock()
+	if this.closed {
+		this.locker.Unlock()
+		return nil, ErrPoolClosed
+	}
+	best, pending := this.leastPendingLocked()
+	if best != nil && (pending == 0 || len(this.clients) >= this.size) {
+		this.locker.Unlock()
+		return best, nil
+	}
+	dial, err := this.dialLocked()
+	this.locker.Unlock()
+	if best != nil {
+		//已有可用连接，补充的连接在后台建立
+		return best, nil
+	}
+	if dial == nil {
+		return nil, err
+	}
+	<-dial.done
+	return dial.client, dial.err
+}
+
+//移除已断开的连接，返回未完成调用最少的连接
+func (this *clientPool) leastPendingLocked() (*rpc.TcpClient, int) {
+	var best *rpc.TcpClient
+	min := 0
+	alive := this.clients[:0]
+	for _, client := range this.clients {
+		if client.IsClosed() {
+			continue
+		}
+		alive = append(alive, client)
+		if n := client.Pending(); best == nil || n < min {
+			best, min = client, n
+		}
+	}
+	for i := len(alive); i < len(this.clients); i++ {
+		this.clients[i] = nil
+	}
+	this.clients = alive
+	return best, min
+}
+
+//发起或加入正在进行的连接，退避期间返回错误
+func (this *clientPool) dialLocked() (*poolDial, error) {
+	if this.dialing != nil {
+		return this.dialing, nil
+	}
+	if now := time.Now(); now.Before(this.retryAt) {
+		return nil, fmt.Errorf("connect to node [ %s ] failed, retry in %v: %v", this.addr, this.retryAt.Sub(now), this.lastErr)
+	}
+	dial := &poolDial{done: make(chan struct{})}
+	this.dialing = dial
+	go this.dial(dial)
+	return dial, nil
+}
+
+func (this *clientPool) dial(dial *poolDial) {
+	client, err := this.connect(this.addr)
+	this.locker.Lock()
+	this.dialing = nil
+	if err == nil && this.closed {
+		//连接建立期间连接池已关闭
+		client.Close()
+		client, err = nil, ErrPoolClosed
+	}
+	if err != nil {
+		this.failures++
+		backoff := poolBackoffMin << uint(this.failures-1)
+		if backoff > poolBackoffMax || backoff <= 0 {
+			backoff = poolBackoffMax
+		}
+		this.retryAt = time.Now().Add(backoff)
+		this.lastErr = err
+	} else {
+		this.failures = 0
+		this.retryAt = time.Time{}
+		this.lastErr = nil
+		this.clients = append(this.clients, client)
+	}
+	this.locker.Unlock()
+	dial.client, dial.err = client, err
+	close(dial.done)
+}
+
+//遍历当前连接
+func (this *clientPool) Range(fn func(client *rpc.TcpClient)) {
+	this.locker.Lock()
+	clients := append([]*rpc.TcpClient(nil), this.clients...)
+	this.locker.Unlock()
+	for _, client := range clients {
+		fn(client)
+	}
+}
+
+//关闭连接池及其所有连接
+func (this *clientPool) Close() {
+	this.locker.Lock()
+	this.closed = true
+	clients := this.clients
+	this.clients = nil
+	this.locker.Unlock()
+	for _, client := range clients {
+		client.Close()
+	}
+}
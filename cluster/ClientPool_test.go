@@ -0,0 +1,212 @@
+package Cluster
+
+import (
+	"errors"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/rpc"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type PoolService struct {
+	release chan struct{}
+}
+
+func (this *PoolService) Block(args int, reply *int) error {
+	<-this.release
+	*reply = args
+	return nil
+}
+
+func startPoolServer(t *testing.T) (string, *PoolService) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	service := &PoolService{release: make(chan struct{})}
+	server := rpc.NewServer()
+	server.Register(service)
+	go server.Accept(l)
+	return l.Addr().String(), service
+}
+
+func TestClientPoolSingleflight(t *testing.T) {
+	addr, _ := startPoolServer(t)
+	var dials int32
+	pool := newClientPool(addr, 4, func(addr string) (*rpc.TcpClient, error) {
+		atomic.AddInt32(&dials, 1)
+		time.Sleep(50 * time.Millisecond)
+		return rpc.NewTcpClient("tcp", addr)
+	})
+
+	clients := make([]*rpc.TcpClient, 10)
+	wg := sync.WaitGroup{}
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := pool.Get()
+			if err != nil {
+				t.Error(err)
+			}
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("concurrent callers should share one dial, dialed %d times", n)
+	}
+	for _, client := range clients {
+		if client != clients[0] {
+			t.Fatal("callers waiting on the same dial should get the same client")
+		}
+	}
+	pool.Range(func(client *rpc.TcpClient) { client.Close() })
+}
+
+func TestClientPoolLeastPending(t *testing.T) {
+	addr, service := startPoolServer(t)
+	pool := newClientPool(addr, 2, func(addr string) (*rpc.TcpClient, error) {
+		return rpc.NewTcpClient("tcp", addr)
+	})
+	defer pool.Range(func(client *rpc.TcpClient) { client.Close() })
+
+	busy, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := busy.Go("PoolService.Block", 1, new(int), nil)
+
+	// The only client is busy: it is still returned while a second one is dialed in the background.
+	if client, err := pool.Get(); err != nil || client != busy {
+		t.Fatalf("expected the busy client while dialing, got %v %v", client, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		n := 0
+		pool.Range(func(*rpc.TcpClient) { n++ })
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pool did not grow while all clients were busy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	idle, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idle == busy {
+		t.Fatal("expected the client without pending calls")
+	}
+
+	// The pool is full: the least pending client is returned without dialing.
+	idle.Go("PoolService.Block", 2, new(int), nil)
+	idle.Go("PoolService.Block", 3, new(int), nil)
+	if client, _ := pool.Get(); client != busy {
+		t.Fatal("expected the client with fewer pending calls")
+	}
+	close(service.release)
+	<-call.Done
+}
+
+func TestClientPoolBackoff(t *testing.T) {
+	addr, _ := startPoolServer(t)
+	var dials int32
+	var fail atomic.Value
+	fail.Store(true)
+	pool := newClientPool(addr, 1, func(addr string) (*rpc.TcpClient, error) {
+		atomic.AddInt32(&dials, 1)
+		if fail.Load().(bool) {
+			return nil, errors.New("refused")
+		}
+		return rpc.NewTcpClient("tcp", addr)
+	})
+
+	if _, err := pool.Get(); err == nil {
+		t.Fatal("expected the dial error")
+	}
+	// Within the backoff callers fail at once without dialing.
+	if _, err := pool.Get(); err == nil || !strings.Contains(err.Error(), "retry in") {
+		t.Fatalf("expected a backoff error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("no dial is expected during backoff, dialed %d times", n)
+	}
+
+	// Each failure doubles the backoff.
+	time.Sleep(poolBackoffMin)
+	pool.Get()
+	pool.locker.Lock()
+	failures, backoff := pool.failures, time.Until(pool.retryAt)
+	pool.locker.Unlock()
+	if failures != 2 || backoff <= poolBackoffMin || backoff > 2*poolBackoffMin {
+		t.Fatalf("expected a doubled backoff after 2 failures, got %v after %d", backoff, failures)
+	}
+
+	// A successful dial resets the backoff.
+	fail.Store(false)
+	time.Sleep(2 * poolBackoffMin)
+	client, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	if pool.failures != 0 || !pool.retryAt.IsZero() {
+		t.Fatal("backoff should be reset after a successful dial")
+	}
+}
+
+func TestClientPoolDroppedOnLeave(t *testing.T) {
+	old := config.Config
+	defer func() { config.Config = old }()
+	conf := &config.ConfigComponent{}
+	conf.SetDefault()
+	config.Config = conf
+
+	addr, _ := startPoolServer(t)
+	node := &NodeComponent{}
+	pool := newClientPool(addr, 1, func(addr string) (*rpc.TcpClient, error) {
+		return rpc.NewTcpClient("tcp", addr)
+	})
+	node.rpcClient.Store(addr, pool)
+	client, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other events keep the pool.
+	node.dropNodeClient(&MembershipEvent{Type: MEMBER_EVENT_ROLE_CHANGE, Node: &NodeInfo{Address: addr}})
+	if _, ok := node.rpcClient.Load(addr); !ok || client.IsClosed() {
+		t.Fatal("pool should be kept while the node is in the cluster")
+	}
+
+	node.dropNodeClient(&MembershipEvent{Type: MEMBER_EVENT_LEAVE, Node: &NodeInfo{Address: addr}})
+	if _, ok := node.rpcClient.Load(addr); ok {
+		t.Fatal("pool of a node that left should be removed")
+	}
+	if !client.IsClosed() {
+		t.Fatal("clients of a node that left should be closed")
+	}
+	if _, err := pool.Get(); err != ErrPoolClosed {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+
+	// Connections to masters are kept.
+	master := newClientPool(addr, 1, nil)
+	node.rpcClient.Store(addr, master)
+	conf.ClusterConfig.MasterAddress = addr
+	node.dropNodeClient(&MembershipEvent{Type: MEMBER_EVENT_LEAVE, Node: &NodeInfo{Address: addr}})
+	if v, ok := node.rpcClient.Load(addr); !ok || v != master {
+		t.Fatal("pool of a master should be kept")
+	}
+}
@@ -0,0 +1,194 @@
+package Cluster
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/zllangct/rockgo/config"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	节点负载上报
+	子节点上报时先采集进程信息，再收集节点上所有实现了ILoadCollector的组件，最后执行AddReportInfo添加的采集函数。
+	最小负载选择按配置的权重(LoadWeights)对上报字段加权求和。
+*/
+
+const (
+	LOAD_FIELD_CPU         = "cpu"         //进程cpu占用，占全部核心的比例 0~1
+	LOAD_FIELD_MEM         = "mem"         //进程常驻内存占物理内存的比例 0~1
+	LOAD_FIELD_RSS         = "rss"         //进程常驻内存，单位MB
+	LOAD_FIELD_GOROUTINE   = "goroutine"   //goroutine数量
+	LOAD_FIELD_ACTOR       = "actor"       //本地actor数量
+	LOAD_FIELD_SESSION     = "session"     //网关客户端会话数量
+	LOAD_FIELD_RPC_PENDING = "rpc_pending" //处理中的rpc调用数量
+	LOAD_FIELD_MAILBOX     = "mailbox"     //本地actor邮箱中等待处理的消息总数
+)
+
+// ILoadCollector is implemented by components that contribute load fields to
+// the node report.
+type ILoadCollector interface {
+	CollectLoad(info map[string]float32)
+}
+
+var defaultLoadWeights = map[string]float32{
+	LOAD_FIELD_CPU: 0.8,
+	LOAD_FIELD_MEM: 0.2,
+}
+
+func loadWeights() map[string]float32 {
+	if config.Config == nil || config.Config.ClusterConfig == nil || len(config.Config.ClusterConfig.LoadWeights) == 0 {
+		return defaultLoadWeights
+	}
+	return config.Config.ClusterConfig.LoadWeights
+}
+
+//加权负载，未上报的字段按1计算
+func loadScore(info map[string]float32, weights map[string]float32) float32 {
+	var sum float32
+	for field, weight := range weights {
+		value, ok := info[field]
+		if !ok {
+			value = 1
+		}
+		sum += value * weight
+	}
+	return sum
+}
+
+/*
+	进程信息，cpu和内存从/proc读取，非linux系统只上报goroutine数量
+*/
+
+//linux的USER_HZ
+const clockTicks = 100
+
+type processCollector struct {
+	locker   sync.Mutex
+	lastCPU  float64 //进程累计cpu时间，单位秒
+	lastTime time.Time
+}
+
+func (this *processCollector) CollectLoad(info map[string]float32) {
+	info[LOAD_FIELD_GOROUTINE] = float32(runtime.NumGoroutine())
+	if cpu, ok := this.cpu(); ok {
+		info[LOAD_FIELD_CPU] = cpu
+	}
+	if rss, ok := processRSS(); ok {
+		info[LOAD_FIELD_RSS] = float32(rss / (1 << 20))
+		if total, ok := memTotal(); ok && total > 0 {
+			info[LOAD_FIELD_MEM] = float32(rss / total)
+		}
+	}
+}
+
+//两次采集之间的cpu占用，第一次采集时为进程启动以来的平均值
+func (this *processCollector) cpu() (float32, bool) {
+	fields, ok := procStat()
+	if !ok {
+		return 0, false
+	}
+	utime, err1 := strconv.ParseFloat(fields[11], 64)
+	stime, err2 := strconv.ParseFloat(fields[12], 64)
+	start, err3 := strconv.ParseFloat(fields[19], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	now := time.Now()
+	used := (utime + stime) / clockTicks
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	var elapsed float64
+	if this.lastTime.IsZero() {
+		uptime, ok := systemUptime()
+		if !ok {
+			return 0, false
+		}
+		elapsed = uptime - start/clockTicks
+		this.lastCPU = 0
+	} else {
+		elapsed = now.Sub(this.lastTime).Seconds()
+	}
+	delta := used - this.lastCPU
+	this.lastCPU, this.lastTime = used, now
+	if elapsed <= 0 {
+		return 0, true
+	}
+	cpu := delta / elapsed / float64(runtime.NumCPU())
+	if cpu > 1 {
+		cpu = 1
+	}
+	return float32(cpu), true
+}
+
+//返回/proc/self/stat中进程名之后的字段，第0个为进程状态
+func procStat() ([]string, bool) {
+	data, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return nil, false
+	}
+	//进程名可能包含空格，从最后一个')'之后开始解析
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil, false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return nil, false
+	}
+	return fields, true
+}
+
+func systemUptime() (float64, bool) {
+	data, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	return uptime, err == nil
+}
+
+//常驻内存，单位字节
+func processRSS() (float64, bool) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * float64(os.Getpagesize()), true
+}
+
+//物理内存，单位字节
+func memTotal() (float64, bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseFloat(fields[1], 64)
+			return kb * 1024, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package Cluster
+
+import (
+	"github.com/zllangct/rockgo/config"
+	"os"
+	"testing"
+)
+
+func TestProcessCollector(t *testing.T) {
+	c := &processCollector{}
+	info := make(map[string]float32)
+	c.CollectLoad(info)
+	if info[LOAD_FIELD_GOROUTINE] < 1 {
+		t.Errorf("goroutine: got %v", info[LOAD_FIELD_GOROUTINE])
+	}
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no procfs")
+	}
+	// Burn some cpu so the second sample has something to measure.
+	for i, n := 0, 0; i < 1e7; i++ {
+		n += i
+	}
+	c.CollectLoad(info)
+	for _, field := range []string{LOAD_FIELD_CPU, LOAD_FIELD_MEM, LOAD_FIELD_RSS} {
+		if _, ok := info[field]; !ok {
+			t.Errorf("%s not reported", field)
+		}
+	}
+	if cpu := info[LOAD_FIELD_CPU]; cpu < 0 || cpu > 1 {
+		t.Errorf("cpu: got %v", cpu)
+	}
+	if mem := info[LOAD_FIELD_MEM]; mem <= 0 || mem > 1 {
+		t.Errorf("mem: got %v", mem)
+	}
+}
+
+func TestSelectMinLoadWeights(t *testing.T) {
+	old := config.Config
+	defer func() { config.Config = old }()
+	conf := &config.ConfigComponent{}
+	conf.SetDefault()
+	config.Config = conf
+
+	nodes := SourceGroup{
+		{Node: "a", Info: map[string]float32{"cpu": 0.1, "mem": 0.1, "session": 900}},
+		{Node: "b", Info: map[string]float32{"cpu": 0.5, "mem": 0.5, "session": 10}},
+	}
+	if index := nodes.SelectMinLoad(); index != 0 {
+		t.Errorf("default weights: got %d, want 0", index)
+	}
+	conf.ClusterConfig.LoadWeights = map[string]float32{"cpu": 0.5, "session": 0.001}
+	if index := nodes.SelectMinLoad(); index != 1 {
+		t.Errorf("session weighted: got %d, want 1", index)
+	}
+}
@@ -0,0 +1,190 @@
+package Cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/utils"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+/*
+	服务发现
+	节点的注册、注销、查询和成员变化监听都通过Discovery完成。
+	默认使用master与位置服务组成的实现，static实现从文件读取节点列表，无需master即可启动节点。
+*/
+
+const (
+	DISCOVERY_MASTER = "master"
+	DISCOVERY_STATIC = "static"
+)
+
+var ErrDiscoveryUnknown = errors.New("unknown discovery")
+
+// Discovery is the backend of cluster membership.
+type Discovery interface {
+	// Register adds or refreshes a node.
+	Register(info *NodeInfo) error
+	// Deregister removes a node.
+	Deregister(addr string) error
+	// Watch calls handler with the member nodes whenever membership changes,
+	// until cancel is called.
+	Watch(handler func(nodes map[string]*NodeInfo)) (cancel func())
+	// Query selects nodes, query is [selector type, app name, role] with an
+	// optional selector key.
+	Query(ctx context.Context, query []string, detail bool) ([]*InquiryReply, error)
+}
+
+//按配置创建服务发现
+func newDiscovery(node *NodeComponent) (Discovery, error) {
+	switch config.Config.ClusterConfig.Discovery {
+	case "", DISCOVERY_MASTER:
+		return NewMasterDiscovery(node), nil
+	case DISCOVERY_STATIC:
+		return NewStaticDiscovery(config.Config.ClusterConfig.DiscoveryFile)
+	default:
+		return nil, ErrDiscoveryUnknown
+	}
+}
+
+/*
+	master与位置服务
+*/
+type MasterDiscovery struct {
+	node *NodeComponent
+}
+
+func NewMasterDiscovery(node *NodeComponent) *MasterDiscovery {
+	return &MasterDiscovery{node: node}
+}
+
+func (this *MasterDiscovery) Register(info *NodeInfo) error {
+	if secret := config.Config.ClusterConfig.ClusterSecret; secret != "" {
+		info.Sign(secret)
+	}
+	var reply bool
+	return this.node.CallMaster(context.Background(), "MasterService.ReportNodeInfo", info, &reply)
+}
+
+func (this *MasterDiscovery) Deregister(addr string) error {
+	var reply bool
+	return this.node.CallMaster(context.Background(), "MasterService.ReportNodeClose", newNodeCloseArgs(addr), &reply)
+}
+
+//优先查询位置服务器，不可用时查询master
+func (this *MasterDiscovery) Query(ctx context.Context, query []string, detail bool) ([]*InquiryReply, error) {
+	if this.node.islocationMode {
+		reply, err := this.node.queryLocation(ctx, query, detail)
+		if err == nil {
+			return reply, nil
+		}
+	}
+	return this.node.queryMaster(ctx, query, detail)
+}
+
+/*
+	静态节点列表
+	文件内容为节点信息的json数组，例如：
+	[{"Address":"127.0.0.1:6601","AppName":"app","Role":["gate"]}]
+*/
+type StaticDiscovery struct {
+	locker   *sync.RWMutex
+	file     string
+	nodes    map[string]*NodeInfo
+	watchers map[int]func(nodes map[string]*NodeInfo)
+	watchID  int
+}
+
+func NewStaticDiscovery(file string) (*StaticDiscovery, error) {
+	this := &StaticDiscovery{
+		locker:   &sync.RWMutex{},
+		file:     file,
+		nodes:    make(map[string]*NodeInfo),
+		watchers: make(map[int]func(nodes map[string]*NodeInfo)),
+	}
+	if file != "" {
+		if err := this.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return this, nil
+}
+
+//重新读取节点列表文件，通过Register加入的节点保留
+func (this *StaticDiscovery) Reload() error {
+	data, err := ioutil.ReadFile(this.file)
+	if err != nil {
+		return err
+	}
+	var nodes []*NodeInfo
+	if err = json.Unmarshal(data, &nodes); err != nil {
+		return err
+	}
+	this.locker.Lock()
+	for _, info := range nodes {
+		this.nodes[info.Address] = info
+	}
+	this.locker.Unlock()
+	this.notify()
+	return nil
+}
+
+func (this *StaticDiscovery) Register(info *NodeInfo) error {
+	info = utils.Copy(info).(*NodeInfo)
+	info.Time = time.Now().UnixNano()
+	this.locker.Lock()
+	typ := memberChange(this.nodes[info.Address], info)
+	changed := typ == MEMBER_EVENT_JOIN || typ == MEMBER_EVENT_ROLE_CHANGE
+	this.nodes[info.Address] = info
+	this.locker.Unlock()
+	if changed {
+		this.notify()
+	}
+	return nil
+}
+
+func (this *StaticDiscovery) Deregister(addr string) error {
+	this.locker.Lock()
+	_, ok := this.nodes[addr]
+	delete(this.nodes, addr)
+	this.locker.Unlock()
+	if ok {
+		this.notify()
+	}
+	return nil
+}
+
+func (this *StaticDiscovery) Watch(handler func(nodes map[string]*NodeInfo)) (cancel func()) {
+	this.locker.Lock()
+	this.watchID++
+	id := this.watchID
+	this.watchers[id] = handler
+	nodes := utils.Copy(this.nodes).(map[string]*NodeInfo)
+	this.locker.Unlock()
+	handler(nodes)
+	return func() {
+		this.locker.Lock()
+		delete(this.watchers, id)
+		this.locker.Unlock()
+	}
+}
+
+func (this *StaticDiscovery) Query(ctx context.Context, query []string, detail bool) ([]*InquiryReply, error) {
+	return Selector(this.nodes).DoQuery(query, detail, this.locker)
+}
+
+func (this *StaticDiscovery) notify() {
+	this.locker.RLock()
+	nodes := utils.Copy(this.nodes).(map[string]*NodeInfo)
+	handlers := make([]func(nodes map[string]*NodeInfo), 0, len(this.watchers))
+	for _, handler := range this.watchers {
+		handlers = append(handlers, handler)
+	}
+	this.locker.RUnlock()
+	for _, handler := range handlers {
+		handler(nodes)
+	}
+}
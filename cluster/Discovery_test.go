@@ -0,0 +1,71 @@
+package Cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nodes.json")
+	err = ioutil.WriteFile(file, []byte(`[
+		{"Address":"127.0.0.1:6601","AppName":"app","Role":["gate"]},
+		{"Address":"127.0.0.1:6605","AppName":"app","Role":["room"]},
+		{"Address":"127.0.0.1:6606","AppName":"app","Role":["room"]}
+	]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewStaticDiscovery(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := d.Query(context.Background(), []string{SELECTOR_TYPE_GROUP, "app", "room"}, false)
+	if err != nil || len(reply) != 2 {
+		t.Fatalf("rooms: got %d, err %v", len(reply), err)
+	}
+	reply, err = d.Query(context.Background(), []string{SELECTOR_TYPE_DEFAULT, "app", "gate"}, false)
+	if err != nil || len(reply) != 1 || reply[0].Node != "127.0.0.1:6601" {
+		t.Fatalf("gate: got %v, err %v", reply, err)
+	}
+	if _, err = d.Query(context.Background(), []string{SELECTOR_TYPE_DEFAULT, "app", "login"}, false); err == nil {
+		t.Error("expected query of a missing role to fail")
+	}
+
+	var seen []int
+	cancel := d.Watch(func(nodes map[string]*NodeInfo) {
+		seen = append(seen, len(nodes))
+	})
+	d.Register(&NodeInfo{Address: "127.0.0.1:6602", AppName: "app", Role: []string{"login"}})
+	d.Register(&NodeInfo{Address: "127.0.0.1:6602", AppName: "app", Role: []string{"login"}})
+	d.Deregister("127.0.0.1:6605")
+	cancel()
+	d.Deregister("127.0.0.1:6606")
+	want := []int{3, 4, 3}
+	if len(seen) != len(want) {
+		t.Fatalf("watch: got %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("watch: got %v, want %v", seen, want)
+		}
+	}
+	reply, _ = d.Query(context.Background(), []string{SELECTOR_TYPE_GROUP, "app", "room"}, false)
+	if len(reply) != 0 {
+		t.Errorf("rooms after deregister: got %d", len(reply))
+	}
+
+	// Draining nodes are no longer selected.
+	d.Register(&NodeInfo{Address: "127.0.0.1:6601", AppName: "app", Role: []string{"gate"}, Draining: true})
+	if reply, err = d.Query(context.Background(), []string{SELECTOR_TYPE_DEFAULT, "app", "gate"}, false); err == nil {
+		t.Errorf("expected draining gate not to be selected; got %v", reply)
+	}
+}
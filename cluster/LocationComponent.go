@@ -1,10 +1,12 @@
 package Cluster
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/zllangct/rockgo/config"
 	"github.com/zllangct/rockgo/ecs"
-	"github.com/zllangct/rockgo/rpc"
+	"github.com/zllangct/rockgo/logger"
 	"reflect"
 	"sync"
 	"time"
@@ -25,7 +27,7 @@ type LocationComponent struct {
 	nodeComponent *NodeComponent
 	Nodes         map[string]*NodeInfo
 	NodeLog       *NodeLogs
-	master        *rpc.TcpClient
+	lastStamp     map[string]int64 //各节点已接受的最新签名时间
 }
 
 func (this *LocationComponent) GetRequire() map[*ecs.Object][]reflect.Type {
@@ -39,6 +41,7 @@ func (this *LocationComponent) GetRequire() map[*ecs.Object][]reflect.Type {
 
 func (this *LocationComponent) Awake(ctx *ecs.Context) {
 	this.locker = &sync.RWMutex{}
+	this.lastStamp = make(map[string]int64)
 	err := this.Parent().Root().Find(&this.nodeComponent)
 	if err != nil {
 		panic(err)
@@ -54,29 +57,68 @@ func (this *LocationComponent) Awake(ctx *ecs.Context) {
 	go this.DoLocationSync()
 }
 
-//同步节点信息到位置服务组件
+//订阅master推送的成员变化，同步到位置服务组件
 func (this *LocationComponent) DoLocationSync() {
-	var reply *NodeInfoSyncReply
-	var interval = time.Duration(config.Config.ClusterConfig.LocationSyncInterval)
-	for {
-		if this.master == nil {
-			var err error
-			this.master, err = this.nodeComponent.GetNodeClient(config.Config.ClusterConfig.MasterAddress)
-			if err != nil {
-				time.Sleep(time.Second * interval)
-				continue
-			}
+	NewMasterDiscovery(this.nodeComponent).watch(context.Background(), this.onMembershipEvent)
+}
+
+func (this *LocationComponent) onMembershipEvent(event *MembershipEvent) {
+	switch event.Type {
+	case MEMBER_EVENT_SNAPSHOT:
+		//(重新)订阅时同步一次节点日志，之后由离开事件追加
+		var reply *NodeInfoSyncReply
+		nodeLog := &NodeLogs{BufferSize: 20}
+		err := this.nodeComponent.CallMaster(context.Background(), "MasterService.NodeInfoSync", "sync", &reply)
+		if err == nil && reply.NodeLog != nil {
+			nodeLog = reply.NodeLog
 		}
-		err := this.master.Call("MasterService.NodeInfoSync", "sync", &reply)
-		if err != nil {
-			this.master = nil
-			continue
+		nodes := event.Nodes
+		if nodes == nil {
+			nodes = make(map[string]*NodeInfo)
+		}
+		this.verifyNodes(nodes)
+		this.locker.Lock()
+		this.Nodes = nodes
+		this.NodeLog = nodeLog
+		this.locker.Unlock()
+	case MEMBER_EVENT_LEAVE:
+		this.locker.Lock()
+		delete(this.Nodes, event.Node.Address)
+		this.NodeLog.Add(&NodeLog{
+			Time: time.Now().UnixNano(),
+			Type: LOG_TYPE_NODE_CLOSE,
+			Log:  event.Node.Address,
+		})
+		this.locker.Unlock()
+	default:
+		nodes := map[string]*NodeInfo{event.Node.Address: event.Node}
+		this.verifyNodes(nodes)
+		if _, ok := nodes[event.Node.Address]; !ok {
+			return
 		}
 		this.locker.Lock()
-		this.Nodes = reply.Nodes
-		this.NodeLog = reply.NodeLog
+		this.Nodes[event.Node.Address] = event.Node
 		this.locker.Unlock()
-		time.Sleep(time.Millisecond * interval)
+	}
+}
+
+//校验同步到的节点签名，签名错误或时间戳回退的节点不对外提供
+func (this *LocationComponent) verifyNodes(nodes map[string]*NodeInfo) {
+	secret := config.Config.ClusterConfig.ClusterSecret
+	if secret == "" {
+		return
+	}
+	for addr, info := range nodes {
+		err := info.Verify(secret)
+		if err == nil && info.Stamp < this.lastStamp[addr] {
+			err = ErrNodeInfoReplayed
+		}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("location: node [ %s ] dropped: %s", addr, err.Error()))
+			delete(nodes, addr)
+			continue
+		}
+		this.lastStamp[addr] = info.Stamp
 	}
 }
 
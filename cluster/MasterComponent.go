@@ -14,15 +14,24 @@ import (
 
 const (
 	LOG_TYPE_NODE_CLOSE = iota
+	LOG_TYPE_NODE_REJECTED
 )
 
 type MasterComponent struct {
 	ecs.ComponentBase
-	locker          *sync.RWMutex
-	nodeComponent   *NodeComponent
-	Nodes           map[string]*NodeInfo
-	NodeLog         *NodeLogs
-	timeoutChecking map[string]int
+	locker              *sync.RWMutex
+	nodeComponent       *NodeComponent
+	Nodes               map[string]*NodeInfo
+	NodeLog             *NodeLogs
+	timeoutChecking     map[string]int
+	lastStamp           map[string]int64 //各节点最近一次通过校验的签名时间，用于拒绝重放
+	stampFloor          int64            //成为leader的时间，签名时间不逐次复制，早于此时间的签名不再接受
+	election            *masterElection  //配置多个master时的leader选举，单master时为空
+	watchers            membershipWatchers
+	actors              map[string]string           //actor目录 [逻辑名,actor地址]
+	topics              map[string]map[string]bool  //订阅主题的节点 [主题,[节点地址]]
+	singletons          map[string]*SingletonLease  //单例租约 [单例名,租约]
+	singletonCandidates map[string]map[string]int64 //单例候选节点 [单例名,[节点地址,最近请求时间]]
 }
 
 func (this *MasterComponent) GetRequire() map[*ecs.Object][]reflect.Type {
@@ -39,6 +48,11 @@ func (this *MasterComponent) Awake(ctx *ecs.Context) {
 	this.Nodes = make(map[string]*NodeInfo)
 	this.NodeLog = &NodeLogs{BufferSize: 20}
 	this.timeoutChecking = make(map[string]int)
+	this.lastStamp = make(map[string]int64)
+	this.actors = make(map[string]string)
+	this.topics = make(map[string]map[string]bool)
+	this.singletons = make(map[string]*SingletonLease)
+	this.singletonCandidates = make(map[string]map[string]int64)
 
 	err := this.Parent().Root().Find(&this.nodeComponent)
 	if err != nil {
@@ -52,11 +66,129 @@ func (this *MasterComponent) Awake(ctx *ecs.Context) {
 	if err != nil {
 		panic(err)
 	}
+	if peers := config.Config.ClusterConfig.MasterPeers; len(peers) > 1 {
+		this.startElection(peers)
+	}
 	if !config.Config.CommonConfig.Debug || false {
 		go this.TimeoutCheck()
 	}
 }
 
+func (this *MasterComponent) Destroy(ctx *ecs.Context) {
+	if this.election != nil {
+		this.election.Stop()
+	}
+}
+
+//启动master选举
+func (this *MasterComponent) startElection(peers []string) {
+	timeout := time.Millisecond * time.Duration(config.Config.ClusterConfig.MasterElectionTimeout)
+	this.election = newMasterElection(config.Config.ClusterConfig.LocalAddress, peers, timeout)
+	this.election.getClient = this.nodeComponent.GetNodeClient
+	this.election.snapshot = this.stateSnapshot
+	this.election.restore = this.restoreState
+	this.election.onChange = func(leader string, isLeader bool) {
+		this.nodeComponent.SetMasterLeader(leader)
+		if !isLeader {
+			//订阅者重新订阅到新leader
+			this.locker.Lock()
+			this.watchers.removeAll()
+			this.locker.Unlock()
+		}
+		if isLeader {
+			//新leader重新开始超时计数，给子节点切换的时间
+			this.locker.Lock()
+			for addr := range this.timeoutChecking {
+				this.timeoutChecking[addr] = 0
+			}
+			for addr := range this.Nodes {
+				this.timeoutChecking[addr] = 0
+			}
+			this.stampFloor = time.Now().UnixNano()
+			this.locker.Unlock()
+			this.extendSingletons()
+		}
+	}
+	err := this.nodeComponent.Register(&MasterElectionService{election: this.election})
+	if err != nil {
+		panic(err)
+	}
+	this.election.Start()
+}
+
+//是否由本master对外服务，单master时总是true
+func (this *MasterComponent) IsLeader() bool {
+	return this.election == nil || this.election.IsLeader()
+}
+
+//非leader时返回携带leader地址的错误
+func (this *MasterComponent) checkLeader() error {
+	if this.IsLeader() {
+		return nil
+	}
+	return notLeaderError(this.election.Leader())
+}
+
+//状态发生变化，调用方持有锁
+func (this *MasterComponent) changed() {
+	if this.election != nil {
+		this.election.Changed()
+	}
+}
+
+//等待多数master确认状态，修改状态的请求在回复前调用，调用方不能持有锁
+func (this *MasterComponent) commit() error {
+	if this.election == nil {
+		return nil
+	}
+	return this.election.Commit()
+}
+
+func (this *MasterComponent) stateSnapshot() (*MasterState, uint64, uint64) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	stateTerm, stateVersion := this.election.StateVersion()
+	state := &MasterState{
+		Nodes:      this.Nodes,
+		NodeLog:    this.NodeLog,
+		LastStamp:  this.lastStamp,
+		Actors:     this.actors,
+		Topics:     this.topics,
+		Singletons: this.singletons,
+	}
+	return utils.Copy(state).(*MasterState), stateTerm, stateVersion
+}
+
+func (this *MasterComponent) restoreState(state *MasterState) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if state.Nodes == nil {
+		state.Nodes = make(map[string]*NodeInfo)
+	}
+	if state.LastStamp == nil {
+		state.LastStamp = make(map[string]int64)
+	}
+	if state.Actors == nil {
+		state.Actors = make(map[string]string)
+	}
+	if state.Topics == nil {
+		state.Topics = make(map[string]map[string]bool)
+	}
+	if state.Singletons == nil {
+		state.Singletons = make(map[string]*SingletonLease)
+	}
+	if state.NodeLog == nil {
+		state.NodeLog = &NodeLogs{BufferSize: this.NodeLog.BufferSize}
+	}
+	this.Nodes = state.Nodes
+	this.NodeLog = state.NodeLog
+	this.lastStamp = state.LastStamp
+	this.actors = state.Actors
+	this.topics = state.Topics
+	this.singletons = state.Singletons
+	this.timeoutChecking = make(map[string]int)
+}
+
 //上报节点信息
 func (this *MasterComponent) UpdateNodeInfo(args *NodeInfo) {
 	this.locker.Lock()
@@ -69,12 +201,68 @@ func (this *MasterComponent) UpdateNodeInfo(args *NodeInfo) {
 		logger.Info(fmt.Sprintf("Node [ %s ] connected to this master, roles: [ %s]", args.Address, s.String()))
 	}
 	args.Time = time.Now().UnixNano()
+	if old, ok := this.Nodes[args.Address]; ok {
+		args.Joined = old.Joined
+	} else {
+		args.Joined = args.Time
+	}
+	typ := memberChange(this.Nodes[args.Address], args)
+	if typ != -1 {
+		this.watchers.publish(&MembershipEvent{Type: typ, Node: args})
+	}
 	this.Nodes[args.Address] = args
 	this.timeoutChecking[args.Address] = 0
+	//负载和上报时间的变化不复制，新leader在下次上报时获得
+	if typ == MEMBER_EVENT_JOIN || typ == MEMBER_EVENT_ROLE_CHANGE {
+		this.changed()
+	}
 
 	this.locker.Unlock()
 }
 
+//校验节点上报的签名，未配置集群密钥时不校验
+//签名时间需在允许偏差内且大于该节点上次的签名时间，被拒绝的上报记录到节点日志
+func (this *MasterComponent) CheckNodeInfo(args *NodeInfo) error {
+	return this.checkSigned(args.Address, args.Stamp, args.Verify)
+}
+
+//校验节点关闭上报的签名和时间戳
+func (this *MasterComponent) CheckNodeClose(args *NodeCloseArgs) error {
+	return this.checkSigned(args.Address, args.Stamp, args.Verify)
+}
+
+//校验签名、时间偏差和时间戳单调递增，未配置集群密钥时不校验
+func (this *MasterComponent) checkSigned(addr string, stamp int64, verify func(secret string) error) error {
+	secret := config.Config.ClusterConfig.ClusterSecret
+	if secret == "" {
+		return nil
+	}
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	now := time.Now()
+	err := verify(secret)
+	if err == nil {
+		if skew := now.Sub(time.Unix(0, stamp)); skew > nodeInfoMaxSkew || skew < -nodeInfoMaxSkew {
+			err = ErrNodeInfoExpired
+		} else if stamp <= this.lastStamp[addr] || stamp <= this.stampFloor {
+			err = ErrNodeInfoReplayed
+		}
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Node [ %s ] rejected: %s", addr, err.Error()))
+		this.NodeLog.Add(&NodeLog{
+			Time: now.UnixNano(),
+			Type: LOG_TYPE_NODE_REJECTED,
+			Log:  fmt.Sprintf("%s %s", addr, err.Error()),
+		})
+		this.changed()
+		return err
+	}
+	this.lastStamp[addr] = stamp
+	return nil
+}
+
 //节点主动关闭
 func (this *MasterComponent) NodeClose(addr string) {
 	//非线程安全，外层注意加锁
@@ -85,14 +273,33 @@ func (this *MasterComponent) NodeClose(addr string) {
 			s.WriteString("  ")
 		}
 		logger.Info(fmt.Sprintf("Node [ %s ] disconnected, roles: [ %s]", addr, s.String()))
+		this.watchers.publish(&MembershipEvent{Type: MEMBER_EVENT_LEAVE, Node: v})
 	}
 	delete(this.Nodes, addr)
 	delete(this.timeoutChecking, addr)
+	this.removeNodeActors(addr)
+	this.removeNodeTopics(addr)
+	this.removeNodeSingletons(addr)
 	this.NodeLog.Add(&NodeLog{
 		Time: time.Now().UnixNano(),
 		Type: LOG_TYPE_NODE_CLOSE,
 		Log:  addr,
 	})
+	this.changed()
+}
+
+//订阅成员变化，返回订阅id、事件通道和当前全量节点
+func (this *MasterComponent) Subscribe() (int, <-chan *MembershipEvent, map[string]*NodeInfo) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	id, ch := this.watchers.add()
+	return id, ch, utils.Copy(this.Nodes).(map[string]*NodeInfo)
+}
+
+func (this *MasterComponent) Unsubscribe(id int) {
+	this.locker.Lock()
+	this.watchers.remove(id)
+	this.locker.Unlock()
 }
 
 //查询节点信息 args : "AppID:Role:SelectorType"
@@ -105,6 +312,9 @@ func (this *MasterComponent) TimeoutCheck() map[string]*NodeInfo {
 	var interval = time.Duration(config.Config.ClusterConfig.ReportInterval)
 	for {
 		time.Sleep(time.Millisecond * interval)
+		if !this.IsLeader() {
+			continue
+		}
 		this.locker.Lock()
 		for addr, count := range this.timeoutChecking {
 			this.timeoutChecking[addr] = count + 1
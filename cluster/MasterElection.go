@@ -0,0 +1,529 @@
+package Cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/rpc"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	master高可用
+	多个master节点按Raft的方式选举leader：follower在选举超时内未收到leader心跳则发起选举，
+	获得多数票的候选者成为leader。leader通过心跳把节点表和节点日志的快照复制给follower，修改状态的请求在多数master确认后才回复，
+	不能在选举超时内联系到多数节点的leader主动退位。只有leader对外提供MasterService，
+	其他master返回ErrNotLeader并附带已知的leader地址，子节点据此跟随leader。
+*/
+
+const (
+	MASTER_STATE_FOLLOWER = iota
+	MASTER_STATE_CANDIDATE
+	MASTER_STATE_LEADER
+)
+
+var ErrNotLeader = errors.New("master: not leader")
+var ErrElectionStopped = errors.New("master: election stopped")
+var ErrNotCommitted = errors.New("master: state not replicated to a majority")
+
+//非leader时返回的错误，附带已知的leader地址
+func notLeaderError(leader string) error {
+	if leader == "" {
+		return ErrNotLeader
+	}
+	return errors.New(ErrNotLeader.Error() + ", leader: " + leader)
+}
+
+// LeaderFromError reports whether err was returned by a master that is not
+// the leader, and the leader address it suggested, which may be empty.
+func LeaderFromError(err error) (string, bool) {
+	if err == nil || !strings.HasPrefix(err.Error(), ErrNotLeader.Error()) {
+		return "", false
+	}
+	msg := strings.TrimPrefix(err.Error(), ErrNotLeader.Error())
+	return strings.TrimPrefix(msg, ", leader: "), true
+}
+
+// MasterState is the replicated state of the masters.
+type MasterState struct {
+	Nodes      map[string]*NodeInfo
+	NodeLog    *NodeLogs
+	LastStamp  map[string]int64
+	Actors     map[string]string          //actor目录
+	Topics     map[string]map[string]bool //订阅主题的节点
+	Singletons map[string]*SingletonLease //单例租约
+}
+
+type VoteArgs struct {
+	Term         uint64
+	Candidate    string
+	StateTerm    uint64 //候选者状态所属任期
+	StateVersion uint64 //候选者状态版本
+}
+
+type VoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendStateArgs struct {
+	Term         uint64
+	Leader       string
+	StateTerm    uint64
+	StateVersion uint64
+	State        *MasterState //follower已是最新时为空，仅作心跳
+}
+
+type AppendStateReply struct {
+	Term         uint64
+	StateTerm    uint64
+	StateVersion uint64
+}
+
+type masterElection struct {
+	self      string
+	peers     []string
+	timeout   time.Duration
+	getClient func(addr string) (*rpc.TcpClient, error)
+	snapshot  func() (state *MasterState, stateTerm, stateVersion uint64) //leader取状态快照及其版本
+	restore   func(state *MasterState)                                    //follower应用快照
+	onChange  func(leader string, isLeader bool)                          //leader变化
+
+	locker       sync.Mutex
+	state        int
+	term         uint64
+	votedFor     string
+	leader       string
+	stateTerm    uint64
+	stateVersion uint64
+	deadline     time.Time            //选举超时时间
+	lastAck      map[string]time.Time //leader收到各follower回复的时间
+	peerState    map[string][2]uint64 //各follower已确认的状态[任期,版本]
+	sending      map[string]bool      //正在向该follower发送
+	acked        chan struct{}        //follower确认状态时关闭并替换，唤醒等待提交的请求
+	stopped      bool
+	stop         chan struct{}
+
+	//按顺序应用快照，restore需要master锁，不能在locker内调用
+	applying sync.Mutex
+}
+
+func newMasterElection(self string, peers []string, timeout time.Duration) *masterElection {
+	others := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer != self {
+			others = append(others, peer)
+		}
+	}
+	return &masterElection{
+		self:      self,
+		peers:     others,
+		timeout:   timeout,
+		lastAck:   make(map[string]time.Time),
+		peerState: make(map[string][2]uint64),
+		sending:   make(map[string]bool),
+		acked:     make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+}
+
+func (this *masterElection) Start() {
+	this.locker.Lock()
+	this.resetDeadlineLocked()
+	this.locker.Unlock()
+	go this.run()
+}
+
+func (this *masterElection) Stop() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.stopped {
+		return
+	}
+	this.stopped = true
+	close(this.stop)
+}
+
+func (this *masterElection) IsLeader() bool {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.state == MASTER_STATE_LEADER
+}
+
+func (this *masterElection) Leader() string {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.leader
+}
+
+func (this *masterElection) Term() uint64 {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.term
+}
+
+//当前状态版本，调用方持有master锁以便与快照一致
+func (this *masterElection) StateVersion() (uint64, uint64) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.stateTerm, this.stateVersion
+}
+
+//leader修改状态后调用，调用方持有master锁
+func (this *masterElection) Changed() {
+	this.locker.Lock()
+	//已退位时不提升版本，状态由新leader的快照覆盖
+	if this.state == MASTER_STATE_LEADER {
+		this.stateTerm = this.term
+		this.stateVersion++
+	}
+	this.locker.Unlock()
+}
+
+//等待多数master确认当前状态，leader在回复修改状态的请求前调用，调用方不能持有master锁
+func (this *masterElection) Commit() error {
+	this.locker.Lock()
+	target := [2]uint64{this.stateTerm, this.stateVersion}
+	this.locker.Unlock()
+	deadline := time.NewTimer(this.timeout)
+	defer deadline.Stop()
+	interval := this.timeout / 5
+	if interval <= 0 {
+		interval = time.Millisecond * 10
+	}
+	retry := time.NewTicker(interval)
+	defer retry.Stop()
+	for {
+		this.locker.Lock()
+		if this.state != MASTER_STATE_LEADER {
+			leader := this.leader
+			this.locker.Unlock()
+			return notLeaderError(leader)
+		}
+		committed := 1
+		for _, peer := range this.peers {
+			state := this.peerState[peer]
+			if state[0] > target[0] || (state[0] == target[0] && state[1] >= target[1]) {
+				committed++
+			}
+		}
+		acked := this.acked
+		this.locker.Unlock()
+		if committed >= this.quorum() {
+			return nil
+		}
+		this.heartbeat()
+		select {
+		case <-acked:
+		case <-retry.C:
+		case <-deadline.C:
+			return ErrNotCommitted
+		case <-this.stop:
+			return ErrElectionStopped
+		}
+	}
+}
+
+func (this *masterElection) quorum() int {
+	return (len(this.peers)+1)/2 + 1
+}
+
+func (this *masterElection) resetDeadlineLocked() {
+	this.deadline = time.Now().Add(this.timeout + time.Duration(rand.Int63n(int64(this.timeout))))
+}
+
+func (this *masterElection) run() {
+	interval := this.timeout / 5
+	if interval <= 0 {
+		interval = time.Millisecond * 10
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-ticker.C:
+		}
+		this.locker.Lock()
+		state := this.state
+		expired := time.Now().After(this.deadline)
+		this.locker.Unlock()
+		switch {
+		case state == MASTER_STATE_LEADER:
+			this.checkQuorum()
+			this.heartbeat()
+		case expired:
+			this.campaign()
+		}
+	}
+}
+
+//发起选举
+func (this *masterElection) campaign() {
+	this.locker.Lock()
+	this.state = MASTER_STATE_CANDIDATE
+	this.term++
+	this.votedFor = this.self
+	this.leader = ""
+	this.resetDeadlineLocked()
+	args := &VoteArgs{
+		Term:         this.term,
+		Candidate:    this.self,
+		StateTerm:    this.stateTerm,
+		StateVersion: this.stateVersion,
+	}
+	this.locker.Unlock()
+	logger.Info(fmt.Sprintf("master [ %s ] starts election for term %d", this.self, args.Term))
+
+	votes := make(chan bool, len(this.peers))
+	for _, peer := range this.peers {
+		go func(peer string) {
+			reply := &VoteReply{}
+			if err := this.call(peer, "MasterElectionService.RequestVote", args, reply); err != nil {
+				votes <- false
+				return
+			}
+			this.observeTerm(reply.Term)
+			votes <- reply.Granted
+		}(peer)
+	}
+	granted := 1
+	for range this.peers {
+		if <-votes {
+			granted++
+		}
+		if granted >= this.quorum() {
+			break
+		}
+	}
+	if granted < this.quorum() {
+		return
+	}
+	//等待正在应用的快照，避免成为leader后被旧快照覆盖
+	this.applying.Lock()
+	this.locker.Lock()
+	if this.state != MASTER_STATE_CANDIDATE || this.term != args.Term {
+		this.locker.Unlock()
+		this.applying.Unlock()
+		return
+	}
+	this.state = MASTER_STATE_LEADER
+	this.leader = this.self
+	now := time.Now()
+	for _, peer := range this.peers {
+		this.lastAck[peer] = now
+		delete(this.peerState, peer)
+	}
+	//新任期的状态覆盖follower上可能残留的旧状态
+	this.stateTerm = this.term
+	this.stateVersion++
+	this.locker.Unlock()
+	this.applying.Unlock()
+	logger.Info(fmt.Sprintf("master [ %s ] is leader of term %d", this.self, args.Term))
+	this.changeLeader(this.self, true)
+	this.heartbeat()
+}
+
+//leader无法联系到多数节点时退位
+func (this *masterElection) checkQuorum() {
+	this.locker.Lock()
+	alive := 1
+	now := time.Now()
+	for _, peer := range this.peers {
+		if now.Sub(this.lastAck[peer]) < this.timeout {
+			alive++
+		}
+	}
+	if alive >= this.quorum() {
+		this.locker.Unlock()
+		return
+	}
+	this.state = MASTER_STATE_FOLLOWER
+	this.leader = ""
+	this.resetDeadlineLocked()
+	this.locker.Unlock()
+	logger.Warn(fmt.Sprintf("master [ %s ] lost quorum, steps down", this.self))
+	this.changeLeader("", false)
+}
+
+func (this *masterElection) heartbeat() {
+	for _, peer := range this.peers {
+		this.locker.Lock()
+		if this.state != MASTER_STATE_LEADER || this.sending[peer] {
+			this.locker.Unlock()
+			continue
+		}
+		this.sending[peer] = true
+		this.locker.Unlock()
+		go this.appendState(peer)
+	}
+}
+
+func (this *masterElection) appendState(peer string) {
+	defer func() {
+		this.locker.Lock()
+		delete(this.sending, peer)
+		this.locker.Unlock()
+	}()
+	this.locker.Lock()
+	args := &AppendStateArgs{Term: this.term, Leader: this.self}
+	acked := this.peerState[peer]
+	current := [2]uint64{this.stateTerm, this.stateVersion}
+	this.locker.Unlock()
+	if acked != current && this.snapshot != nil {
+		args.State, args.StateTerm, args.StateVersion = this.snapshot()
+	} else {
+		args.StateTerm, args.StateVersion = current[0], current[1]
+	}
+	reply := &AppendStateReply{}
+	if err := this.call(peer, "MasterElectionService.AppendState", args, reply); err != nil {
+		return
+	}
+	if this.observeTerm(reply.Term) {
+		return
+	}
+	this.locker.Lock()
+	this.lastAck[peer] = time.Now()
+	this.peerState[peer] = [2]uint64{reply.StateTerm, reply.StateVersion}
+	close(this.acked)
+	this.acked = make(chan struct{})
+	this.locker.Unlock()
+}
+
+//发现更高任期时转为follower，返回是否发生转换
+func (this *masterElection) observeTerm(term uint64) bool {
+	this.locker.Lock()
+	if term <= this.term {
+		this.locker.Unlock()
+		return false
+	}
+	wasLeader := this.state == MASTER_STATE_LEADER
+	this.term = term
+	this.state = MASTER_STATE_FOLLOWER
+	this.votedFor = ""
+	this.leader = ""
+	this.resetDeadlineLocked()
+	this.locker.Unlock()
+	if wasLeader {
+		this.changeLeader("", false)
+	}
+	return true
+}
+
+func (this *masterElection) changeLeader(leader string, isLeader bool) {
+	if this.onChange != nil {
+		this.onChange(leader, isLeader)
+	}
+}
+
+func (this *masterElection) call(peer string, serviceMethod string, args interface{}, reply interface{}) error {
+	client, err := this.getClient(peer)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout/2)
+	defer cancel()
+	return client.CallContext(ctx, serviceMethod, args, reply)
+}
+
+func (this *masterElection) requestVote(args *VoteArgs, reply *VoteReply) error {
+	this.locker.Lock()
+	if this.stopped {
+		this.locker.Unlock()
+		return ErrElectionStopped
+	}
+	wasLeader := false
+	if args.Term > this.term {
+		wasLeader = this.state == MASTER_STATE_LEADER
+		this.term = args.Term
+		this.state = MASTER_STATE_FOLLOWER
+		this.votedFor = ""
+		this.leader = ""
+	}
+	reply.Term = this.term
+	//候选者的状态不能比自己旧
+	upToDate := args.StateTerm > this.stateTerm ||
+		(args.StateTerm == this.stateTerm && args.StateVersion >= this.stateVersion)
+	if args.Term == this.term && (this.votedFor == "" || this.votedFor == args.Candidate) && upToDate {
+		this.votedFor = args.Candidate
+		reply.Granted = true
+		this.resetDeadlineLocked()
+	}
+	this.locker.Unlock()
+	if wasLeader {
+		this.changeLeader("", false)
+	}
+	return nil
+}
+
+func (this *masterElection) appendStateFrom(args *AppendStateArgs, reply *AppendStateReply) error {
+	this.locker.Lock()
+	if this.stopped {
+		this.locker.Unlock()
+		return ErrElectionStopped
+	}
+	if args.Term < this.term {
+		reply.Term = this.term
+		this.locker.Unlock()
+		return nil
+	}
+	wasLeader := this.state == MASTER_STATE_LEADER
+	if args.Term > this.term {
+		this.votedFor = ""
+	}
+	this.term = args.Term
+	this.state = MASTER_STATE_FOLLOWER
+	changed := this.leader != args.Leader
+	this.leader = args.Leader
+	this.resetDeadlineLocked()
+	this.locker.Unlock()
+
+	if args.State != nil && this.restore != nil {
+		this.applyState(args)
+	}
+	if changed || wasLeader {
+		logger.Info(fmt.Sprintf("master [ %s ] follows leader [ %s ], term %d", this.self, args.Leader, args.Term))
+		this.changeLeader(args.Leader, false)
+	}
+	this.locker.Lock()
+	reply.Term = this.term
+	reply.StateTerm, reply.StateVersion = this.stateTerm, this.stateVersion
+	this.locker.Unlock()
+	return nil
+}
+
+//按顺序应用leader的快照，只应用当前任期内比本地新的快照
+func (this *masterElection) applyState(args *AppendStateArgs) {
+	this.applying.Lock()
+	defer this.applying.Unlock()
+	this.locker.Lock()
+	newer := args.Term == this.term && this.state == MASTER_STATE_FOLLOWER &&
+		(args.StateTerm > this.stateTerm || (args.StateTerm == this.stateTerm && args.StateVersion > this.stateVersion))
+	this.locker.Unlock()
+	if !newer {
+		return
+	}
+	this.restore(args.State)
+	this.locker.Lock()
+	this.stateTerm, this.stateVersion = args.StateTerm, args.StateVersion
+	this.locker.Unlock()
+}
+
+// MasterElectionService is the rpc service masters use to elect a leader and
+// replicate state.
+type MasterElectionService struct {
+	election *masterElection
+}
+
+func (this *MasterElectionService) RequestVote(args *VoteArgs, reply *VoteReply) error {
+	return this.election.requestVote(args, reply)
+}
+
+func (this *MasterElectionService) AppendState(args *AppendStateArgs, reply *AppendStateReply) error {
+	return this.election.appendStateFrom(args, reply)
+}
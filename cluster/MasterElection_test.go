@@ -0,0 +1,267 @@
+package Cluster
+
+import (
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/rpc"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMaster struct {
+	addr     string
+	election *masterElection
+	listener net.Listener
+
+	locker  sync.Mutex
+	state   *MasterState
+	clients map[string]*rpc.TcpClient
+}
+
+func (this *testMaster) getClient(addr string) (*rpc.TcpClient, error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if client, ok := this.clients[addr]; ok && !client.IsClosed() {
+		return client, nil
+	}
+	client, err := rpc.NewTcpClient("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	this.clients[addr] = client
+	return client, nil
+}
+
+func (this *testMaster) nodes() int {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return len(this.state.Nodes)
+}
+
+func (this *testMaster) stop() {
+	this.election.Stop()
+	this.listener.Close()
+	this.locker.Lock()
+	for _, client := range this.clients {
+		client.Close()
+	}
+	this.locker.Unlock()
+}
+
+func newTestMasters(t *testing.T, n int, timeout time.Duration) []*testMaster {
+	masters := make([]*testMaster, n)
+	peers := make([]string, n)
+	for i := range masters {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers[i] = l.Addr().String()
+		masters[i] = &testMaster{
+			addr:     peers[i],
+			listener: l,
+			state:    &MasterState{Nodes: map[string]*NodeInfo{}},
+			clients:  make(map[string]*rpc.TcpClient),
+		}
+	}
+	for _, m := range masters {
+		m := m
+		m.election = newMasterElection(m.addr, peers, timeout)
+		m.election.getClient = m.getClient
+		m.election.snapshot = func() (*MasterState, uint64, uint64) {
+			m.locker.Lock()
+			defer m.locker.Unlock()
+			stateTerm, stateVersion := m.election.StateVersion()
+			nodes := make(map[string]*NodeInfo)
+			for k, v := range m.state.Nodes {
+				nodes[k] = v
+			}
+			return &MasterState{Nodes: nodes}, stateTerm, stateVersion
+		}
+		m.election.restore = func(state *MasterState) {
+			m.locker.Lock()
+			m.state = state
+			m.locker.Unlock()
+		}
+		server := rpc.NewServer()
+		server.Register(&MasterElectionService{election: m.election})
+		go server.Accept(m.listener)
+	}
+	for _, m := range masters {
+		m.election.Start()
+	}
+	return masters
+}
+
+func waitLeader(t *testing.T, masters []*testMaster, timeout time.Duration) *testMaster {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		var leaders []*testMaster
+		for _, m := range masters {
+			if m.election.IsLeader() {
+				leaders = append(leaders, m)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("no single leader elected")
+	return nil
+}
+
+func TestMasterElection(t *testing.T) {
+	const timeout = time.Millisecond * 200
+	masters := newTestMasters(t, 3, timeout)
+	defer func() {
+		for _, m := range masters {
+			m.stop()
+		}
+	}()
+
+	leader := waitLeader(t, masters, time.Second*5)
+	for _, m := range masters {
+		if m != leader && m.election.Leader() != "" && m.election.Leader() != leader.addr {
+			t.Errorf("master %s follows %s, leader is %s", m.addr, m.election.Leader(), leader.addr)
+		}
+	}
+
+	// State changed on the leader is replicated to the followers.
+	leader.locker.Lock()
+	leader.state.Nodes["127.0.0.1:9000"] = &NodeInfo{Address: "127.0.0.1:9000", Role: []string{"gate"}}
+	leader.election.Changed()
+	leader.locker.Unlock()
+	deadline := time.Now().Add(time.Second * 2)
+	for _, m := range masters {
+		for m.nodes() != 1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 20)
+		}
+		if m.nodes() != 1 {
+			t.Fatalf("master %s has %d nodes, want 1", m.addr, m.nodes())
+		}
+	}
+
+	// A committed change has been applied by a majority when Commit returns.
+	leader.locker.Lock()
+	leader.state.Nodes["127.0.0.1:9001"] = &NodeInfo{Address: "127.0.0.1:9001", Role: []string{"gate"}}
+	leader.election.Changed()
+	leader.locker.Unlock()
+	if err := leader.election.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	applied := 0
+	for _, m := range masters {
+		if m.nodes() == 2 {
+			applied++
+		}
+	}
+	if applied < leader.election.quorum() {
+		t.Fatalf("a committed change is applied by %d masters, want a majority", applied)
+	}
+
+	// The remaining masters elect a new leader that keeps the state.
+	term := leader.election.Term()
+	leader.stop()
+	var rest []*testMaster
+	for _, m := range masters {
+		if m != leader {
+			rest = append(rest, m)
+		}
+	}
+	next := waitLeader(t, rest, time.Second*5)
+	if next.election.Term() <= term {
+		t.Errorf("new leader term %d, want > %d", next.election.Term(), term)
+	}
+	if next.nodes() != 2 {
+		t.Errorf("new leader has %d nodes, want 2", next.nodes())
+	}
+}
+
+func TestAppendStateOrder(t *testing.T) {
+	election := newMasterElection("127.0.0.1:6600", []string{"127.0.0.1:6600"}, time.Second)
+	var restored []int
+	election.restore = func(state *MasterState) {
+		restored = append(restored, len(state.Nodes))
+	}
+	appendState := func(term, stateVersion uint64, nodes int) {
+		state := &MasterState{Nodes: map[string]*NodeInfo{}}
+		for i := 0; i < nodes; i++ {
+			state.Nodes[string(rune('a'+i))] = &NodeInfo{}
+		}
+		args := &AppendStateArgs{Term: term, Leader: "127.0.0.1:6601", StateTerm: term, StateVersion: stateVersion, State: state}
+		if err := election.appendStateFrom(args, &AppendStateReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendState(1, 2, 2)
+	// Snapshots that are not newer, or from an older leader, are not applied.
+	appendState(1, 1, 1)
+	appendState(1, 2, 1)
+	appendState(2, 1, 3)
+	appendState(1, 5, 1)
+	if len(restored) != 2 || restored[0] != 2 || restored[1] != 3 {
+		t.Fatalf("expected only newer snapshots to be applied, got %v", restored)
+	}
+	if stateTerm, stateVersion := election.StateVersion(); stateTerm != 2 || stateVersion != 1 {
+		t.Fatalf("expected state 2/1, got %d/%d", stateTerm, stateVersion)
+	}
+}
+
+func TestLeaderFromError(t *testing.T) {
+	if leader, ok := LeaderFromError(notLeaderError("127.0.0.1:6666")); !ok || leader != "127.0.0.1:6666" {
+		t.Errorf("got %q %v", leader, ok)
+	}
+	if leader, ok := LeaderFromError(rpc.ServerError(notLeaderError("").Error())); !ok || leader != "" {
+		t.Errorf("got %q %v", leader, ok)
+	}
+	if _, ok := LeaderFromError(ErrNodeOffline); ok {
+		t.Error("expected other errors not to be reported as not leader")
+	}
+}
+
+func TestMasterStateVersion(t *testing.T) {
+	master := newAuthMaster("")
+	master.election = newMasterElection("127.0.0.1:6600", []string{"127.0.0.1:6600"}, time.Second)
+	master.election.state = MASTER_STATE_LEADER
+	version := func() uint64 {
+		_, v := master.election.StateVersion()
+		return v
+	}
+
+	master.UpdateNodeInfo(&NodeInfo{Address: "127.0.0.1:6605", Role: []string{"room"}, Info: map[string]float32{"cpu": 0.1}})
+	joined := version()
+	if joined == 0 {
+		t.Fatal("a joining node should change the replicated state")
+	}
+	// Load reports are not replicated.
+	for i := 0; i < 3; i++ {
+		master.UpdateNodeInfo(&NodeInfo{Address: "127.0.0.1:6605", Role: []string{"room"}, Info: map[string]float32{"cpu": float32(i)}})
+	}
+	if v := version(); v != joined {
+		t.Fatalf("load reports should not change the state version, %d -> %d", joined, v)
+	}
+	master.UpdateNodeInfo(&NodeInfo{Address: "127.0.0.1:6605", Role: []string{"room"}, Draining: true})
+	if v := version(); v == joined {
+		t.Fatal("draining should change the replicated state")
+	}
+
+	// Accepted signatures are not replicated, a new leader rejects stamps from before it took over.
+	config.Config.ClusterConfig.ClusterSecret = "secret"
+	info := &NodeInfo{Address: "127.0.0.1:6605"}
+	info.Sign("secret")
+	before := version()
+	if err := master.CheckNodeInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(); v != before {
+		t.Fatal("accepted signatures should not change the state version")
+	}
+	old := &NodeInfo{Address: "127.0.0.1:6606"}
+	old.Sign("secret")
+	master.stampFloor = time.Now().UnixNano()
+	if err := master.CheckNodeInfo(old); err != ErrNodeInfoReplayed {
+		t.Fatalf("expected a stamp from before the leader change to be rejected, got %v", err)
+	}
+}
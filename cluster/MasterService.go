@@ -2,14 +2,19 @@ package Cluster
 
 import (
 	"errors"
+	"github.com/zllangct/rockgo/rpc"
 )
 
 type NodeInfo struct {
-	Time    int64
-	Address string
-	Role    []string
-	AppName string
-	Info    map[string]float32
+	Time      int64
+	Joined    int64 //首次上报时间，由master设置，纳秒
+	Address   string
+	Role      []string
+	AppName   string
+	Info      map[string]float32
+	Draining  bool   //节点正在停机排空，查询时不再被选择
+	Stamp     int64  //签名时间，纳秒
+	Signature string //集群密钥签名，未配置密钥时为空
 }
 
 type InquiryReply struct {
@@ -25,33 +30,95 @@ func (this *MasterService) init(master *MasterComponent) {
 	this.master = master
 }
 
-func (this *MasterService) ReportNodeClose(addr string, reply *bool) error {
+func (this *MasterService) ReportNodeClose(args *NodeCloseArgs, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	if err := this.master.CheckNodeClose(args); err != nil {
+		return err
+	}
 	this.master.locker.Lock()
-	this.master.NodeClose(addr)
+	this.master.NodeClose(args.Address)
 	this.master.locker.Unlock()
+	if err := this.master.commit(); err != nil {
+		return err
+	}
 	*reply = true
 	return nil
 }
 
 func (this *MasterService) ReportNodeInfo(args *NodeInfo, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	if err := this.master.CheckNodeInfo(args); err != nil {
+		return err
+	}
 	this.master.UpdateNodeInfo(args)
+	if err := this.master.commit(); err != nil {
+		return err
+	}
 	*reply = true
 	return nil
 }
 
 func (this *MasterService) NodeInquiry(args []string, reply *[]*InquiryReply) error {
 	//logger.Debug("Inquiry :",args)
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
 	res, err := this.master.NodeInquiry(args, false)
 	*reply = res
 	return err
 }
 
 func (this *MasterService) NodeInquiryDetail(args []string, reply *[]*InquiryReply) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
 	res, err := this.master.NodeInquiry(args, true)
 	*reply = res
 	return err
 }
 
+//确认本master是否为leader，不是时返回的错误携带leader地址
+func (this *MasterService) LeaderInquiry(args string, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+//订阅成员变化，先推送全量节点，之后推送变化，直到订阅者取消或断开
+func (this *MasterService) Watch(args string, out *rpc.StreamSender) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	id, events, nodes := this.master.Subscribe()
+	defer this.master.Unsubscribe(id)
+	err := out.Send(&MembershipEvent{Type: MEMBER_EVENT_SNAPSHOT, Nodes: nodes})
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if err := this.master.checkLeader(); err != nil {
+					return err
+				}
+				return ErrWatchOverflow
+			}
+			if err = out.Send(event); err != nil {
+				return err
+			}
+		case <-out.Done():
+			return nil
+		}
+	}
+}
+
 type NodeInfoSyncReply struct {
 	Nodes   map[string]*NodeInfo
 	NodeLog *NodeLogs
@@ -61,6 +128,9 @@ func (this *MasterService) NodeInfoSync(args string, reply *NodeInfoSyncReply) e
 	if args != "sync" {
 		return errors.New("call service [ NodeInfoSynchronous ],has wrong argument")
 	}
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
 	*reply = NodeInfoSyncReply{
 		Nodes:   this.master.NodesCopy(),
 		NodeLog: this.master.NodesLogsCopy(),
@@ -0,0 +1,223 @@
+package Cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/logger"
+	"github.com/zllangct/rockgo/rpc"
+	"github.com/zllangct/rockgo/utils"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+	成员变化推送
+	master把节点的加入、离开、角色变化和负载变化推送给订阅者，订阅开始时先推送一次全量节点。
+	订阅者处理过慢时master断开订阅，订阅者重新订阅后从全量节点重新开始。
+*/
+
+const (
+	MEMBER_EVENT_JOIN = iota
+	MEMBER_EVENT_LEAVE
+	MEMBER_EVENT_ROLE_CHANGE //角色、应用或排空状态变化
+	MEMBER_EVENT_LOAD_CHANGE
+	MEMBER_EVENT_SNAPSHOT //订阅开始时的全量节点，不传给OnMembershipChange
+)
+
+//每个订阅者未发送事件的上限
+const membershipBacklog = 256
+
+var ErrWatchOverflow = errors.New("membership watch overflow")
+
+// MembershipEvent describes a change of cluster membership. Node is the node
+// after the change; for MEMBER_EVENT_LEAVE it is the last known node info.
+type MembershipEvent struct {
+	Type  int
+	Node  *NodeInfo
+	Nodes map[string]*NodeInfo //仅MEMBER_EVENT_SNAPSHOT
+}
+
+func (this *MembershipEvent) String() string {
+	switch this.Type {
+	case MEMBER_EVENT_JOIN:
+		return "join " + this.Node.Address
+	case MEMBER_EVENT_LEAVE:
+		return "leave " + this.Node.Address
+	case MEMBER_EVENT_ROLE_CHANGE:
+		return "role change " + this.Node.Address
+	case MEMBER_EVENT_LOAD_CHANGE:
+		return "load change " + this.Node.Address
+	case MEMBER_EVENT_SNAPSHOT:
+		return fmt.Sprintf("snapshot of %d nodes", len(this.Nodes))
+	}
+	return "unknown"
+}
+
+//节点从old变为new产生的事件，没有变化时返回-1
+func memberChange(old, new *NodeInfo) int {
+	switch {
+	case old == nil && new == nil:
+		return -1
+	case old == nil:
+		return MEMBER_EVENT_JOIN
+	case new == nil:
+		return MEMBER_EVENT_LEAVE
+	case old.AppName != new.AppName || strings.Join(old.Role, ",") != strings.Join(new.Role, ",") || old.Draining != new.Draining:
+		return MEMBER_EVENT_ROLE_CHANGE
+	case !reflect.DeepEqual(old.Info, new.Info):
+		return MEMBER_EVENT_LOAD_CHANGE
+	}
+	return -1
+}
+
+//两次成员列表之间的变化
+func diffMembership(old, new map[string]*NodeInfo) []*MembershipEvent {
+	events := make([]*MembershipEvent, 0)
+	for addr, info := range new {
+		if typ := memberChange(old[addr], info); typ != -1 {
+			events = append(events, &MembershipEvent{Type: typ, Node: info})
+		}
+	}
+	for addr, info := range old {
+		if _, ok := new[addr]; !ok {
+			events = append(events, &MembershipEvent{Type: MEMBER_EVENT_LEAVE, Node: info})
+		}
+	}
+	return events
+}
+
+//应用事件到成员列表
+func applyMembership(nodes map[string]*NodeInfo, event *MembershipEvent) map[string]*NodeInfo {
+	switch event.Type {
+	case MEMBER_EVENT_SNAPSHOT:
+		nodes = event.Nodes
+		if nodes == nil {
+			nodes = make(map[string]*NodeInfo)
+		}
+	case MEMBER_EVENT_LEAVE:
+		delete(nodes, event.Node.Address)
+	default:
+		nodes[event.Node.Address] = event.Node
+	}
+	return nodes
+}
+
+/*
+	master端订阅管理，调用方持有master锁
+*/
+type membershipWatchers struct {
+	nextID   int
+	watchers map[int]chan *MembershipEvent
+}
+
+func (this *membershipWatchers) add() (int, chan *MembershipEvent) {
+	if this.watchers == nil {
+		this.watchers = make(map[int]chan *MembershipEvent)
+	}
+	this.nextID++
+	ch := make(chan *MembershipEvent, membershipBacklog)
+	this.watchers[this.nextID] = ch
+	return this.nextID, ch
+}
+
+func (this *membershipWatchers) remove(id int) {
+	if ch, ok := this.watchers[id]; ok {
+		delete(this.watchers, id)
+		close(ch)
+	}
+}
+
+func (this *membershipWatchers) removeAll() {
+	for id := range this.watchers {
+		this.remove(id)
+	}
+}
+
+//推送事件，积压过多的订阅者被断开
+func (this *membershipWatchers) publish(event *MembershipEvent) {
+	for id, ch := range this.watchers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warn(fmt.Sprintf("membership watcher %d is too slow, dropped", id))
+			this.remove(id)
+		}
+	}
+}
+
+/*
+	订阅端
+*/
+
+//订阅master的成员变化，跟随master leader，断开后重新订阅，直到ctx结束
+func (this *MasterDiscovery) watch(ctx context.Context, handler func(event *MembershipEvent)) {
+	for ctx.Err() == nil {
+		stream, event, err := this.subscribe(ctx)
+		for err == nil {
+			handler(event)
+			event = &MembershipEvent{}
+			err = stream.Recv(event)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Millisecond * time.Duration(config.Config.ClusterConfig.LocationSyncInterval)):
+		}
+	}
+}
+
+//在master leader上打开订阅，返回订阅和第一个事件
+func (this *MasterDiscovery) subscribe(ctx context.Context) (*rpc.ServerStreamCall, *MembershipEvent, error) {
+	var err error
+	tried := make(map[string]bool)
+	candidates := this.node.masterCandidates()
+	for len(candidates) > 0 {
+		addr := candidates[0]
+		candidates = candidates[1:]
+		if tried[addr] {
+			continue
+		}
+		tried[addr] = true
+		var client *rpc.TcpClient
+		client, err = this.node.GetNodeClient(addr)
+		if err != nil {
+			continue
+		}
+		var stream *rpc.ServerStreamCall
+		stream, err = client.OpenServerStream(ctx, "MasterService.Watch", "watch")
+		if err != nil {
+			continue
+		}
+		//非leader在第一次接收时返回错误
+		first := &MembershipEvent{}
+		if err = stream.Recv(first); err == nil {
+			return stream, first, nil
+		}
+		if leader, ok := LeaderFromError(err); ok && leader != "" {
+			this.node.SetMasterLeader(leader)
+			candidates = append([]string{leader}, candidates...)
+		}
+	}
+	if err == nil {
+		err = ErrNodeOffline
+	}
+	return nil, nil, err
+}
+
+// Watch subscribes to membership changes pushed by the master leader.
+func (this *MasterDiscovery) Watch(handler func(nodes map[string]*NodeInfo)) (cancel func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		nodes := make(map[string]*NodeInfo)
+		this.watch(ctx, func(event *MembershipEvent) {
+			nodes = applyMembership(nodes, event)
+			handler(utils.Copy(nodes).(map[string]*NodeInfo))
+		})
+	}()
+	return cancel
+}
@@ -0,0 +1,92 @@
+package Cluster
+
+import (
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/rpc"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMembershipWatch(t *testing.T) {
+	conf := &config.ConfigComponent{}
+	conf.SetDefault()
+	config.Config = conf
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	master := &MasterComponent{
+		locker:          &sync.RWMutex{},
+		Nodes:           make(map[string]*NodeInfo),
+		NodeLog:         &NodeLogs{BufferSize: 20},
+		timeoutChecking: make(map[string]int),
+		lastStamp:       make(map[string]int64),
+	}
+	server := rpc.NewServer()
+	service := new(MasterService)
+	service.init(master)
+	server.Register(service)
+	go server.Accept(l)
+	conf.ClusterConfig.MasterAddress = l.Addr().String()
+
+	room := &NodeInfo{Address: "127.0.0.1:6605", AppName: "app", Role: []string{"room"}}
+	master.UpdateNodeInfo(room)
+
+	node := &NodeComponent{isOnline: true}
+	node.discovery = NewMasterDiscovery(node)
+	events := make(chan *MembershipEvent, 16)
+	node.OnMembershipChange(func(event *MembershipEvent) {
+		events <- event
+	})
+	expect := func(typ int, addr string) {
+		select {
+		case event := <-events:
+			if event.Type != typ || event.Node.Address != addr {
+				t.Fatalf("expected %d %s; got %s", typ, addr, event)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("expected %d %s; got nothing", typ, addr)
+		}
+	}
+	// The nodes already in the cluster are reported as joins.
+	expect(MEMBER_EVENT_JOIN, room.Address)
+
+	gate := &NodeInfo{Address: "127.0.0.1:6601", AppName: "app", Role: []string{"gate"}}
+	master.UpdateNodeInfo(gate)
+	expect(MEMBER_EVENT_JOIN, gate.Address)
+
+	// Reports without changes are not pushed.
+	master.UpdateNodeInfo(&NodeInfo{Address: gate.Address, AppName: "app", Role: []string{"gate"}})
+	master.UpdateNodeInfo(&NodeInfo{Address: gate.Address, AppName: "app", Role: []string{"gate"}, Info: map[string]float32{"cpu": 0.5}})
+	expect(MEMBER_EVENT_LOAD_CHANGE, gate.Address)
+	master.UpdateNodeInfo(&NodeInfo{Address: gate.Address, AppName: "app", Role: []string{"gate", "login"}, Info: map[string]float32{"cpu": 0.5}})
+	expect(MEMBER_EVENT_ROLE_CHANGE, gate.Address)
+
+	master.locker.Lock()
+	master.NodeClose(room.Address)
+	master.locker.Unlock()
+	expect(MEMBER_EVENT_LEAVE, room.Address)
+
+	// A late handler gets the current members as joins.
+	late := make(chan *MembershipEvent, 16)
+	node.OnMembershipChange(func(event *MembershipEvent) {
+		late <- event
+	})
+	select {
+	case event := <-late:
+		if event.Type != MEMBER_EVENT_JOIN || event.Node.Address != gate.Address {
+			t.Errorf("late handler: expected join %s; got %s", gate.Address, event)
+		}
+	default:
+		t.Error("late handler got no join")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s", event)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
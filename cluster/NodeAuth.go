@@ -0,0 +1,147 @@
+package Cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/zllangct/rockgo/config"
+	"math"
+	"sort"
+	"time"
+)
+
+/*
+	节点上报签名
+	配置了集群密钥(ClusterSecret)时，子节点用HMAC-SHA256对上报内容和时间戳签名，
+	master校验签名、时间偏差和时间戳单调递增，位置服务同步时再次校验，拒绝伪造和重放的上报。
+	节点关闭的上报同样签名，与节点信息共用时间戳序列，签名内容带有类型前缀，二者不能互相冒用。
+*/
+
+//签名时间与master时间允许的最大偏差
+const nodeInfoMaxSkew = time.Second * 30
+
+var (
+	ErrNodeInfoUnsigned  = errors.New("node info is not signed")
+	ErrNodeInfoSignature = errors.New("node info signature mismatch")
+	ErrNodeInfoExpired   = errors.New("node info timestamp out of range")
+	ErrNodeInfoReplayed  = errors.New("node info replayed")
+)
+
+//签名内容类型前缀
+const (
+	signKindNodeInfo  = 1
+	signKindNodeClose = 2
+)
+
+//签名内容：地址、应用、角色、上报信息(按键排序)、排空状态、时间戳
+func (this *NodeInfo) signContent() []byte {
+	var buf []byte
+	var num [8]byte
+	appendString := func(s string) {
+		binary.BigEndian.PutUint64(num[:], uint64(len(s)))
+		buf = append(buf, num[:]...)
+		buf = append(buf, s...)
+	}
+	buf = append(buf, signKindNodeInfo)
+	appendString(this.Address)
+	appendString(this.AppName)
+	binary.BigEndian.PutUint64(num[:], uint64(len(this.Role)))
+	buf = append(buf, num[:]...)
+	for _, role := range this.Role {
+		appendString(role)
+	}
+	keys := make([]string, 0, len(this.Info))
+	for key := range this.Info {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	binary.BigEndian.PutUint64(num[:], uint64(len(keys)))
+	buf = append(buf, num[:]...)
+	for _, key := range keys {
+		appendString(key)
+		binary.BigEndian.PutUint32(num[:4], math.Float32bits(this.Info[key]))
+		buf = append(buf, num[:4]...)
+	}
+	if this.Draining {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	binary.BigEndian.PutUint64(num[:], uint64(this.Stamp))
+	return append(buf, num[:]...)
+}
+
+func (this *NodeInfo) mac(secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(this.signContent())
+	return h.Sum(nil)
+}
+
+// Sign stamps the node info with the current time and signs it with the cluster secret.
+func (this *NodeInfo) Sign(secret string) {
+	this.Stamp = time.Now().UnixNano()
+	this.Signature = hex.EncodeToString(this.mac(secret))
+}
+
+// Verify checks the signature against the cluster secret. It does not check
+// the timestamp; see MasterComponent.CheckNodeInfo for replay protection.
+func (this *NodeInfo) Verify(secret string) error {
+	if this.Signature == "" {
+		return ErrNodeInfoUnsigned
+	}
+	signature, err := hex.DecodeString(this.Signature)
+	if err != nil || !hmac.Equal(signature, this.mac(secret)) {
+		return ErrNodeInfoSignature
+	}
+	return nil
+}
+
+// NodeCloseArgs reports that a node is leaving the cluster.
+type NodeCloseArgs struct {
+	Address   string
+	Stamp     int64  //签名时间，纳秒
+	Signature string //集群密钥签名，未配置密钥时为空
+}
+
+//签名内容：类型前缀、地址、时间戳
+func (this *NodeCloseArgs) mac(secret string) []byte {
+	var num [8]byte
+	buf := []byte{signKindNodeClose}
+	binary.BigEndian.PutUint64(num[:], uint64(len(this.Address)))
+	buf = append(buf, num[:]...)
+	buf = append(buf, this.Address...)
+	binary.BigEndian.PutUint64(num[:], uint64(this.Stamp))
+	buf = append(buf, num[:]...)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+// Sign stamps the report with the current time and signs it with the cluster secret.
+func (this *NodeCloseArgs) Sign(secret string) {
+	this.Stamp = time.Now().UnixNano()
+	this.Signature = hex.EncodeToString(this.mac(secret))
+}
+
+// Verify checks the signature against the cluster secret.
+func (this *NodeCloseArgs) Verify(secret string) error {
+	if this.Signature == "" {
+		return ErrNodeInfoUnsigned
+	}
+	signature, err := hex.DecodeString(this.Signature)
+	if err != nil || !hmac.Equal(signature, this.mac(secret)) {
+		return ErrNodeInfoSignature
+	}
+	return nil
+}
+
+//节点关闭上报，配置了集群密钥时签名
+func newNodeCloseArgs(addr string) *NodeCloseArgs {
+	args := &NodeCloseArgs{Address: addr}
+	if secret := config.Config.ClusterConfig.ClusterSecret; secret != "" {
+		args.Sign(secret)
+	}
+	return args
+}
@@ -0,0 +1,146 @@
+package Cluster
+
+import (
+	"encoding/hex"
+	"github.com/zllangct/rockgo/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newAuthMaster(secret string) *MasterComponent {
+	conf := &config.ConfigComponent{}
+	conf.SetDefault()
+	conf.ClusterConfig.ClusterSecret = secret
+	config.Config = conf
+	return &MasterComponent{
+		locker:          &sync.RWMutex{},
+		Nodes:           make(map[string]*NodeInfo),
+		NodeLog:         &NodeLogs{BufferSize: 20},
+		timeoutChecking: make(map[string]int),
+		lastStamp:       make(map[string]int64),
+	}
+}
+
+func TestNodeInfoSign(t *testing.T) {
+	info := &NodeInfo{
+		Address: "127.0.0.1:6605",
+		AppName: "app",
+		Role:    []string{"room"},
+		Info:    map[string]float32{"cpu": 0.5, "mem": 0.25},
+	}
+	if err := info.Verify("secret"); err != ErrNodeInfoUnsigned {
+		t.Fatalf("expected unsigned error, got %v", err)
+	}
+	info.Sign("secret")
+	if err := info.Verify("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := info.Verify("other"); err != ErrNodeInfoSignature {
+		t.Fatalf("expected signature error with another secret, got %v", err)
+	}
+
+	// Any change to the signed fields breaks the signature.
+	forged := []func(info *NodeInfo){
+		func(info *NodeInfo) { info.Address = "127.0.0.1:6606" },
+		func(info *NodeInfo) { info.Role = []string{"gate"} },
+		func(info *NodeInfo) { info.Info["cpu"] = 0.1 },
+		func(info *NodeInfo) { info.Draining = true },
+		func(info *NodeInfo) { info.Stamp++ },
+	}
+	for i, forge := range forged {
+		copied := *info
+		copied.Role = append([]string(nil), info.Role...)
+		copied.Info = map[string]float32{}
+		for k, v := range info.Info {
+			copied.Info[k] = v
+		}
+		forge(&copied)
+		if err := copied.Verify("secret"); err != ErrNodeInfoSignature {
+			t.Errorf("forgery %d: expected signature error, got %v", i, err)
+		}
+	}
+}
+
+func TestCheckNodeInfo(t *testing.T) {
+	master := newAuthMaster("secret")
+	info := &NodeInfo{Address: "127.0.0.1:6605", Role: []string{"room"}}
+	info.Sign("secret")
+	if err := master.CheckNodeInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	if err := master.CheckNodeInfo(info); err != ErrNodeInfoReplayed {
+		t.Fatalf("expected replayed report to be rejected, got %v", err)
+	}
+
+	forged := &NodeInfo{Address: "127.0.0.1:6605", Role: []string{"room"}}
+	forged.Sign("guess")
+	if err := master.CheckNodeInfo(forged); err != ErrNodeInfoSignature {
+		t.Fatalf("expected forged report to be rejected, got %v", err)
+	}
+
+	stale := &NodeInfo{Address: "127.0.0.1:6606"}
+	stale.Stamp = time.Now().Add(-2 * nodeInfoMaxSkew).UnixNano()
+	stale.Signature = hex.EncodeToString(stale.mac("secret"))
+	if err := master.CheckNodeInfo(stale); err != ErrNodeInfoExpired {
+		t.Fatalf("expected stale report to be rejected, got %v", err)
+	}
+}
+
+func TestReportNodeClose(t *testing.T) {
+	master := newAuthMaster("secret")
+	service := new(MasterService)
+	service.init(master)
+	const addr = "127.0.0.1:6605"
+	join := func() {
+		info := &NodeInfo{Address: addr, Role: []string{"room"}}
+		info.Sign("secret")
+		if err := service.ReportNodeInfo(info, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	joined := func() bool {
+		master.locker.RLock()
+		defer master.locker.RUnlock()
+		_, ok := master.Nodes[addr]
+		return ok
+	}
+	join()
+
+	// Unsigned and forged close reports do not evict the node.
+	if err := service.ReportNodeClose(&NodeCloseArgs{Address: addr}, new(bool)); err != ErrNodeInfoUnsigned {
+		t.Fatalf("expected unsigned close to be rejected, got %v", err)
+	}
+	forged := &NodeCloseArgs{Address: addr}
+	forged.Sign("guess")
+	if err := service.ReportNodeClose(forged, new(bool)); err != ErrNodeInfoSignature {
+		t.Fatalf("expected forged close to be rejected, got %v", err)
+	}
+	// A signed node info can not be passed off as a close report.
+	info := &NodeInfo{Address: addr}
+	info.Sign("secret")
+	if err := service.ReportNodeClose(&NodeCloseArgs{Address: addr, Stamp: info.Stamp, Signature: info.Signature}, new(bool)); err != ErrNodeInfoSignature {
+		t.Fatalf("expected node info signature to be rejected for close, got %v", err)
+	}
+	if !joined() {
+		t.Fatal("rejected close reports should not evict the node")
+	}
+
+	report := &NodeCloseArgs{Address: addr}
+	report.Sign("secret")
+	if err := service.ReportNodeClose(report, new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	if joined() {
+		t.Fatal("signed close report should evict the node")
+	}
+
+	// Replaying the close after the node rejoined is rejected.
+	join()
+	if err := service.ReportNodeClose(report, new(bool)); err != ErrNodeInfoReplayed {
+		t.Fatalf("expected replayed close to be rejected, got %v", err)
+	}
+	if !joined() {
+		t.Fatal("replayed close report should not evict the node")
+	}
+}
@@ -1,6 +1,8 @@
 package Cluster
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/zllangct/rockgo/config"
@@ -24,13 +26,23 @@ type NodeComponent struct {
 	Addr            string
 	isOnline        bool
 	islocationMode  bool
-	rpcClient       sync.Map    //RPC客户端集合
+	rpcClient       sync.Map    //RPC客户端连接池集合[addr,*clientPool]
+	rpcClientWatch  sync.Once   //节点离开时关闭其连接池
 	rpcServer       *rpc.Server //本节点RPC Server
 	serverListener  *net.TCPListener
+	clientTLS       *tls.Config      //启用TLS时连接其他节点使用的配置
 	locationClients []*rpc.TcpClient //位置服务器集合
 	locationGetter  func()
 	lockers         sync.Map //[nodeid,locker]
-	clientGetting   map[string]int
+	poolSize        int      //到每个节点的连接数上限
+	masterLeader    string   //已知的master leader地址
+	discovery       Discovery
+
+	membershipLocker   sync.Mutex
+	members            map[string]*NodeInfo           //最近一次收到的成员列表
+	membershipHandlers []func(event *MembershipEvent) //成员变化回调
+
+	clientInterceptors []rpc.ClientInterceptor //新建客户端默认使用的拦截器
 }
 
 func (this *NodeComponent) GetRequire() map[*ecs.Object][]reflect.Type {
@@ -45,20 +57,45 @@ func (this *NodeComponent) Initialize() error {
 	logger.Info("NodeComponent init .....")
 	this.AppName = config.Config.ClusterConfig.AppName
 	this.islocationMode = config.Config.ClusterConfig.IsLocationMode
-	this.clientGetting = make(map[string]int)
+	this.poolSize = config.Config.ClusterConfig.RpcPoolSize
 	//开始本节点RPC服务
 	err := this.StartRpcServer()
 	if err != nil {
 		//地址占用，立即修改配置文件
 		return err
 	}
-	//初始化位置服务器搜索
-	this.InitLocationServerGetter()
-	this.locationGetter()
+	this.discovery, err = newDiscovery(this)
+	if err != nil {
+		return err
+	}
+	if _, ok := this.discovery.(*MasterDiscovery); ok {
+		//初始化位置服务器搜索
+		this.InitLocationServerGetter()
+		if this.locationGetter != nil {
+			this.locationGetter()
+		}
+	} else {
+		//不依赖master，直接上线
+		this.isOnline = true
+	}
 	logger.Info("NodeComponent initialized.")
 	return nil
 }
 
+//服务发现
+func (this *NodeComponent) Discovery() Discovery {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	return this.discovery
+}
+
+//替换服务发现，需在使用前设置
+func (this *NodeComponent) SetDiscovery(discovery Discovery) {
+	this.locker.Lock()
+	this.discovery = discovery
+	this.locker.Unlock()
+}
+
 func (this *NodeComponent) Locker() *sync.RWMutex {
 	return &this.locker
 }
@@ -117,20 +154,60 @@ func (this *NodeComponent) InitLocationServerGetter() {
 	this.locationGetter = func() {
 		go getter()
 	}
-	//非频繁更新
-	go func() {
-		for {
-			time.Sleep(time.Second * 30)
-			this.locationGetter()
+	//位置服务器加入或离开时更新
+	this.OnMembershipChange(func(event *MembershipEvent) {
+		if event.Type == MEMBER_EVENT_LOAD_CHANGE {
+			return
+		}
+		for _, role := range event.Node.Role {
+			if role == "location" {
+				this.locationGetter()
+				return
+			}
+		}
+	})
+}
+
+// OnMembershipChange registers handler to be called for every change of the
+// cluster membership. Nodes already in the cluster are reported as joins
+// first. Handlers are called one at a time and should not block.
+func (this *NodeComponent) OnMembershipChange(handler func(event *MembershipEvent)) {
+	this.membershipLocker.Lock()
+	this.membershipHandlers = append(this.membershipHandlers, handler)
+	start := this.members == nil
+	if start {
+		this.members = make(map[string]*NodeInfo)
+	} else {
+		for _, event := range diffMembership(nil, this.members) {
+			handler(event)
+		}
+	}
+	this.membershipLocker.Unlock()
+	//第一个回调，开始监听
+	if start {
+		this.Discovery().Watch(this.membershipChanged)
+	}
+}
+
+func (this *NodeComponent) membershipChanged(nodes map[string]*NodeInfo) {
+	this.membershipLocker.Lock()
+	defer this.membershipLocker.Unlock()
+	events := diffMembership(this.members, nodes)
+	this.members = nodes
+	for _, event := range events {
+		for _, handler := range this.membershipHandlers {
+			handler(event)
 		}
-	}()
+	}
 }
 
 func (this *NodeComponent) locationBroken() {
 	this.locker.Lock()
 	this.locationClients = nil
 	this.locker.Unlock()
-	this.locationGetter()
+	if this.locationGetter != nil {
+		this.locationGetter()
+	}
 }
 
 //RPC服务
@@ -144,89 +221,121 @@ func (this *NodeComponent) StartRpcServer() error {
 	if err != nil {
 		return err
 	}
+	var listener net.Listener = this.serverListener
+	if conf := config.Config.ClusterConfig; conf.RpcTls {
+		serverTLS, err := rpc.NewServerTLSConfig(conf.RpcTlsCert, conf.RpcTlsKey, conf.RpcTlsCA)
+		if err != nil {
+			this.serverListener.Close()
+			return err
+		}
+		this.clientTLS, err = rpc.NewClientTLSConfig(conf.RpcTlsCert, conf.RpcTlsKey, conf.RpcTlsCA, conf.RpcTlsServerName)
+		if err != nil {
+			this.serverListener.Close()
+			return err
+		}
+		listener = tls.NewListener(this.serverListener, serverTLS)
+	}
 	this.rpcServer = server
 	this.localIP = config.Config.ClusterConfig.LocalAddress
-	logger.Info(fmt.Sprintf("NodeComponent RPC server listening on: [ %s ]", addr.String()))
-	go server.Accept(this.serverListener)
+	logger.Info(fmt.Sprintf("NodeComponent RPC server listening on: [ %s ], tls: %v", addr.String(), this.clientTLS != nil))
+	go server.Accept(listener)
 	return nil
 }
 
+//负载：处理中的rpc调用
+func (this *NodeComponent) CollectLoad(info map[string]float32) {
+	if this.rpcServer != nil {
+		info[LOAD_FIELD_RPC_PENDING] = float32(this.rpcServer.Inflight())
+	}
+}
+
+//停机钩子：等待本节点正在执行的rpc调用完成
+func (this *NodeComponent) Shutdown(ctx context.Context) {
+	if err := this.rpcServer.WaitIdle(ctx); err != nil {
+		logger.Warn(fmt.Sprintf("rpc calls still in flight at shutdown: %d", this.rpcServer.Inflight()))
+	}
+}
+
 func (this *NodeComponent) Register(rcvr interface{}) error {
 	return this.rpcServer.Register(rcvr)
 }
 
+// UseServerInterceptor adds interceptors to the RPC server of this node.
+func (this *NodeComponent) UseServerInterceptor(interceptors ...rpc.ServerInterceptor) {
+	this.rpcServer.Use(interceptors...)
+}
+
+// UseClientInterceptor adds interceptors to every RPC client of this node,
+// both those already connected and those connected later.
+func (this *NodeComponent) UseClientInterceptor(interceptors ...rpc.ClientInterceptor) {
+	this.locker.Lock()
+	this.clientInterceptors = append(this.clientInterceptors, interceptors...)
+	this.locker.Unlock()
+	this.rpcClient.Range(func(key, value interface{}) bool {
+		value.(*clientPool).Range(func(client *rpc.TcpClient) {
+			client.Use(interceptors...)
+		})
+		return true
+	})
+}
+
 func (this *NodeComponent) clientCallback(event string, data ...interface{}) {
 	switch event {
 	case "close":
 		nodeAddr := data[0].(string)
-		this.rpcClient.Delete(nodeAddr)
 		logger.Info(fmt.Sprintf("  disconnect to remote node: [ %s ]", nodeAddr))
 	}
 }
 
-//获取节点客户端
+//获取节点客户端，从到该节点的连接池中选择负载最低的连接
 func (this *NodeComponent) GetNodeClient(addr string) (*rpc.TcpClient, error) {
-a:
-	if v, ok := this.rpcClient.Load(addr); ok {
-		client := v.(*rpc.TcpClient)
-		if !client.IsClosed() {
-			return client, nil
-		}
-	}
+	this.rpcClientWatch.Do(func() {
+		this.OnMembershipChange(this.dropNodeClient)
+	})
+	v, ok := this.rpcClient.Load(addr)
+	if !ok {
+		v, _ = this.rpcClient.LoadOrStore(addr, newClientPool(addr, this.poolSize, func(addr string) (*rpc.TcpClient, error) {
+			return this.ConnectToNode(addr, this.clientCallback)
+		}))
+	}
+	return v.(*clientPool).Get()
+}
 
-	this.locker.Lock()
-	count, ok := this.clientGetting[addr]
-	if ok {
-		if count > 100 {
-			this.locker.Unlock()
-			return nil, errors.New("init not complete")
-		} else {
-			this.clientGetting[addr] += 1
+//节点离开集群，关闭并移除到该节点的连接池；master的连接用于调用和订阅，不随成员变化关闭
+func (this *NodeComponent) dropNodeClient(event *MembershipEvent) {
+	if event.Type != MEMBER_EVENT_LEAVE {
+		return
+	}
+	for _, addr := range this.masterCandidates() {
+		if addr == event.Node.Address {
+			return
 		}
-		this.locker.Unlock()
-		time.Sleep(time.Millisecond * 100)
-		goto a
-	} else {
-		this.clientGetting[addr] = 0
-		this.locker.Unlock()
 	}
-
-	defer func() {
-		this.locker.Lock()
-		delete(this.clientGetting, addr)
-		this.locker.Unlock()
-	}()
-
-	client, err := this.ConnectToNode(addr, this.clientCallback)
-	if err != nil {
-		return nil, err
+	if v, ok := this.rpcClient.LoadAndDelete(event.Node.Address); ok {
+		v.(*clientPool).Close()
 	}
-	this.rpcClient.Store(addr, client)
-	return client, nil
 }
 
-//查询并选择一个节点
+//查询并选择一个节点，selectorType为[选择模式, key]，如GetNode("room", SELECTOR_TYPE_HASH, roomID)
 func (this *NodeComponent) GetNode(role string, selectorType ...SelectorType) (*NodeID, error) {
-	var nodeID *NodeID
-	var err error
-	//优先查询位置服务器
-	if this.islocationMode {
-		nodeID, err = this.GetNodeFromLocation(role, selectorType...)
-		if err == nil {
-			return nodeID, nil
-		}
-	}
-	//位置服务器不存在或不可用时在master上查询
-	nodeID, err = this.GetNodeFromMaster(role, selectorType...)
+	return this.GetNodeContext(context.Background(), role, selectorType...)
+}
+
+func (this *NodeComponent) GetNodeContext(ctx context.Context, role string, selectorType ...SelectorType) (*NodeID, error) {
+	reply, err := this.Discovery().Query(ctx, queryArgs(SELECTOR_TYPE_DEFAULT, role, selectorType...), false)
 	if err != nil {
 		return nil, err
 	}
-	return nodeID, nil
+	return this.nodeID(reply, role)
 }
 
 //查询获取客户端
 func (this *NodeComponent) GetNodeClientByRole(role string, selectorType ...SelectorType) (*rpc.TcpClient, error) {
-	nodeID, err := this.GetNode(role, selectorType...)
+	return this.GetNodeClientByRoleContext(context.Background(), role, selectorType...)
+}
+
+func (this *NodeComponent) GetNodeClientByRoleContext(ctx context.Context, role string, selectorType ...SelectorType) (*rpc.TcpClient, error) {
+	nodeID, err := this.GetNodeContext(ctx, role, selectorType...)
 	if err != nil {
 		return nil, err
 	}
@@ -239,161 +348,232 @@ func (this *NodeComponent) GetNodeClientByRole(role string, selectorType ...Sele
 
 //查询节点组
 func (this *NodeComponent) GetNodeGroup(role string) (*NodeIDGroup, error) {
-	var nodeIDGroup *NodeIDGroup
-	var err error
-	//优先查询位置服务器
-	if this.islocationMode {
-		nodeIDGroup, err = this.GetNodeGroupFromLocation(role)
-		if err == nil {
-			return nodeIDGroup, nil
-		}
-	}
+	return this.GetNodeGroupContext(context.Background(), role)
+}
 
-	//位置服务器不存在或不可用时在master上查询
-	nodeIDGroup, err = this.GetNodeGroupFromMaster(role)
+func (this *NodeComponent) GetNodeGroupContext(ctx context.Context, role string) (*NodeIDGroup, error) {
+	reply, err := this.Discovery().Query(ctx, queryArgs(SELECTOR_TYPE_GROUP, role), false)
 	if err != nil {
 		return nil, err
 	}
-
-	if nodeIDGroup == nil {
-		nodeIDGroup = NewNodeIDGrop()
-	}
-	return nodeIDGroup, nil
+	return this.nodeIDGroup(reply), nil
 }
 
-//从位置服务器查询并选择一个节点
-func (this *NodeComponent) GetNodeFromLocation(role string, selectorType ...SelectorType) (*NodeID, error) {
-	var client *rpc.TcpClient
-	var err error
-
-	this.locker.RLock()
-	if this.locationClients == nil {
-		this.locker.RUnlock()
-		return nil, errors.New("location server not found")
-	}
-	//随机一个节点
-	rnd := rand.Intn(len(this.locationClients))
-	client = this.locationClients[rnd]
-	this.locker.RUnlock()
-
-	var reply *[]*InquiryReply
+//查询参数：选择模式、AppName、角色、key
+func queryArgs(defaultSelector SelectorType, role string, selectorType ...SelectorType) []string {
 	args := []string{
-		SELECTOR_TYPE_DEFAULT, config.Config.ClusterConfig.AppName, role,
+		defaultSelector, config.Config.ClusterConfig.AppName, role,
 	}
-	if len(selectorType) > 0 {
+	if len(selectorType) > 0 && selectorType[0] != "" {
 		args[0] = selectorType[0]
 	}
-
-	err = client.Call("LocationService.NodeInquiry", args, &reply)
-	if err != nil {
-		this.locationBroken()
-		return nil, err
+	if len(selectorType) > 1 {
+		args = append(args, selectorType[1])
 	}
-	if len(*reply) > 0 {
+	return args
+}
+
+func (this *NodeComponent) nodeID(reply []*InquiryReply, role string) (*NodeID, error) {
+	if len(reply) > 0 {
 		g := &NodeID{
 			nodeComponent: this,
-			Addr:          (*reply)[0].Node,
+			Addr:          reply[0].Node,
 		}
 		return g, nil
 	}
-
 	return nil, errors.New("no node of this role:" + role)
 }
 
+func (this *NodeComponent) nodeIDGroup(reply []*InquiryReply) *NodeIDGroup {
+	if reply == nil {
+		reply = []*InquiryReply{}
+	}
+	return &NodeIDGroup{
+		nodeComponent: this,
+		nodes:         reply,
+	}
+}
+
+//从位置服务器查询并选择一个节点
+func (this *NodeComponent) GetNodeFromLocation(role string, selectorType ...SelectorType) (*NodeID, error) {
+	return this.GetNodeFromLocationContext(context.Background(), role, selectorType...)
+}
+
+func (this *NodeComponent) GetNodeFromLocationContext(ctx context.Context, role string, selectorType ...SelectorType) (*NodeID, error) {
+	reply, err := this.queryLocation(ctx, queryArgs(SELECTOR_TYPE_DEFAULT, role, selectorType...), false)
+	if err != nil {
+		return nil, err
+	}
+	return this.nodeID(reply, role)
+}
+
 //从位置服务器查询
 func (this *NodeComponent) GetNodeGroupFromLocation(role string) (*NodeIDGroup, error) {
-	var client *rpc.TcpClient
-	var err error
+	return this.GetNodeGroupFromLocationContext(context.Background(), role)
+}
 
+func (this *NodeComponent) GetNodeGroupFromLocationContext(ctx context.Context, role string) (*NodeIDGroup, error) {
+	reply, err := this.queryLocation(ctx, queryArgs(SELECTOR_TYPE_GROUP, role), false)
+	if err != nil {
+		return nil, err
+	}
+	return this.nodeIDGroup(reply), nil
+}
+
+func (this *NodeComponent) queryLocation(ctx context.Context, query []string, detail bool) ([]*InquiryReply, error) {
 	this.locker.RLock()
-	if this.locationClients == nil {
+	if len(this.locationClients) == 0 {
 		this.locker.RUnlock()
 		return nil, errors.New("location server not found")
 	}
 	//随机一个节点
 	rnd := rand.Intn(len(this.locationClients))
-	client = this.locationClients[rnd]
+	client := this.locationClients[rnd]
 	this.locker.RUnlock()
 
-	var reply *[]*InquiryReply
-	args := []string{
-		SELECTOR_TYPE_GROUP, config.Config.ClusterConfig.AppName, role,
+	method := "LocationService.NodeInquiry"
+	if detail {
+		method = "LocationService.NodeInquiryDetail"
 	}
-	err = client.Call("LocationService.NodeInquiry", args, &reply)
+	var reply []*InquiryReply
+	err := client.CallContext(ctx, method, query, &reply)
 	if err != nil {
 		this.locationBroken()
 		return nil, err
 	}
-	g := &NodeIDGroup{
-		nodeComponent: this,
-		nodes:         *reply,
+	return reply, nil
+}
+
+//当前master地址，多master时为已知的leader
+func (this *NodeComponent) MasterAddress() string {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	if this.masterLeader != "" {
+		return this.masterLeader
 	}
-	return g, nil
+	return config.Config.ClusterConfig.MasterAddress
+}
+
+//记录master leader地址
+func (this *NodeComponent) SetMasterLeader(addr string) {
+	this.locker.Lock()
+	this.masterLeader = addr
+	this.locker.Unlock()
+}
+
+//按优先级排列的master地址：已知leader、配置的master、其他master
+func (this *NodeComponent) masterCandidates() []string {
+	candidates := []string{this.MasterAddress(), config.Config.ClusterConfig.MasterAddress}
+	candidates = append(candidates, config.Config.ClusterConfig.MasterPeers...)
+	res := make([]string, 0, len(candidates))
+	seen := make(map[string]bool)
+	for _, addr := range candidates {
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		res = append(res, addr)
+	}
+	return res
+}
+
+// CallMaster calls a MasterService method on the master leader. When the
+// called master is not the leader, the call follows the leader it suggests;
+// when a master is unreachable, the next known master is tried.
+func (this *NodeComponent) CallMaster(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	var err error
+	tried := make(map[string]bool)
+	candidates := this.masterCandidates()
+	for len(candidates) > 0 {
+		addr := candidates[0]
+		candidates = candidates[1:]
+		if tried[addr] {
+			continue
+		}
+		tried[addr] = true
+		var client *rpc.TcpClient
+		client, err = this.GetNodeClient(addr)
+		if err != nil {
+			continue
+		}
+		err = client.CallContext(ctx, serviceMethod, args, reply)
+		if err == nil {
+			if addr != this.MasterAddress() {
+				this.SetMasterLeader(addr)
+			}
+			return nil
+		}
+		if leader, ok := LeaderFromError(err); ok {
+			if leader != "" {
+				this.SetMasterLeader(leader)
+				candidates = append([]string{leader}, candidates...)
+			}
+			continue
+		}
+		if _, ok := err.(rpc.ServerError); ok || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
 }
 
 //从master查询并选择一个节点
 func (this *NodeComponent) GetNodeFromMaster(role string, selectorType ...SelectorType) (*NodeID, error) {
-	if !this.IsOnline() {
-		return nil, ErrNodeOffline
-	}
-	client, err := this.GetNodeClient(config.Config.ClusterConfig.MasterAddress)
+	return this.GetNodeFromMasterContext(context.Background(), role, selectorType...)
+}
+
+func (this *NodeComponent) GetNodeFromMasterContext(ctx context.Context, role string, selectorType ...SelectorType) (*NodeID, error) {
+	reply, err := this.queryMaster(ctx, queryArgs(SELECTOR_TYPE_DEFAULT, role, selectorType...), false)
 	if err != nil {
 		return nil, err
 	}
-	var reply *[]*InquiryReply
-	args := []string{
-		SELECTOR_TYPE_DEFAULT, config.Config.ClusterConfig.AppName, role,
-	}
-	if len(selectorType) > 0 {
-		args[0] = selectorType[0]
-	}
-	err = client.Call("MasterService.NodeInquiry", args, &reply)
+	return this.nodeID(reply, role)
+}
+
+//从master查询
+func (this *NodeComponent) GetNodeGroupFromMaster(role string) (*NodeIDGroup, error) {
+	return this.GetNodeGroupFromMasterContext(context.Background(), role)
+}
+
+func (this *NodeComponent) GetNodeGroupFromMasterContext(ctx context.Context, role string) (*NodeIDGroup, error) {
+	reply, err := this.queryMaster(ctx, queryArgs(SELECTOR_TYPE_GROUP, role), false)
 	if err != nil {
 		return nil, err
 	}
-	if len(*reply) > 0 {
-		g := &NodeID{
-			nodeComponent: this,
-			Addr:          (*reply)[0].Node,
-		}
-		return g, nil
-	}
-
-	return nil, errors.New("no node of this role:" + role)
+	return this.nodeIDGroup(reply), nil
 }
 
-//从master查询
-func (this *NodeComponent) GetNodeGroupFromMaster(role string) (*NodeIDGroup, error) {
+func (this *NodeComponent) queryMaster(ctx context.Context, query []string, detail bool) ([]*InquiryReply, error) {
 	if !this.IsOnline() {
 		return nil, ErrNodeOffline
 	}
-	client, err := this.GetNodeClient(config.Config.ClusterConfig.MasterAddress)
-	if err != nil {
-		return nil, err
-	}
-	var reply *[]*InquiryReply
-	args := []string{
-		SELECTOR_TYPE_GROUP, config.Config.ClusterConfig.AppName, role,
+	method := "MasterService.NodeInquiry"
+	if detail {
+		method = "MasterService.NodeInquiryDetail"
 	}
-	err = client.Call("MasterService.NodeInquiry", args, &reply)
+	var reply []*InquiryReply
+	err := this.CallMaster(ctx, method, query, &reply)
 	if err != nil {
 		return nil, err
 	}
-	g := &NodeIDGroup{
-		nodeComponent: this,
-		nodes:         *reply,
-	}
-	return g, nil
+	return reply, nil
 }
 
-//连接到某个节点
+// 连接到某个节点
 func (this *NodeComponent) ConnectToNode(addr string, callback func(event string, data ...interface{})) (*rpc.TcpClient, error) {
-	client, err := rpc.NewTcpClient("tcp", addr, callback)
+	var client *rpc.TcpClient
+	var err error
+	if this.clientTLS != nil {
+		client, err = rpc.NewTlsClient("tcp", addr, this.clientTLS, rpc.DefaultCodec, callback)
+	} else {
+		client, err = rpc.NewTcpClient("tcp", addr, callback)
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	this.rpcClient.Store(addr, client)
+	this.locker.RLock()
+	client.Use(this.clientInterceptors...)
+	this.locker.RUnlock()
 
 	logger.Info(fmt.Sprintf("  connect to node: [ %s ] success", addr))
 	return client, nil
@@ -2,34 +2,34 @@ package Cluster
 
 import (
 	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sort"
 	"sync"
 )
 
 const (
-	SELECTOR_TYPE_GROUP    SelectorType = "Group"
-	SELECTOR_TYPE_DEFAULT  SelectorType = "Default"
-	SELECTOR_TYPE_MIN_LOAD SelectorType = "MinLoad"
-	SELECTOR_TYPE_CUSTOM   SelectorType = "Custom"
+	SELECTOR_TYPE_GROUP       SelectorType = "Group"
+	SELECTOR_TYPE_DEFAULT     SelectorType = "Default"
+	SELECTOR_TYPE_MIN_LOAD    SelectorType = "MinLoad"
+	SELECTOR_TYPE_CUSTOM      SelectorType = "Custom"
+	SELECTOR_TYPE_ROUND_ROBIN SelectorType = "RoundRobin"
+	SELECTOR_TYPE_WEIGHTED    SelectorType = "Weighted"
+	SELECTOR_TYPE_HASH        SelectorType = "Hash"
 )
 
 type SelectorType = string
 
 type SourceGroup []*InquiryReply
 
-//最小负载：cpu * 80% + mem * 20%
+//最小负载：按配置的权重对上报字段加权求和，默认cpu * 80% + mem * 20%
 func (this SourceGroup) SelectMinLoad() int {
-	var min float32 = 1
+	weights := loadWeights()
+	var min float32
 	var index int = -1
 	for i, info := range this {
-		var cpu, mem float32 = 1, 1
-		if v, ok := info.Info["cpu"]; ok {
-			cpu = v
-		}
-		if v, ok := info.Info["mem"]; ok {
-			mem = v
-		}
-		sum := cpu*0.8 + mem*0.2
-		if sum <= min {
+		sum := loadScore(info.Info, weights)
+		if index == -1 || sum <= min {
 			min = sum
 			index = i
 		}
@@ -37,57 +37,209 @@ func (this SourceGroup) SelectMinLoad() int {
 	return index
 }
 
+/*
+	节点选择策略
+	按名字注册，查询时通过名字指定，master和位置服务使用相同的策略，因此可以跨rpc使用。
+	候选节点按地址排序后传入，key为调用方提供的键，如房间id，未提供时为空。
+*/
+
+// NodeSelector picks one of the candidate nodes of a role and returns its
+// index, or -1 if none is suitable.
+type NodeSelector interface {
+	Select(nodes SourceGroup, role string, key string) int
+}
+
+// NodeSelectorFunc adapts a function to a NodeSelector.
+type NodeSelectorFunc func(nodes SourceGroup, role string, key string) int
+
+func (this NodeSelectorFunc) Select(nodes SourceGroup, role string, key string) int {
+	return this(nodes, role, key)
+}
+
+var ErrUnknownSelector = errors.New("unknown selector")
+
+var selectors = struct {
+	sync.RWMutex
+	m map[SelectorType]NodeSelector
+}{m: make(map[SelectorType]NodeSelector)}
+
+//注册节点选择策略，同名覆盖。需要在master和位置服务节点上同样注册
+func RegisterSelector(name SelectorType, selector NodeSelector) {
+	selectors.Lock()
+	selectors.m[name] = selector
+	selectors.Unlock()
+}
+
+func GetSelector(name SelectorType) (NodeSelector, bool) {
+	selectors.RLock()
+	defer selectors.RUnlock()
+	selector, ok := selectors.m[name]
+	return selector, ok
+}
+
+func init() {
+	minLoad := NodeSelectorFunc(func(nodes SourceGroup, role string, key string) int {
+		return nodes.SelectMinLoad()
+	})
+	RegisterSelector(SELECTOR_TYPE_DEFAULT, minLoad)
+	RegisterSelector(SELECTOR_TYPE_MIN_LOAD, minLoad)
+	RegisterSelector(SELECTOR_TYPE_ROUND_ROBIN, NewRoundRobinSelector())
+	RegisterSelector(SELECTOR_TYPE_WEIGHTED, NewWeightedSelector("weight"))
+	RegisterSelector(SELECTOR_TYPE_HASH, NodeSelectorFunc(SelectByHash))
+}
+
+//轮询，每个角色单独计数
+type RoundRobinSelector struct {
+	locker sync.Mutex
+	next   map[string]uint64
+}
+
+func NewRoundRobinSelector() *RoundRobinSelector {
+	return &RoundRobinSelector{next: make(map[string]uint64)}
+}
+
+func (this *RoundRobinSelector) Select(nodes SourceGroup, role string, key string) int {
+	if len(nodes) == 0 {
+		return -1
+	}
+	this.locker.Lock()
+	n := this.next[role]
+	this.next[role] = n + 1
+	this.locker.Unlock()
+	return int(n % uint64(len(nodes)))
+}
+
+//按上报信息中的字段加权随机，未上报该字段的节点权重为1，权重不大于0的节点不会被选中
+type WeightedSelector struct {
+	Field string
+}
+
+func NewWeightedSelector(field string) *WeightedSelector {
+	return &WeightedSelector{Field: field}
+}
+
+func (this *WeightedSelector) Select(nodes SourceGroup, role string, key string) int {
+	weights := make([]float64, len(nodes))
+	var total float64
+	for i, node := range nodes {
+		weight := float64(1)
+		if v, ok := node.Info[this.Field]; ok {
+			weight = float64(v)
+		}
+		if weight > 0 {
+			weights[i] = weight
+			total += weight
+		}
+	}
+	if total <= 0 {
+		return -1
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return i
+		}
+		r -= weight
+	}
+	//浮点误差，取最后一个有权重的节点
+	for i := len(weights) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+//一致性哈希(最高随机权重)：相同的key总是落在同一节点，节点增减时只有落在该节点上的key会迁移
+func SelectByHash(nodes SourceGroup, role string, key string) int {
+	index := -1
+	var max uint64
+	for i, node := range nodes {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(node.Node))
+		if score := mix64(h.Sum64()); index == -1 || score > max {
+			index, max = i, score
+		}
+	}
+	return index
+}
+
+//打散fnv的低位相关性
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
 type Selector map[string]*NodeInfo
 
 var ErrNoAvailableNode = errors.New("query string wrong")
 
-// 0 选择模式 1 AppName 2 role
+// 0 选择模式 1 AppName 2 role 3 key(可选，一致性哈希等策略使用)
 func (this Selector) DoQuery(query []string, detail bool, locker *sync.RWMutex, selector ...func(SourceGroup) int) ([]*InquiryReply, error) {
 	length := len(query)
-	if length != 3 || query[0] == "" {
+	if (length != 3 && length != 4) || query[0] == "" {
 		return nil, ErrNoAvailableNode
 	}
+	key := ""
+	if length == 4 {
+		key = query[3]
+	}
 
 	err := errors.New("no available node ")
 	var reply = make([]*InquiryReply, 0)
 	locker.RLock()
 	for nodeName, nodeInfo := range this {
+		//排空中的节点不再被选择
+		if nodeInfo.Draining {
+			continue
+		}
 		if nodeInfo.AppName == query[1] {
 			for _, role := range nodeInfo.Role {
 				if role == query[2] {
-					if detail {
-						reply = append(reply, &InquiryReply{Node: nodeName, Info: nodeInfo.Info})
-					} else {
-						reply = append(reply, &InquiryReply{Node: nodeName})
-					}
+					reply = append(reply, &InquiryReply{Node: nodeName, Info: nodeInfo.Info})
 					err = nil
 					break
 				}
 			}
-			if err == nil && query[0] != SELECTOR_TYPE_GROUP {
-				break
-			}
 		}
 	}
 	locker.RUnlock()
+	//按地址排序，使轮询和哈希结果稳定
+	sort.Slice(reply, func(i, j int) bool {
+		return reply[i].Node < reply[j].Node
+	})
 
+	var index = -1
 	switch query[0] {
-	case SELECTOR_TYPE_DEFAULT, SELECTOR_TYPE_MIN_LOAD:
-		var index = -1
-		index = SourceGroup(reply).SelectMinLoad()
-		if index != -1 {
-			reply = []*InquiryReply{reply[index]}
-		}
 	case SELECTOR_TYPE_GROUP:
 	case SELECTOR_TYPE_CUSTOM:
-		var index = -1
 		if len(selector) == 0 {
-			err = errors.New("custom selector is empty")
+			return nil, errors.New("custom selector is empty")
 		}
 		index = selector[0](SourceGroup(reply))
-		reply = []*InquiryReply{reply[index]}
 	default:
-
+		s, ok := GetSelector(query[0])
+		if !ok {
+			return nil, ErrUnknownSelector
+		}
+		index = s.Select(SourceGroup(reply), query[2], key)
+	}
+	if query[0] != SELECTOR_TYPE_GROUP && len(reply) > 0 {
+		if index < 0 || index >= len(reply) {
+			return []*InquiryReply{}, errors.New("no available node ")
+		}
+		reply = []*InquiryReply{reply[index]}
+	}
+	if !detail {
+		for i, node := range reply {
+			reply[i] = &InquiryReply{Node: node.Node}
+		}
 	}
 	return reply, err
 }
@@ -0,0 +1,96 @@
+package Cluster
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func testSelectorNodes(n int) Selector {
+	nodes := make(Selector)
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:%d", 7000+i)
+		nodes[addr] = &NodeInfo{Address: addr, AppName: "app", Role: []string{"room"}, Info: map[string]float32{}}
+	}
+	return nodes
+}
+
+func selectNode(t *testing.T, nodes Selector, query ...string) string {
+	reply, err := nodes.DoQuery(query, false, &sync.RWMutex{})
+	if err != nil || len(reply) != 1 {
+		t.Fatalf("query %v: got %v, err %v", query, reply, err)
+	}
+	return reply[0].Node
+}
+
+func TestSelectorRoundRobin(t *testing.T) {
+	nodes := testSelectorNodes(3)
+	seen := make(map[string]int)
+	for i := 0; i < 9; i++ {
+		seen[selectNode(t, nodes, SELECTOR_TYPE_ROUND_ROBIN, "app", "room")]++
+	}
+	if len(seen) != 3 {
+		t.Fatalf("got %v, want every node selected", seen)
+	}
+	for addr, n := range seen {
+		if n != 3 {
+			t.Errorf("%s selected %d times, want 3", addr, n)
+		}
+	}
+}
+
+func TestSelectorWeighted(t *testing.T) {
+	nodes := testSelectorNodes(3)
+	nodes["127.0.0.1:7000"].Info["weight"] = 0
+	nodes["127.0.0.1:7001"].Info["weight"] = 3
+	seen := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		seen[selectNode(t, nodes, SELECTOR_TYPE_WEIGHTED, "app", "room")]++
+	}
+	if seen["127.0.0.1:7000"] != 0 {
+		t.Errorf("node with zero weight selected %d times", seen["127.0.0.1:7000"])
+	}
+	if seen["127.0.0.1:7001"] <= seen["127.0.0.1:7002"] {
+		t.Errorf("got %v, want the heavier node selected more often", seen)
+	}
+}
+
+func TestSelectorHash(t *testing.T) {
+	nodes := testSelectorNodes(4)
+	before := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("room-%d", i)
+		before[key] = selectNode(t, nodes, SELECTOR_TYPE_HASH, "app", "room", key)
+		if again := selectNode(t, nodes, SELECTOR_TYPE_HASH, "app", "room", key); again != before[key] {
+			t.Fatalf("key %s moved from %s to %s", key, before[key], again)
+		}
+	}
+
+	// Only keys of the removed node move.
+	delete(nodes, "127.0.0.1:7001")
+	for key, addr := range before {
+		now := selectNode(t, nodes, SELECTOR_TYPE_HASH, "app", "room", key)
+		if addr != "127.0.0.1:7001" && now != addr {
+			t.Errorf("key %s moved from %s to %s", key, addr, now)
+		}
+	}
+}
+
+func TestSelectorRegister(t *testing.T) {
+	nodes := testSelectorNodes(3)
+	if _, err := nodes.DoQuery([]string{"Last", "app", "room"}, false, &sync.RWMutex{}); err != ErrUnknownSelector {
+		t.Errorf("got %v, want ErrUnknownSelector", err)
+	}
+	RegisterSelector("Last", NodeSelectorFunc(func(nodes SourceGroup, role string, key string) int {
+		return len(nodes) - 1
+	}))
+	if addr := selectNode(t, nodes, "Last", "app", "room"); addr != "127.0.0.1:7002" {
+		t.Errorf("got %s", addr)
+	}
+
+	nodes["127.0.0.1:7001"].Info["cpu"] = 0.1
+	nodes["127.0.0.1:7001"].Info["mem"] = 0.1
+	if addr := selectNode(t, nodes, SELECTOR_TYPE_MIN_LOAD, "app", "room"); addr != "127.0.0.1:7001" {
+		t.Errorf("min load: got %s", addr)
+	}
+}
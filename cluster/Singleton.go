@@ -0,0 +1,207 @@
+package Cluster
+
+import (
+	"context"
+	"fmt"
+	"github.com/zllangct/rockgo/config"
+	"github.com/zllangct/rockgo/logger"
+	"time"
+)
+
+/*
+	集群单例租约
+	每个单例在集群中同一时间只由一个节点持有租约，持有者定期续约，停止续约超过租约时长后租约失效。
+	租约空缺时分配给最早加入、未在排空中、且近期请求过该单例的节点。
+	排空中的持有者不再续约，主动释放或租约到期后由下一个节点接手。节点离开时不立即回收其租约，
+	该节点可能只是与master失联，仍需等待租约到期，持有者在本地租约到期时停止单例。
+	租约随master状态复制，新分配的租约在多数master确认后才返回给请求方，新leader一定知道该租约，不会重复分配。
+	续约只改变到期时间，不触发复制；新leader上任时所有租约延长一个租约时长，覆盖未复制的续约。
+	master返回收到请求时租约的剩余时长，请求方从发出请求时起算本地到期时间，本地总是先于master到期。
+*/
+
+type SingletonLease struct {
+	Name      string
+	Node      string //持有租约的节点地址，空缺时为空
+	Expire    int64  //到期时间，纳秒，master时钟
+	TTL       int64  //租约时长，纳秒
+	Remaining int64  //master收到请求时的剩余时长，纳秒
+	Renewed   bool   //本次请求为请求方续约或分配了租约，持有者未被续约时为false
+}
+
+type SingletonArgs struct {
+	Name string
+	Node string
+	TTL  int64 //租约时长，纳秒
+}
+
+//请求单例租约：持有者续约，租约空缺时分配给最早加入的候选节点，返回当前租约
+//租约变化在多数master确认后才返回，未确认时返回错误
+func (this *MasterComponent) AcquireSingleton(name string, node string, ttl time.Duration) (*SingletonLease, error) {
+	lease, changed := this.acquireSingleton(name, node, ttl)
+	if changed {
+		if err := this.commit(); err != nil {
+			return nil, err
+		}
+	}
+	return lease, nil
+}
+
+//返回当前租约及租约是否变化，调用方不持有锁
+func (this *MasterComponent) acquireSingleton(name string, node string, ttl time.Duration) (*SingletonLease, bool) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.singletons == nil {
+		this.singletons = make(map[string]*SingletonLease)
+	}
+	if this.singletonCandidates == nil {
+		this.singletonCandidates = make(map[string]map[string]int64)
+	}
+	now := time.Now().UnixNano()
+	candidates, ok := this.singletonCandidates[name]
+	if !ok {
+		candidates = make(map[string]int64)
+		this.singletonCandidates[name] = candidates
+	}
+	candidates[node] = now
+
+	lease, ok := this.singletons[name]
+	if ok && now < lease.Expire {
+		//排空中或已离开的持有者不再续约
+		renewed := lease.Node == node && this.singletonEligible(node)
+		if renewed {
+			lease.Expire = now + int64(ttl)
+			lease.TTL = int64(ttl)
+		}
+		return leaseReply(lease, now, renewed), false
+	}
+
+	owner := this.oldestSingletonCandidate(name, now, int64(ttl))
+	if owner == "" {
+		if ok {
+			delete(this.singletons, name)
+			this.changed()
+		}
+		return &SingletonLease{Name: name}, ok
+	}
+	lease = &SingletonLease{Name: name, Node: owner, Expire: now + int64(ttl), TTL: int64(ttl)}
+	this.singletons[name] = lease
+	this.changed()
+	logger.Info(fmt.Sprintf("singleton [ %s ] assigned to node [ %s ]", name, owner))
+	return leaseReply(lease, now, owner == node), true
+}
+
+func leaseReply(lease *SingletonLease, now int64, renewed bool) *SingletonLease {
+	copied := *lease
+	copied.Remaining = lease.Expire - now
+	copied.Renewed = renewed
+	return &copied
+}
+
+//释放单例租约，只有持有者可以释放；该节点同时不再作为候选
+func (this *MasterComponent) ReleaseSingleton(name string, node string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	delete(this.singletonCandidates[name], node)
+	if lease, ok := this.singletons[name]; ok && lease.Node == node {
+		delete(this.singletons, name)
+		this.changed()
+		logger.Info(fmt.Sprintf("singleton [ %s ] released by node [ %s ]", name, node))
+	}
+}
+
+//查询单例租约
+func (this *MasterComponent) ResolveSingleton(name string) (*SingletonLease, bool) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	lease, ok := this.singletons[name]
+	if !ok {
+		return nil, false
+	}
+	copied := *lease
+	return &copied, true
+}
+
+//节点在线且未在排空，调用方持有锁
+func (this *MasterComponent) singletonEligible(node string) bool {
+	info, ok := this.Nodes[node]
+	return ok && !info.Draining
+}
+
+//最早加入的候选节点，加入时间相同时按地址，调用方持有锁
+func (this *MasterComponent) oldestSingletonCandidate(name string, now int64, ttl int64) string {
+	owner := ""
+	var joined int64
+	for node, seen := range this.singletonCandidates[name] {
+		if now-seen > ttl || !this.singletonEligible(node) {
+			continue
+		}
+		t := this.Nodes[node].Joined
+		if owner == "" || t < joined || (t == joined && node < owner) {
+			owner, joined = node, t
+		}
+	}
+	return owner
+}
+
+//清除节点的候选登记，其持有的租约等待到期，调用方持有锁
+func (this *MasterComponent) removeNodeSingletons(addr string) {
+	for _, candidates := range this.singletonCandidates {
+		delete(candidates, addr)
+	}
+}
+
+//新leader上任时延长所有租约，避免复制延迟导致租约提前失效
+func (this *MasterComponent) extendSingletons() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	now := time.Now().UnixNano()
+	for _, lease := range this.singletons {
+		if expire := now + lease.TTL; expire > lease.Expire {
+			lease.Expire = expire
+		}
+	}
+	this.singletonCandidates = make(map[string]map[string]int64)
+}
+
+func (this *MasterService) AcquireSingleton(args *SingletonArgs, reply *SingletonLease) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	lease, err := this.master.AcquireSingleton(args.Name, args.Node, time.Duration(args.TTL))
+	if err != nil {
+		return err
+	}
+	*reply = *lease
+	return nil
+}
+
+func (this *MasterService) ReleaseSingleton(args *SingletonArgs, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	this.master.ReleaseSingleton(args.Name, args.Node)
+	if err := this.master.commit(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+//请求或续约单例租约，返回当前租约，租约节点为本节点时持有租约
+func (this *NodeComponent) AcquireSingleton(ctx context.Context, name string, ttl time.Duration) (*SingletonLease, error) {
+	reply := &SingletonLease{}
+	err := this.CallMaster(ctx, "MasterService.AcquireSingleton", &SingletonArgs{
+		Name: name,
+		Node: config.Config.ClusterConfig.LocalAddress,
+		TTL:  int64(ttl),
+	}, reply)
+	return reply, err
+}
+
+func (this *NodeComponent) ReleaseSingleton(ctx context.Context, name string) error {
+	var reply bool
+	return this.CallMaster(ctx, "MasterService.ReleaseSingleton", &SingletonArgs{
+		Name: name,
+		Node: config.Config.ClusterConfig.LocalAddress,
+	}, &reply)
+}
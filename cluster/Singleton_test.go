@@ -0,0 +1,138 @@
+package Cluster
+
+import (
+	"github.com/zllangct/rockgo/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+func acquire(t *testing.T, master *MasterComponent, name string, node string, ttl time.Duration) *SingletonLease {
+	t.Helper()
+	lease, err := master.AcquireSingleton(name, node, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lease
+}
+
+func TestSingletonLease(t *testing.T) {
+	master := &MasterComponent{
+		locker:          &sync.RWMutex{},
+		Nodes:           make(map[string]*NodeInfo),
+		NodeLog:         &NodeLogs{BufferSize: 20},
+		timeoutChecking: make(map[string]int),
+	}
+	const (
+		old   = "127.0.0.1:6605"
+		young = "127.0.0.1:6606"
+		new   = "127.0.0.1:6607"
+		ttl   = 50 * time.Millisecond
+	)
+	master.Nodes[old] = &NodeInfo{Address: old, Joined: 1}
+	master.Nodes[young] = &NodeInfo{Address: young, Joined: 2}
+	master.Nodes[new] = &NodeInfo{Address: new, Joined: 3}
+
+	// The first candidate takes the vacant lease and keeps it while renewing.
+	if lease := acquire(t, master, "matchmaking", new, ttl); lease.Node != new || !lease.Renewed || lease.Remaining != int64(ttl) {
+		t.Fatalf("first candidate should get the lease, got %+v", lease)
+	}
+	if lease := acquire(t, master, "matchmaking", young, ttl); lease.Node != new || lease.Renewed {
+		t.Fatalf("other candidates should not renew the lease, got %+v", lease)
+	}
+	if lease := acquire(t, master, "matchmaking", new, ttl); !lease.Renewed || lease.Remaining <= 0 || lease.Remaining > int64(ttl) {
+		t.Fatalf("the holder should be renewed, got %+v", lease)
+	}
+	if lease := acquire(t, master, "matchmaking", old, ttl); lease.Node != new {
+		t.Fatalf("lease is held by %q, got %q", new, lease.Node)
+	}
+
+	// Once released, the lease goes to the oldest candidate.
+	master.ReleaseSingleton("matchmaking", new)
+	if lease := acquire(t, master, "matchmaking", young, ttl); lease.Node != old {
+		t.Fatalf("lease should go to the oldest node, got %q", lease.Node)
+	}
+
+	// A draining holder is not renewed and the lease moves once it expires.
+	master.Nodes[old].Draining = true
+	held, _ := master.ResolveSingleton("matchmaking")
+	time.Sleep(ttl / 5)
+	lease := acquire(t, master, "matchmaking", old, ttl)
+	if lease.Node != old || lease.Renewed || lease.Expire != held.Expire {
+		t.Fatalf("a draining holder should not be renewed, got %+v", lease)
+	}
+	if lease.Remaining <= 0 || lease.Remaining > int64(ttl-ttl/5) {
+		t.Fatalf("expected the remaining lease of the draining holder, got %v", time.Duration(lease.Remaining))
+	}
+	time.Sleep(ttl + 10*time.Millisecond)
+	if lease := acquire(t, master, "matchmaking", old, ttl); lease.Node != "" {
+		t.Fatalf("no fresh candidate is eligible, got %q", lease.Node)
+	}
+	if lease := acquire(t, master, "matchmaking", young, ttl); lease.Node != young {
+		t.Fatalf("lease should move to %q, got %q", young, lease.Node)
+	}
+
+	// The lease of a node that left is kept until it expires.
+	master.locker.Lock()
+	master.NodeClose(young)
+	master.locker.Unlock()
+	if lease, ok := master.ResolveSingleton("matchmaking"); !ok || lease.Node != young {
+		t.Fatalf("lease of a closed node should wait for expiry, got %v", lease)
+	}
+	time.Sleep(ttl + 10*time.Millisecond)
+	master.Nodes[old].Draining = false
+	if lease := acquire(t, master, "matchmaking", old, ttl); lease.Node != old {
+		t.Fatalf("lease should return to %q, got %q", old, lease.Node)
+	}
+}
+
+func TestSingletonRenewalNotReplicated(t *testing.T) {
+	master := newAuthMaster("")
+	master.election = newMasterElection("127.0.0.1:6600", []string{"127.0.0.1:6600"}, time.Second)
+	master.election.state = MASTER_STATE_LEADER
+	version := func() uint64 {
+		_, v := master.election.StateVersion()
+		return v
+	}
+	const node = "127.0.0.1:6605"
+	master.Nodes[node] = &NodeInfo{Address: node, Joined: 1}
+
+	acquire(t, master, "ranking", node, time.Second)
+	assigned := version()
+	if assigned == 0 {
+		t.Fatal("assigning a lease should change the replicated state")
+	}
+	for i := 0; i < 3; i++ {
+		if lease := acquire(t, master, "ranking", node, time.Second); !lease.Renewed {
+			t.Fatalf("expected the lease to be renewed, got %+v", lease)
+		}
+	}
+	if v := version(); v != assigned {
+		t.Fatalf("renewals should not change the state version, %d -> %d", assigned, v)
+	}
+	master.ReleaseSingleton("ranking", node)
+	if v := version(); v == assigned {
+		t.Fatal("releasing a lease should change the replicated state")
+	}
+}
+
+func TestSingletonAssignCommitted(t *testing.T) {
+	master := newAuthMaster("")
+	// The other master is unreachable, so a new assignment cannot reach a majority.
+	master.election = newMasterElection("127.0.0.1:6600", []string{"127.0.0.1:6600", "127.0.0.1:1"}, time.Millisecond*100)
+	master.election.getClient = func(addr string) (*rpc.TcpClient, error) {
+		return nil, ErrNodeOffline
+	}
+	master.election.state = MASTER_STATE_LEADER
+	const node = "127.0.0.1:6605"
+	master.Nodes[node] = &NodeInfo{Address: node, Joined: 1}
+
+	if lease, err := master.AcquireSingleton("ranking", node, time.Second); err != ErrNotCommitted {
+		t.Fatalf("an uncommitted assignment should not be returned, got %+v %v", lease, err)
+	}
+	// The lease is returned once a majority has it.
+	master.election.peers = nil
+	if lease := acquire(t, master, "ranking", node, time.Second); lease.Node != node || !lease.Renewed {
+		t.Fatalf("expected the committed lease, got %+v", lease)
+	}
+}
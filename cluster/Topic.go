@@ -0,0 +1,122 @@
+package Cluster
+
+import (
+	"context"
+	"github.com/zllangct/rockgo/config"
+	"sort"
+)
+
+/*
+	发布订阅主题
+	master记录每个主题有订阅者的节点，节点上第一个订阅者加入时登记，最后一个离开时注销。
+	发布时按登记的节点逐个发送一次，由节点分发给本地订阅者。节点离开时其登记被清除。
+*/
+
+type TopicRecord struct {
+	Topic string
+	Node  string //节点地址
+}
+
+//登记节点订阅了主题
+func (this *MasterComponent) SubscribeTopic(topic string, node string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.topics == nil {
+		this.topics = make(map[string]map[string]bool)
+	}
+	nodes, ok := this.topics[topic]
+	if !ok {
+		nodes = make(map[string]bool)
+		this.topics[topic] = nodes
+	}
+	if !nodes[node] {
+		nodes[node] = true
+		this.changed()
+	}
+}
+
+func (this *MasterComponent) UnsubscribeTopic(topic string, node string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	nodes, ok := this.topics[topic]
+	if !ok || !nodes[node] {
+		return
+	}
+	delete(nodes, node)
+	if len(nodes) == 0 {
+		delete(this.topics, topic)
+	}
+	this.changed()
+}
+
+//订阅了主题的节点，按地址排序
+func (this *MasterComponent) TopicNodes(topic string) []string {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	nodes := make([]string, 0, len(this.topics[topic]))
+	for node := range this.topics[topic] {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+//清除节点的订阅登记，调用方持有锁
+func (this *MasterComponent) removeNodeTopics(addr string) {
+	for topic, nodes := range this.topics {
+		delete(nodes, addr)
+		if len(nodes) == 0 {
+			delete(this.topics, topic)
+		}
+	}
+}
+
+func (this *MasterService) SubscribeTopic(args *TopicRecord, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	this.master.SubscribeTopic(args.Topic, args.Node)
+	if err := this.master.commit(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+func (this *MasterService) UnsubscribeTopic(args *TopicRecord, reply *bool) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	this.master.UnsubscribeTopic(args.Topic, args.Node)
+	if err := this.master.commit(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+func (this *MasterService) TopicNodes(topic string, reply *[]string) error {
+	if err := this.master.checkLeader(); err != nil {
+		return err
+	}
+	*reply = this.master.TopicNodes(topic)
+	return nil
+}
+
+//登记本节点订阅了主题
+func (this *NodeComponent) SubscribeTopic(ctx context.Context, topic string) error {
+	var reply bool
+	return this.CallMaster(ctx, "MasterService.SubscribeTopic", &TopicRecord{Topic: topic, Node: config.Config.ClusterConfig.LocalAddress}, &reply)
+}
+
+func (this *NodeComponent) UnsubscribeTopic(ctx context.Context, topic string) error {
+	var reply bool
+	return this.CallMaster(ctx, "MasterService.UnsubscribeTopic", &TopicRecord{Topic: topic, Node: config.Config.ClusterConfig.LocalAddress}, &reply)
+}
+
+//查询订阅了主题的节点
+func (this *NodeComponent) TopicNodes(ctx context.Context, topic string) ([]string, error) {
+	var reply []string
+	err := this.CallMaster(ctx, "MasterService.TopicNodes", topic, &reply)
+	return reply, err
+}
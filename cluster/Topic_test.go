@@ -0,0 +1,44 @@
+package Cluster
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTopicNodes(t *testing.T) {
+	master := &MasterComponent{
+		locker:          &sync.RWMutex{},
+		Nodes:           make(map[string]*NodeInfo),
+		NodeLog:         &NodeLogs{BufferSize: 20},
+		timeoutChecking: make(map[string]int),
+		topics:          make(map[string]map[string]bool),
+	}
+	master.SubscribeTopic("chat", "127.0.0.1:6606")
+	master.SubscribeTopic("chat", "127.0.0.1:6605")
+	master.SubscribeTopic("chat", "127.0.0.1:6605")
+	master.SubscribeTopic("world", "127.0.0.1:6605")
+
+	if nodes := master.TopicNodes("chat"); !reflect.DeepEqual(nodes, []string{"127.0.0.1:6605", "127.0.0.1:6606"}) {
+		t.Errorf("chat: got %v", nodes)
+	}
+	if nodes := master.TopicNodes("guild"); len(nodes) != 0 {
+		t.Errorf("guild: got %v", nodes)
+	}
+
+	master.UnsubscribeTopic("chat", "127.0.0.1:6606")
+	if nodes := master.TopicNodes("chat"); !reflect.DeepEqual(nodes, []string{"127.0.0.1:6605"}) {
+		t.Errorf("chat after unsubscribe: got %v", nodes)
+	}
+
+	// Subscriptions of a node that left are removed along with empty topics.
+	master.locker.Lock()
+	master.NodeClose("127.0.0.1:6605")
+	master.locker.Unlock()
+	if nodes := master.TopicNodes("chat"); len(nodes) != 0 {
+		t.Errorf("chat after node close: got %v", nodes)
+	}
+	if len(master.topics) != 0 {
+		t.Errorf("empty topics should be removed: %v", master.topics)
+	}
+}
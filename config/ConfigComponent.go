@@ -142,9 +142,31 @@ func (this *ConfigComponent) SetDefault() {
 		RpcTimeout:           9000,
 		RpcCallTimeout:       5000,
 		RpcHeartBeatInterval: 3000,
+		RpcCodec:             "gob",
+		RpcPoolSize:          4,
 		IsLocationMode:       true,
 		LocationSyncInterval: 500,
 
+		MasterElectionTimeout: 1500,
+
+		DrainTimeout: 10000,
+
+		LoadWeights: map[string]float32{"cpu": 0.8, "mem": 0.2},
+
+		ActorTombstoneTTL: 60000,
+		GrainIdleTimeout:  600000,
+
+		MailboxCapacity:     20,
+		MailboxOverflow:     "block",
+		MailboxBlockTimeout: 3000,
+
+		JournalDir:              "journal",
+		JournalSnapshotInterval: 100,
+
+		DeadLetterBufferSize: 256,
+
+		SingletonLeaseTTL: 10000,
+
 		NetConnTimeout:   9000,
 		NetListenAddress: "0.0.0.0:5555",
 
@@ -178,12 +200,49 @@ type ClusterConfig struct {
 	Role          []string //本节点拥有角色
 	NodeDefine    map[string]Node
 
-	ReportInterval       int  //子节点节点信息上报间隔，单位秒
-	RpcTimeout           int  //tcp链接超时，单位毫秒
-	RpcCallTimeout       int  //rpc调用超时
-	RpcHeartBeatInterval int  //tcp心跳间隔
-	IsLocationMode       bool //是否启用位置服务器
-	LocationSyncInterval int  //位置服务同步间隔，单位秒
+	ReportInterval       int    //子节点节点信息上报间隔，单位秒
+	RpcTimeout           int    //tcp链接超时，单位毫秒
+	RpcCallTimeout       int    //rpc调用超时
+	RpcHeartBeatInterval int    //tcp心跳间隔
+	RpcCodec             string //节点间rpc编解码器：gob、msgpack，服务端总是接受所有已注册的编解码器
+	RpcPoolSize          int    //到每个节点的rpc连接数上限，连接在负载增加时按需建立
+	RpcTls               bool   //节点间rpc是否启用TLS，集群内所有节点需一致
+	RpcTlsCert           string //本节点证书文件，同时用作服务端证书和双向认证时的客户端证书
+	RpcTlsKey            string //本节点私钥文件
+	RpcTlsCA             string //集群CA证书文件，配置后启用双向认证，为空时使用系统根证书校验服务端
+	RpcTlsServerName     string //校验服务端证书时使用的名称，为空时使用连接地址的主机名
+	ClusterSecret        string //集群密钥，配置后节点上报需签名，master与位置服务拒绝签名错误或重放的上报
+	IsLocationMode       bool   //是否启用位置服务器
+	LocationSyncInterval int    //与master断开后重新订阅成员变化的间隔，单位毫秒
+
+	//master高可用
+	MasterPeers           []string //所有master节点地址(含本节点)，多于一个时master之间选举leader，子节点自动跟随leader
+	MasterElectionTimeout int      //master选举超时，单位毫秒
+
+	//服务发现
+	Discovery     string //成员发现方式：master(默认，master与位置服务)、static(静态节点列表文件，无需master)
+	DiscoveryFile string //static方式的节点列表文件
+
+	DrainTimeout int //停机时等待进行中的工作(actor消息、rpc调用、客户端会话)完成的最长时间，单位毫秒
+
+	//负载上报字段：cpu、mem、rss、goroutine、actor、session、rpc_pending、mailbox
+	LoadWeights map[string]float32 //最小负载选择时各上报字段的权重，未配置的字段不参与计算
+
+	ActorTombstoneTTL int //actor迁移后旧地址继续转发消息的时长，单位毫秒
+	GrainIdleTimeout  int //虚拟actor空闲多久后失活并保存状态，单位毫秒
+
+	//actor邮箱，可在ActorComponent上单独设置
+	MailboxCapacity     int    //邮箱容量
+	MailboxOverflow     string //邮箱满时的策略：block、drop_newest、drop_oldest、reject
+	MailboxBlockTimeout int    //策略为block时的最长等待时间，单位毫秒，0为一直等待
+
+	//actor持久化
+	JournalDir              string //默认文件日志的目录
+	JournalSnapshotInterval int    //每多少个事件保存一次快照
+
+	DeadLetterBufferSize int //保留最近死信的数量
+
+	SingletonLeaseTTL int //集群单例租约时长，单位毫秒
 
 	//外网
 	NetConnTimeout   int    //外网链接超时
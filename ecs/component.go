@@ -1,6 +1,7 @@
 package ecs
 
 import (
+	"context"
 	"reflect"
 )
 
@@ -40,6 +41,11 @@ type IInit interface {
 	Initialize() error
 }
 
+//停机钩子，在销毁前执行，用于完成进行中的工作，ctx结束时应尽快返回
+type IShutdown interface {
+	Shutdown(ctx context.Context)
+}
+
 type Context struct {
 	Object    *Object
 	DeltaTime float32
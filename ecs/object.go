@@ -1,6 +1,7 @@
 package ecs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/zllangct/rockgo/3rd/iter"
@@ -241,6 +242,45 @@ func (o *Object) Destroy() (err error) {
 	return
 }
 
+// 停机，并发执行实体及其子对象上所有组件的停机钩子，等待全部返回或ctx结束
+func (o *Object) Shutdown(ctx context.Context) error {
+	hooks := make([]IShutdown, 0)
+	collect := func(components iter.Iter) {
+		for val, err := components.Next(); err == nil; val, err = components.Next() {
+			if hook, ok := val.(IShutdown); ok {
+				hooks = append(hooks, hook)
+			}
+		}
+	}
+	collect(o.AllComponents())
+	collect(o.GetComponentsInChildren(nil))
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook IShutdown) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error(fmt.Sprintf("shutdown panic: %v\n%s", r, debug.Stack()))
+				}
+				wg.Done()
+			}()
+			hook.Shutdown(ctx)
+		}(hook)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // 移除实体
 func (o *Object) RemoveObject(object *Object) (err error) {
 	if o == object {
@@ -24,7 +24,12 @@ func NewObjectFactory() *ObjectFactory {
 
 // RegisterGroup a ComponentProvider that can be used to serialize and deserialize objects
 func (factory *ObjectFactory) Register(provider ComponentProvider) {
-	factory.handlers[typeName(provider.Type())] = provider
+	typ := provider.Type()
+	if typ == nil {
+		//未添加到实体的组件，类型尚未初始化
+		typ = reflect.TypeOf(provider)
+	}
+	factory.handlers[typeName(typ)] = provider
 }
 
 // Serialize converts an object into an ObjectTemplate
@@ -77,13 +82,61 @@ func (factory *ObjectFactory) Deserialize(template *ObjectTemplate) (*Object, er
 	return obj, nil
 }
 
+// Instantiate converts an ObjectTemplate into an object attached to parent.
+// Components are added after the object is attached, so they are initialized
+// with the runtime of the parent.
+func (factory *ObjectFactory) Instantiate(template *ObjectTemplate, parent *Object) (*Object, error) {
+	components := make([]IComponent, 0, len(template.Components))
+	for i := 0; i < len(template.Components); i++ {
+		c, err := factory.deserializeComponent(&template.Components[i])
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+	obj := NewObject(template.Name)
+	if err := parent.AddObjectWithComponents(obj, components); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(template.Objects); i++ {
+		if _, err := factory.Instantiate(&template.Objects[i], obj); err != nil {
+			parent.RemoveObject(obj)
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// Restore loads the state in template into the existing components of object.
+// Each component template is matched, in order, with a component of the same
+// type. Child objects are not touched.
+func (factory *ObjectFactory) Restore(object *Object, template *ObjectTemplate) error {
+	used := make(map[IComponent]bool)
+	for i := 0; i < len(template.Components); i++ {
+		t := &template.Components[i]
+		for _, component := range object.components {
+			if used[component] || typeName(component.Type()) != t.Type {
+				continue
+			}
+			used[component] = true
+			if persist, ok := component.(IPersist); ok {
+				if err := persist.Deserialize(t.Data); err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
 // deserializeComponent turns a ecs template into a ecs
 func (factory *ObjectFactory) deserializeComponent(template *ComponentTemplate) (IComponent, error) {
 	for k, v := range factory.handlers {
 		if k == template.Type {
 			component := v.New()
-			if component.Type().Implements(reflect.TypeOf((*IPersist)(nil)).Elem()) {
-				err := component.(IPersist).Deserialize(template.Data)
+			if persist, ok := component.(IPersist); ok {
+				err := persist.Deserialize(template.Data)
 				if err != nil {
 					return nil, err
 				}
@@ -1,10 +1,12 @@
 package ecs_test
 
 import (
-	"github.com/zllangct/rockgo"
+	"context"
 	"github.com/zllangct/rockgo/3rd/assert"
 	"github.com/zllangct/rockgo/ecs"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCannotMakeRecursiveObjects(T *testing.T) {
@@ -156,3 +158,48 @@ func TestRemoveChildWithChildren(T *testing.T) {
 		T.Assert(err == nil)
 	})
 }
+
+type FakeShutdownComponent struct {
+	ecs.ComponentBase
+	wait time.Duration
+	done int32
+}
+
+func (fake *FakeShutdownComponent) Shutdown(ctx context.Context) {
+	select {
+	case <-time.After(fake.wait):
+		atomic.StoreInt32(&fake.done, 1)
+	case <-ctx.Done():
+	}
+}
+
+func TestShutdown(T *testing.T) {
+	o1 := ecs.NewObject("A")
+	o2 := ecs.NewObject("B")
+	o1.AddObject(o2)
+	c1 := &FakeShutdownComponent{wait: time.Millisecond * 10}
+	c2 := &FakeShutdownComponent{wait: time.Millisecond * 20}
+	o1.AddComponent(c1)
+	o2.AddComponent(c2)
+	o2.AddComponent(&FakeComponent{Id: "no hook"})
+
+	if err := o1.Shutdown(context.Background()); err != nil {
+		T.Fatal(err)
+	}
+	if atomic.LoadInt32(&c1.done) != 1 || atomic.LoadInt32(&c2.done) != 1 {
+		T.Error("expected every shutdown hook to finish")
+	}
+
+	// Hooks still running at the deadline are abandoned.
+	c3 := &FakeShutdownComponent{wait: time.Second * 10}
+	o2.AddComponent(c3)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if err := o1.Shutdown(ctx); err != context.DeadlineExceeded {
+		T.Errorf("expected deadline exceeded; got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		T.Error("shutdown did not stop at the deadline")
+	}
+}
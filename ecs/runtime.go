@@ -171,15 +171,9 @@ func (runtime *Runtime) Extract(object *Object) (*ObjectTemplate, error) {
 	return rtn, nil
 }
 
+//从模板创建实体并添加到parent下，组件在实体挂载后添加，因此能正常初始化
 func (runtime *Runtime) Insert(template *ObjectTemplate, parent *Object) (*Object, error) {
-	rtn, err := runtime.factory.Deserialize(template)
-	if err != nil {
-		return nil, err
-	}
-	if err := parent.AddObject(rtn); err != nil {
-		return nil, err
-	}
-	return rtn, nil
+	return runtime.factory.Instantiate(template, parent)
 }
 
 func (runtime *Runtime) Objects() iter.Iter {
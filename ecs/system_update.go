@@ -66,8 +66,9 @@ func (this *UpdateSystem) UpdateFrame() {
 		}
 		v := c.(IUpdate)
 		this.runtime.workers.Run(func() {
+			//组件update异常时同样结束，避免阻塞整帧
+			defer this.wg.Done()
 			v.Update(ctx)
-			this.wg.Done()
 		})
 	}
 	this.wg.Wait()
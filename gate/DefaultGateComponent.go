@@ -1,6 +1,7 @@
 package gate
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/zllangct/rockgo/cluster"
@@ -77,6 +78,38 @@ func (this *DefaultGateComponent) OnDropped(sess *network.Session) {
 	sess.PostProcessing()
 }
 
+//客户端会话数量
+func (this *DefaultGateComponent) SessionCount() int {
+	count := 0
+	this.clients.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+//负载：客户端会话数量
+func (this *DefaultGateComponent) CollectLoad(info map[string]float32) {
+	info[Cluster.LOAD_FIELD_SESSION] = float32(this.SessionCount())
+}
+
+//停机钩子：拒绝新连接，等待已连接的客户端断开
+func (this *DefaultGateComponent) Shutdown(ctx context.Context) {
+	this.server.Drain()
+	for {
+		count := this.SessionCount()
+		if count == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			logger.Warn(fmt.Sprintf("%d client sessions still connected at shutdown", count))
+			return
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
 func (this *DefaultGateComponent) Destroy() error {
 	this.server.Shutdown()
 	return nil
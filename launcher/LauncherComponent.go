@@ -1,6 +1,7 @@
 package launcher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/zllangct/rockgo/actor"
@@ -50,6 +51,13 @@ func (this *LauncherComponent) Initialize() error {
 	rpc.Timeout = time.Millisecond * time.Duration(config.Config.ClusterConfig.RpcTimeout)
 	rpc.HeartInterval = time.Millisecond * time.Duration(config.Config.ClusterConfig.RpcHeartBeatInterval)
 	rpc.DebugMode = config.Config.CommonConfig.Debug
+	if config.Config.ClusterConfig.RpcCodec != "" {
+		codec, err := rpc.GetCodecByName(config.Config.ClusterConfig.RpcCodec)
+		if err != nil {
+			return err
+		}
+		rpc.DefaultCodec = codec.Type()
+	}
 
 	//log设置
 	switch config.Config.CommonConfig.LogMode {
@@ -105,7 +113,8 @@ func (this *LauncherComponent) Serve() {
 	}
 
 	logger.Info("====== Start to close this server, do some cleaning now ...... ======")
-	//do something else
+	//排空：节点不再被选择，拒绝新连接，等待进行中的工作完成
+	this.Drain()
 	err = this.Root().Destroy()
 	if err != nil {
 		logger.Error(err)
@@ -114,6 +123,19 @@ func (this *LauncherComponent) Serve() {
 	logger.Info("====== Server is closed ======")
 }
 
+//执行所有组件的停机钩子，最多等待DrainTimeout
+func (this *LauncherComponent) Drain() {
+	timeout := time.Millisecond * time.Duration(config.Config.ClusterConfig.DrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := this.Root().Shutdown(ctx); err != nil {
+		logger.Warn(fmt.Sprintf("drain timeout after %s, in-flight work is cut off", timeout))
+		return
+	}
+	logger.Info(fmt.Sprintf("drained in %s", time.Since(start)))
+}
+
 //覆盖节点信息
 func (this *LauncherComponent) OverrideNodeDefine(nodeConfName string) {
 	if this.Config == nil {
@@ -55,6 +55,7 @@ type Server struct {
 	idleTime   time.Time
 	isClosed   bool
 	numInvoke  int32
+	draining   int32
 }
 
 //NewServer new Server and init with conf.
@@ -92,6 +93,16 @@ func (ts *Server) Shutdown() {
 	ts.isClosed = true
 }
 
+//Drain refuses new client connections, connected sessions keep working.
+func (ts *Server) Drain() {
+	atomic.StoreInt32(&ts.draining, 1)
+}
+
+//IsDraining shows whether the server refuses new client connections.
+func (ts *Server) IsDraining() bool {
+	return atomic.LoadInt32(&ts.draining) == 1
+}
+
 //GetConfig gets the tars server config.
 func (ts *Server) GetConfig() *ServerConf {
 	return ts.conf
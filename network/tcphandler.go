@@ -82,6 +82,11 @@ func (h *tcpHandler) Handle() error {
 			}
 			continue
 		}
+		//停机排空中，拒绝新连接
+		if h.ts.IsDraining() {
+			conn.Close()
+			continue
+		}
 		go func(conn *net.TCPConn) {
 			logger.Debug("TCP accept:", conn.RemoteAddr())
 			atomic.AddInt32(&h.acceptNum, 1)
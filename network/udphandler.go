@@ -131,6 +131,10 @@ func (h *udpHandler) Handle() error {
 			}
 
 			cid := mid[0]
+			//停机排空中，拒绝新连接
+			if cid == 0 && h.ts.IsDraining() {
+				return
+			}
 			if cid == 0 {
 				cid = atomic.AddUint32(&h.cid, 1)
 				new = true
@@ -68,6 +68,11 @@ func (h *websocketHandler) Listen() error {
 	router.Use(gin.Recovery())
 	router.GET("/", serveHome)
 	router.GET("/ws", func(ctx *gin.Context) {
+		//停机排空中，拒绝新连接
+		if h.ts.IsDraining() {
+			ctx.Status(http.StatusServiceUnavailable)
+			return
+		}
 		conn, err := upGrader.Upgrade(ctx.Writer, ctx.Request, nil)
 		if err != nil {
 			_, _ = ctx.Writer.WriteString("server internal error")
@@ -6,6 +6,7 @@ package rpc
 
 import (
 	"bufio"
+	"context"
 	"encoding/gob"
 	"errors"
 	"github.com/zllangct/rockgo/logger"
@@ -22,6 +23,12 @@ const (
 	RPC_CALL_TYPE_NONE = iota
 	RPC_CALL_TYPE_NORMAL
 	RPC_CALL_TYPE_WITHOUTREPLY
+	RPC_CALL_TYPE_SERVER_STREAM //打开服务端流
+	RPC_CALL_TYPE_CLIENT_STREAM //打开客户端流
+	RPC_CALL_TYPE_STREAM_DATA   //流数据
+	RPC_CALL_TYPE_STREAM_END    //发送方结束发送
+	RPC_CALL_TYPE_STREAM_CREDIT //流量控制，授予对方发送额度
+	RPC_CALL_TYPE_STREAM_CANCEL //接收方放弃流
 )
 
 var isDebug = true
@@ -47,6 +54,9 @@ type Call struct {
 	Error         error       // After completion, the error status.
 	Done          chan *Call  // Strobes when call is complete.
 	Type          int
+	seq           uint64    // sequence number in pending, set by send
+	deadline      time.Time // propagated to the server, zero means none
+	meta          Metadata  // sent in the request header
 }
 
 // TcpClient represents an RPC TcpClient.
@@ -54,7 +64,8 @@ type Call struct {
 // with a single TcpClient, and a TcpClient may be used by
 // multiple goroutines simultaneously.
 type TcpClient struct {
-	codec ClientCodec
+	codec     ClientCodec
+	codecType CodecType
 
 	network string
 	conn    net.Conn
@@ -65,10 +76,14 @@ type TcpClient struct {
 	mutex          sync.Mutex // protects following
 	seq            uint64
 	pending        map[uint64]*Call
+	streams        map[uint64]*stream
 	closeHeartbeat chan struct{}
 	closing        bool // user has called Close
 	shutdown       bool // server has told us to stop
 	Callback       func(event string, data ...interface{})
+
+	interceptorLock sync.RWMutex // protects interceptors
+	interceptors    []ClientInterceptor
 }
 
 // A ClientCodec implements writing of RPC requests and
@@ -94,6 +109,13 @@ func (client *TcpClient) IsClosed() bool {
 	return client.closing || client.shutdown
 }
 
+// Pending returns the number of calls and streams waiting for the server.
+func (client *TcpClient) Pending() int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return len(client.pending) + len(client.streams)
+}
+
 func (client *TcpClient) send(call *Call) {
 	client.reqMutex.Lock()
 	defer client.reqMutex.Unlock()
@@ -108,6 +130,7 @@ func (client *TcpClient) send(call *Call) {
 	}
 	seq := client.seq
 	client.seq++
+	call.seq = seq
 	client.pending[seq] = call
 	client.mutex.Unlock()
 
@@ -115,6 +138,8 @@ func (client *TcpClient) send(call *Call) {
 	client.request.Seq = seq
 	client.request.ServiceMethod = call.ServiceMethod
 	client.request.Type = call.Type
+	client.request.Timeout = call.timeout()
+	client.request.Meta = call.meta
 	err := client.codec.WriteRequest(&client.request, call.Args)
 	if err != nil {
 		client.mutex.Lock()
@@ -143,17 +168,16 @@ func (client *TcpClient) sendWithoutReply(call *Call) {
 	// Encode and send the request.
 	client.request.ServiceMethod = call.ServiceMethod
 	client.request.Type = call.Type
+	client.request.Timeout = call.timeout()
+	client.request.Meta = call.meta
 	err := client.codec.WriteRequest(&client.request, call.Args)
 	if err != nil {
-		if call != nil {
-			call.Error = err
-			call.done()
-		}
-	}
-	if call != nil {
-		call.Error = nil
+		call.Error = err
 		call.done()
+		return
 	}
+	call.Error = nil
+	call.done()
 }
 func (client *TcpClient) input() {
 	var err error
@@ -166,6 +190,11 @@ func (client *TcpClient) input() {
 		}
 		seq := response.Seq
 		client.mutex.Lock()
+		if s, ok := client.streams[seq]; ok {
+			client.mutex.Unlock()
+			err = client.streamResponse(s, &response)
+			continue
+		}
 		call := client.pending[seq]
 		delete(client.pending, seq)
 		client.mutex.Unlock()
@@ -215,16 +244,47 @@ func (client *TcpClient) input() {
 		call.Error = err
 		call.done()
 	}
+	streams := client.streams
+	client.streams = make(map[uint64]*stream)
 	if client.Callback != nil {
 		client.Callback("close", client.conn.RemoteAddr().String())
 	}
 	client.mutex.Unlock()
 	client.reqMutex.Unlock()
+	for _, s := range streams {
+		s.finish(err)
+	}
 	if debugLog && err != io.EOF && !closing {
 		logger.Error("rpc: client protocol error:", err)
 	}
 }
 
+//剩余时间，随请求发送到服务端
+func (call *Call) timeout() int64 {
+	return timeoutUntil(call.deadline)
+}
+
+func timeoutUntil(deadline time.Time) int64 {
+	if deadline.IsZero() {
+		return 0
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		//已经过期，服务端收到后直接跳过
+		d = 1
+	}
+	return int64(d)
+}
+
+//从pending中移除尚未完成的调用，之后到达的回复会被丢弃
+func (client *TcpClient) cancel(call *Call) {
+	client.mutex.Lock()
+	if c, ok := client.pending[call.seq]; ok && c == call {
+		delete(client.pending, call.seq)
+	}
+	client.mutex.Unlock()
+}
+
 func (call *Call) done() {
 	select {
 	case call.Done <- call:
@@ -284,20 +344,59 @@ func NewClient(conn net.Conn) *TcpClient {
 }
 
 func NewTcpClient(network string, remoteAddr string, callback ...func(event string, data ...interface{})) (*TcpClient, error) {
+	return NewTcpClientWithCodec(network, remoteAddr, DefaultCodec, callback...)
+}
+
+// NewTcpClientWithCodec dials remoteAddr and speaks the registered codec of the given type.
+func NewTcpClientWithCodec(network string, remoteAddr string, codecType CodecType, callback ...func(event string, data ...interface{})) (*TcpClient, error) {
+	codec, err := GetCodec(codecType)
+	if err != nil {
+		return nil, err
+	}
 	conn, err := net.Dial(network, remoteAddr)
 	if err != nil {
 		return nil, err
 	}
-	return NewClientWithConn(conn, callback...), nil
+	return NewClientWithCodec(conn, codec, callback...)
 }
 
 func NewClientWithConn(conn net.Conn, callback ...func(event string, data ...interface{})) *TcpClient {
-	encBuf := bufio.NewWriter(conn)
-	codec := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	codec, err := GetCodec(DefaultCodec)
+	if err != nil {
+		codec = GobCodec{}
+	}
+	//握手失败时关闭连接，返回已终止的客户端，所有调用都会得到ErrShutdown
+	if err := writeHandshake(conn, codec.Type()); err != nil {
+		conn.Close()
+		return &TcpClient{
+			conn:           conn,
+			codec:          codec.NewClientCodec(conn),
+			codecType:      codec.Type(),
+			pending:        make(map[uint64]*Call),
+			streams:        make(map[uint64]*stream),
+			closeHeartbeat: make(chan struct{}),
+			shutdown:       true,
+		}
+	}
+	return newClient(conn, codec, callback...)
+}
+
+// NewClientWithCodec sends the codec handshake on conn and returns a client using that codec.
+func NewClientWithCodec(conn net.Conn, codec Codec, callback ...func(event string, data ...interface{})) (*TcpClient, error) {
+	if err := writeHandshake(conn, codec.Type()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newClient(conn, codec, callback...), nil
+}
+
+func newClient(conn net.Conn, codec Codec, callback ...func(event string, data ...interface{})) *TcpClient {
 	client := &TcpClient{
-		conn:    conn,
-		codec:   codec,
-		pending: make(map[uint64]*Call),
+		conn:      conn,
+		codec:     codec.NewClientCodec(conn),
+		codecType: codec.Type(),
+		pending:   make(map[uint64]*Call),
+		streams:   make(map[uint64]*stream),
 	}
 
 	if len(callback) > 0 {
@@ -313,6 +412,11 @@ func NewClientWithConn(conn net.Conn, callback ...func(event string, data ...int
 	return client
 }
 
+// CodecType returns the handshake type of the codec this client speaks.
+func (client *TcpClient) CodecType() CodecType {
+	return client.codecType
+}
+
 type gobClientCodec struct {
 	rwc    io.ReadWriteCloser
 	dec    *gob.Decoder
@@ -339,7 +443,7 @@ func (c *gobClientCodec) ReadResponseBody(body interface{}) error {
 }
 
 func (c *gobClientCodec) Close() error {
-	c.encBuf.Flush()
+	//写请求时已刷新，关闭时不再刷新，避免与正在发送的请求竞争
 	return c.rwc.Close()
 }
 
@@ -396,7 +500,8 @@ func (client *TcpClient) Close() error {
 		return ErrShutdown
 	}
 	client.closing = true
-	client.closeHeartbeat <- struct{}{}
+	//心跳协程在debug模式下不会启动，关闭通道而不是发送，避免阻塞
+	close(client.closeHeartbeat)
 	client.mutex.Unlock()
 	return err
 }
@@ -406,10 +511,16 @@ func (client *TcpClient) Close() error {
 // the same Call object. If done is nil, Go will allocate a new channel.
 // If non-nil, done must be buffered or Go will deliberately crash.
 func (client *TcpClient) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	return client.goWithDeadline(serviceMethod, args, reply, done, time.Time{}, nil)
+}
+
+func (client *TcpClient) goWithDeadline(serviceMethod string, args interface{}, reply interface{}, done chan *Call, deadline time.Time, meta Metadata) *Call {
 	call := new(Call)
 	call.ServiceMethod = serviceMethod
 	call.Args = args
 	call.Reply = reply
+	call.deadline = deadline
+	call.meta = meta
 	if done == nil {
 		done = make(chan *Call, 10) // buffered.
 	} else {
@@ -433,19 +544,63 @@ func (client *TcpClient) Go(serviceMethod string, args interface{}, reply interf
 }
 
 // Call invokes the named function, waits for it to complete, and returns its error status.
+// The call times out after CallTimeout.
 func (client *TcpClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return client.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext is like Call but honors the deadline and cancellation of ctx.
+// If ctx has no deadline, CallTimeout applies. The deadline is sent to the
+// server, which skips the call if it expires before the method runs.
+// When ctx is done the call is removed from pending and ctx.Err() is returned.
+// Metadata attached with WithMetadata is sent along with the request.
+// The call passes through the client interceptors added by Use.
+func (client *TcpClient) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	client.interceptorLock.RLock()
+	interceptors := client.interceptors
+	client.interceptorLock.RUnlock()
+	if len(interceptors) == 0 {
+		return client.invoke(ctx, serviceMethod, args, reply)
+	}
+	return chainClientInterceptors(interceptors, client.invoke)(ctx, serviceMethod, args, reply)
+}
+
+// Use appends interceptors to the client. They run in the order given,
+// around every CallContext and Call made afterwards.
+func (client *TcpClient) Use(interceptors ...ClientInterceptor) {
+	client.interceptorLock.Lock()
+	defer client.interceptorLock.Unlock()
+	chain := make([]ClientInterceptor, 0, len(client.interceptors)+len(interceptors))
+	chain = append(chain, client.interceptors...)
+	client.interceptors = append(chain, interceptors...)
+}
+
+//拦截器链的最内层，发送请求并等待结果
+func (client *TcpClient) invoke(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
 	if client.IsClosed() {
 		return ErrShutdown
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	//check the reply type
 	//if t:=reflect.TypeOf(reply);t.Kind() != 54 {
 	//	return errors.New(fmt.Sprintf("%s is not pointer,stead of &%s",t.Name(),t.Name()))
 	//}
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	var timeout <-chan struct{}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(CallTimeout)
+		timeout = timer.After(CallTimeout)
+	}
+	call := client.goWithDeadline(serviceMethod, args, reply, make(chan *Call, 1), deadline, MetadataFromContext(ctx))
 	select {
-	case <-timer.After(CallTimeout):
-		call.Error = ErrTimeout
+	case <-timeout:
+		client.cancel(call)
 		return ErrTimeout
+	case <-ctx.Done():
+		client.cancel(call)
+		return ctx.Err()
 	case <-call.Done:
 		return call.Error
 	}
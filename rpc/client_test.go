@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type CountArith struct {
+	calls int
+}
+
+func (t *CountArith) Add(args Args, reply *Reply) error {
+	t.calls++
+	reply.C = args.A + args.B
+	return nil
+}
+
+func TestCallContextCancel(t *testing.T) {
+	_, addr := startCodecServer(t)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.CallContext(ctx, "Arith.SleepMilli", &Args{A: 500}, new(Reply))
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded; got %v", err)
+	}
+	client.mutex.Lock()
+	n := len(client.pending)
+	client.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("expected cancelled call to be removed from pending, %d left", n)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = client.CallContext(ctx, "Arith.Add", &Args{1, 2}, new(Reply)); err != context.Canceled {
+		t.Errorf("expected context.Canceled; got %v", err)
+	}
+
+	// The connection is still usable after the abandoned call completes on the server.
+	reply := new(Reply)
+	if err = client.CallContext(context.Background(), "Arith.Add", &Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3 got %d, err %v", reply.C, err)
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	server, addr := startCodecServer(t)
+	counter := new(CountArith)
+	server.Register(counter)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	// An already expired deadline reaches the server, which skips the method.
+	call := client.goWithDeadline("CountArith.Add", &Args{1, 2}, new(Reply), make(chan *Call, 1), time.Now().Add(-time.Second), nil)
+	<-call.Done
+	if call.Error == nil || call.Error.Error() != ErrDeadlineExceeded.Error() {
+		t.Errorf("expected %q; got %v", ErrDeadlineExceeded, call.Error)
+	}
+	if counter.calls != 0 {
+		t.Errorf("expected method to be skipped, called %d times", counter.calls)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply := new(Reply)
+	if err = client.CallContext(ctx, "CountArith.Add", &Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3 got %d, err %v", reply.C, err)
+	}
+	if counter.calls != 1 {
+		t.Errorf("expected one call, got %d", counter.calls)
+	}
+}
+
+func TestSendWithoutReplyError(t *testing.T) {
+	_, addr := startCodecServer(t)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	// A write error completes the call once and is not overwritten.
+	call := client.Go("Arith.Add", make(chan int), nil, make(chan *Call, 2))
+	if call.Error == nil {
+		t.Fatal("expected the encode error")
+	}
+	if n := len(call.Done); n != 1 {
+		t.Fatalf("expected the call to be completed once, completed %d times", n)
+	}
+}
+
+func TestServerWaitIdle(t *testing.T) {
+	server, addr := startCodecServer(t)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	call := client.Go("Arith.SleepMilli", &Args{A: 100}, new(Reply), nil)
+	for server.Inflight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = server.WaitIdle(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded; got %v", err)
+	}
+	if err = server.WaitIdle(context.Background()); err != nil {
+		t.Errorf("WaitIdle: %v", err)
+	}
+	if server.Inflight() != 0 {
+		t.Errorf("expected no call in flight, got %d", server.Inflight())
+	}
+	<-call.Done
+	if call.Error != nil {
+		t.Errorf("SleepMilli: %v", call.Error)
+	}
+}
+
+func TestClientHandshakeFailure(t *testing.T) {
+	conn, peer := net.Pipe()
+	peer.Close()
+	client := NewClientWithConn(conn)
+	if !client.IsClosed() {
+		t.Error("expected client to be shut down after a failed handshake")
+	}
+	if err := client.Call("Arith.Add", &Args{1, 2}, new(Reply)); err != ErrShutdown {
+		t.Errorf("expected ErrShutdown; got %v", err)
+	}
+	client.Close()
+}
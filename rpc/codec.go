@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+/*
+	编解码器注册表
+	连接建立时，客户端先发送一个字节的握手标识，服务端据此选择对应的编解码器，
+	握手之后的数据流完全由选定的编解码器负责。gob为默认编解码器。
+*/
+
+type CodecType = byte
+
+const (
+	CODEC_TYPE_NONE CodecType = iota
+	CODEC_TYPE_GOB
+	CODEC_TYPE_MSGPACK
+	CODEC_TYPE_PROTOBUF
+)
+
+var ErrUnknownCodec = errors.New("rpc: unknown codec")
+
+// A Codec creates the client and server halves of one wire format.
+// Type is the handshake byte sent by the client when the connection opens.
+type Codec interface {
+	Type() CodecType
+	Name() string
+	NewClientCodec(conn io.ReadWriteCloser) ClientCodec
+	NewServerCodec(conn net.Conn, iocallback func()) ServerCodec
+}
+
+type codecRegistry struct {
+	locker sync.RWMutex
+	codecs map[CodecType]Codec
+}
+
+func newCodecRegistry(codecs ...Codec) *codecRegistry {
+	r := &codecRegistry{codecs: make(map[CodecType]Codec)}
+	for _, c := range codecs {
+		r.codecs[c.Type()] = c
+	}
+	return r
+}
+
+func (this *codecRegistry) register(codec Codec) error {
+	if codec.Type() == CODEC_TYPE_NONE {
+		return errors.New("rpc: codec type 0 is reserved")
+	}
+	this.locker.Lock()
+	this.codecs[codec.Type()] = codec
+	this.locker.Unlock()
+	return nil
+}
+
+func (this *codecRegistry) get(typ CodecType) (Codec, bool) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	c, ok := this.codecs[typ]
+	return c, ok
+}
+
+func (this *codecRegistry) getByName(name string) (Codec, bool) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	for _, c := range this.codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (this *codecRegistry) all() []Codec {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	codecs := make([]Codec, 0, len(this.codecs))
+	for _, c := range this.codecs {
+		codecs = append(codecs, c)
+	}
+	return codecs
+}
+
+//全局编解码器，新建的Server默认支持全部已注册的编解码器
+var codecs = newCodecRegistry(GobCodec{}, MsgpackCodec{}, ProtobufCodec{})
+
+// RegisterCodec adds a codec to the global registry. Servers created
+// afterwards accept it, and clients can select it by type or name.
+func RegisterCodec(codec Codec) error {
+	return codecs.register(codec)
+}
+
+// GetCodec returns the globally registered codec with the given handshake type.
+func GetCodec(typ CodecType) (Codec, error) {
+	if c, ok := codecs.get(typ); ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, typ)
+}
+
+// GetCodecByName returns the globally registered codec with the given name,
+// such as "gob", "msgpack" or "protobuf".
+func GetCodecByName(name string) (Codec, error) {
+	if c, ok := codecs.getByName(name); ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+//客户端握手，发送编解码器标识
+func writeHandshake(conn io.Writer, typ CodecType) error {
+	_, err := conn.Write([]byte{typ})
+	return err
+}
+
+//服务端握手，读取编解码器标识
+func readHandshake(conn io.Reader) (CodecType, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return CODEC_TYPE_NONE, err
+	}
+	return b[0], nil
+}
+
+// GobCodec is the default codec, using the gob wire format (see package gob).
+type GobCodec struct{}
+
+func (GobCodec) Type() CodecType {
+	return CODEC_TYPE_GOB
+}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (GobCodec) NewClientCodec(conn io.ReadWriteCloser) ClientCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+}
+
+func (GobCodec) NewServerCodec(conn net.Conn, iocallback func()) ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:        conn,
+		dec:        gob.NewDecoder(conn),
+		enc:        gob.NewEncoder(buf),
+		encBuf:     buf,
+		iocallback: iocallback,
+	}
+}
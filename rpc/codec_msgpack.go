@@ -0,0 +1,140 @@
+package rpc
+
+import (
+	"bufio"
+	"github.com/ugorji/go/codec"
+	"github.com/zllangct/rockgo/logger"
+	"io"
+	"net"
+)
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+// MsgpackCodec encodes headers and bodies as consecutive msgpack values,
+// which lets clients written in other languages call the services.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Type() CodecType {
+	return CODEC_TYPE_MSGPACK
+}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) NewClientCodec(conn io.ReadWriteCloser) ClientCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &msgpackClientCodec{
+		rwc:    conn,
+		dec:    codec.NewDecoder(bufio.NewReader(conn), msgpackHandle),
+		enc:    codec.NewEncoder(encBuf, msgpackHandle),
+		encBuf: encBuf,
+	}
+}
+
+func (MsgpackCodec) NewServerCodec(conn net.Conn, iocallback func()) ServerCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &msgpackServerCodec{
+		rwc:        conn,
+		dec:        codec.NewDecoder(bufio.NewReader(conn), msgpackHandle),
+		enc:        codec.NewEncoder(encBuf, msgpackHandle),
+		encBuf:     encBuf,
+		iocallback: iocallback,
+	}
+}
+
+//编码器跨多次Encode复用时状态不会自动清理，每次编码前重置
+func msgpackEncode(enc *codec.Encoder, w io.Writer, v interface{}) error {
+	enc.Reset(w)
+	return enc.Encode(v)
+}
+
+//msgpack无法解码到nil，丢弃时解码到临时变量
+func msgpackDecode(dec *codec.Decoder, body interface{}) error {
+	if body == nil {
+		var discard interface{}
+		return dec.Decode(&discard)
+	}
+	return dec.Decode(body)
+}
+
+type msgpackClientCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *codec.Decoder
+	enc    *codec.Encoder
+	encBuf *bufio.Writer
+}
+
+func (c *msgpackClientCodec) WriteRequest(r *Request, body interface{}) (err error) {
+	if err = msgpackEncode(c.enc, c.encBuf, r); err != nil {
+		return
+	}
+	if err = msgpackEncode(c.enc, c.encBuf, body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *msgpackClientCodec) ReadResponseHeader(r *Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *msgpackClientCodec) ReadResponseBody(body interface{}) error {
+	return msgpackDecode(c.dec, body)
+}
+
+func (c *msgpackClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+type msgpackServerCodec struct {
+	rwc        net.Conn
+	dec        *codec.Decoder
+	enc        *codec.Encoder
+	encBuf     *bufio.Writer
+	iocallback func()
+	closed     bool
+}
+
+func (c *msgpackServerCodec) ReadRequestHeader(r *Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *msgpackServerCodec) ReadRequestBody(body interface{}) error {
+	return msgpackDecode(c.dec, body)
+}
+
+func (c *msgpackServerCodec) IOCallback() {
+	c.iocallback()
+}
+
+func (c *msgpackServerCodec) WriteResponse(r *Response, body interface{}) (err error) {
+	if err = msgpackEncode(c.enc, c.encBuf, r); err != nil {
+		if c.encBuf.Flush() == nil {
+			logger.Info("rpc: msgpack error encoding response:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = msgpackEncode(c.enc, c.encBuf, body); err != nil {
+		if c.encBuf.Flush() == nil {
+			logger.Info("rpc: msgpack error encoding body:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *msgpackServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,321 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/zllangct/rockgo/logger"
+	"io"
+	"net"
+)
+
+/*
+	protobuf编解码器
+	每个消息由两帧组成：header帧和body帧，每帧前缀为uvarint编码的长度。
+	header按如下protobuf字段手工编码，body必须实现proto.Message：
+		Request:  1 ServiceMethod(string) 2 Seq(uint64) 3 Type(int) 4 Timeout(int64)
+		Response: 1 ServiceMethod(string) 2 Seq(uint64) 3 Error(string)
+*/
+
+//单帧最大长度
+var MaxProtobufFrameSize uint64 = 64 << 20
+
+var ErrFrameTooLarge = errors.New("rpc: protobuf frame too large")
+var ErrBadHeader = errors.New("rpc: malformed protobuf header")
+
+// ProtobufCodec frames protobuf messages with a uvarint length prefix.
+// Argument and reply types of services called through it must implement proto.Message.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Type() CodecType {
+	return CODEC_TYPE_PROTOBUF
+}
+
+func (ProtobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (ProtobufCodec) NewClientCodec(conn io.ReadWriteCloser) ClientCodec {
+	return &protobufClientCodec{
+		rwc: conn,
+		r:   bufio.NewReader(conn),
+		w:   bufio.NewWriter(conn),
+	}
+}
+
+func (ProtobufCodec) NewServerCodec(conn net.Conn, iocallback func()) ServerCodec {
+	return &protobufServerCodec{
+		rwc:        conn,
+		r:          bufio.NewReader(conn),
+		w:          bufio.NewWriter(conn),
+		iocallback: iocallback,
+	}
+}
+
+func writeFrame(w *bufio.Writer, data []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(data)))
+	if _, err := w.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxProtobufFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func marshalProtoBody(body interface{}) ([]byte, error) {
+	switch b := body.(type) {
+	case nil, struct{}, *struct{}:
+		return nil, nil
+	case proto.Message:
+		return proto.Marshal(b)
+	}
+	return nil, fmt.Errorf("rpc: protobuf codec can't encode %T, it is not a proto.Message", body)
+}
+
+func unmarshalProtoBody(data []byte, body interface{}) error {
+	switch b := body.(type) {
+	case nil, *struct{}:
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, b)
+	}
+	return fmt.Errorf("rpc: protobuf codec can't decode into %T, it is not a proto.Message", body)
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	b = appendUvarint(b, uint64(num)<<3|proto.WireVarint)
+	return appendUvarint(b, v)
+}
+
+func appendStringField(b []byte, num int, s string) []byte {
+	b = appendUvarint(b, uint64(num)<<3|proto.WireBytes)
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func encodeRequestHeader(r *Request) []byte {
+	b := appendStringField(nil, 1, r.ServiceMethod)
+	b = appendVarintField(b, 2, r.Seq)
+	b = appendVarintField(b, 3, uint64(r.Type))
+	if r.Timeout > 0 {
+		b = appendVarintField(b, 4, uint64(r.Timeout))
+	}
+	//与protobuf的map<string,string>编码一致，每个键值对是一个嵌套消息
+	for k, v := range r.Meta {
+		entry := appendStringField(nil, 1, k)
+		entry = appendStringField(entry, 2, v)
+		b = appendStringField(b, 5, string(entry))
+	}
+	if r.Credit > 0 {
+		b = appendVarintField(b, 6, r.Credit)
+	}
+	return b
+}
+
+func encodeResponseHeader(r *Response) []byte {
+	b := appendStringField(nil, 1, r.ServiceMethod)
+	b = appendVarintField(b, 2, r.Seq)
+	if r.Error != "" {
+		b = appendStringField(b, 3, r.Error)
+	}
+	if r.Type != 0 {
+		b = appendVarintField(b, 4, uint64(r.Type))
+	}
+	if r.Credit > 0 {
+		b = appendVarintField(b, 5, r.Credit)
+	}
+	return b
+}
+
+//逐个字段解析header，varint字段传入数值，bytes字段传入内容，其他类型不支持
+func decodeHeader(data []byte, field func(num int, v uint64, s string)) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrBadHeader
+		}
+		data = data[n:]
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrBadHeader
+		}
+		data = data[n:]
+		switch key & 7 {
+		case proto.WireVarint:
+			field(int(key>>3), v, "")
+		case proto.WireBytes:
+			if uint64(len(data)) < v {
+				return ErrBadHeader
+			}
+			field(int(key>>3), 0, string(data[:v]))
+			data = data[v:]
+		default:
+			return ErrBadHeader
+		}
+	}
+	return nil
+}
+
+type protobufClientCodec struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+	w   *bufio.Writer
+}
+
+func (c *protobufClientCodec) WriteRequest(r *Request, body interface{}) error {
+	data, err := marshalProtoBody(body)
+	if err != nil {
+		return err
+	}
+	if err = writeFrame(c.w, encodeRequestHeader(r)); err != nil {
+		return err
+	}
+	if err = writeFrame(c.w, data); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *protobufClientCodec) ReadResponseHeader(r *Response) error {
+	data, err := readFrame(c.r)
+	if err != nil {
+		return err
+	}
+	return decodeHeader(data, func(num int, v uint64, s string) {
+		switch num {
+		case 1:
+			r.ServiceMethod = s
+		case 2:
+			r.Seq = v
+		case 3:
+			r.Error = s
+		case 4:
+			r.Type = int(v)
+		case 5:
+			r.Credit = v
+		}
+	})
+}
+
+func (c *protobufClientCodec) ReadResponseBody(body interface{}) error {
+	data, err := readFrame(c.r)
+	if err != nil {
+		return err
+	}
+	return unmarshalProtoBody(data, body)
+}
+
+func (c *protobufClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+type protobufServerCodec struct {
+	rwc        net.Conn
+	r          *bufio.Reader
+	w          *bufio.Writer
+	iocallback func()
+	closed     bool
+}
+
+func (c *protobufServerCodec) ReadRequestHeader(r *Request) error {
+	data, err := readFrame(c.r)
+	if err != nil {
+		return err
+	}
+	var metaErr error
+	err = decodeHeader(data, func(num int, v uint64, s string) {
+		switch num {
+		case 1:
+			r.ServiceMethod = s
+		case 2:
+			r.Seq = v
+		case 3:
+			r.Type = int(v)
+		case 4:
+			r.Timeout = int64(v)
+		case 5:
+			var key, value string
+			if err := decodeHeader([]byte(s), func(num int, _ uint64, s string) {
+				switch num {
+				case 1:
+					key = s
+				case 2:
+					value = s
+				}
+			}); err != nil {
+				metaErr = err
+				return
+			}
+			if r.Meta == nil {
+				r.Meta = make(map[string]string)
+			}
+			r.Meta[key] = value
+		case 6:
+			r.Credit = v
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return metaErr
+}
+
+func (c *protobufServerCodec) ReadRequestBody(body interface{}) error {
+	data, err := readFrame(c.r)
+	if err != nil {
+		return err
+	}
+	return unmarshalProtoBody(data, body)
+}
+
+func (c *protobufServerCodec) IOCallback() {
+	c.iocallback()
+}
+
+func (c *protobufServerCodec) WriteResponse(r *Response, body interface{}) error {
+	data, err := marshalProtoBody(body)
+	if err != nil {
+		//body无法编码时，仍然回复错误，避免客户端等待超时
+		logger.Info("rpc: protobuf error encoding body:", err)
+		r.Error = err.Error()
+		data = nil
+	}
+	if err = writeFrame(c.w, encodeResponseHeader(r)); err != nil {
+		return err
+	}
+	if err = writeFrame(c.w, data); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *protobufServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
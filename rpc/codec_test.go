@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+type PbArgs struct {
+	A int32 `protobuf:"varint,1,opt,name=A"`
+	B int32 `protobuf:"varint,2,opt,name=B"`
+}
+
+func (m *PbArgs) Reset()         { *m = PbArgs{} }
+func (m *PbArgs) String() string { return fmt.Sprintf("A:%d B:%d", m.A, m.B) }
+func (*PbArgs) ProtoMessage()    {}
+
+type PbReply struct {
+	C int32 `protobuf:"varint,1,opt,name=C"`
+}
+
+func (m *PbReply) Reset()         { *m = PbReply{} }
+func (m *PbReply) String() string { return fmt.Sprintf("C:%d", m.C) }
+func (*PbReply) ProtoMessage()    {}
+
+type PbArith int
+
+func (t *PbArith) Add(args *PbArgs, reply *PbReply) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func startCodecServer(t *testing.T) (*Server, string) {
+	server := NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register(new(PbArith)); err != nil {
+		t.Fatal(err)
+	}
+	var l net.Listener
+	l, addr := listenTCP()
+	go server.Accept(l)
+	return server, addr
+}
+
+func TestCodecs(t *testing.T) {
+	_, addr := startCodecServer(t)
+	for _, typ := range []CodecType{CODEC_TYPE_GOB, CODEC_TYPE_MSGPACK} {
+		client, err := NewTcpClientWithCodec("tcp", addr, typ)
+		if err != nil {
+			t.Fatal("dialing", err)
+		}
+		if client.CodecType() != typ {
+			t.Errorf("codec %d: client reports codec %d", typ, client.CodecType())
+		}
+
+		args := &Args{7, 8}
+		reply := new(Reply)
+		err = client.Call("Arith.Add", args, reply)
+		if err != nil {
+			t.Errorf("codec %d: Add: expected no error but got %q", typ, err.Error())
+		}
+		if reply.C != args.A+args.B {
+			t.Errorf("codec %d: Add: expected %d got %d", typ, args.A+args.B, reply.C)
+		}
+
+		// Error response followed by a normal call on the same connection.
+		err = client.Call("Arith.Div", &Args{7, 0}, new(Reply))
+		if err == nil || err.Error() != "divide by zero" {
+			t.Errorf("codec %d: Div: expected divide by zero error; got %v", typ, err)
+		}
+		err = client.Call("Arith.Mul", args, reply)
+		if err != nil || reply.C != args.A*args.B {
+			t.Errorf("codec %d: Mul: expected %d got %d, err %v", typ, args.A*args.B, reply.C, err)
+		}
+		client.Close()
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	_, addr := startCodecServer(t)
+	client, err := NewTcpClientWithCodec("tcp", addr, CODEC_TYPE_PROTOBUF)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	reply := new(PbReply)
+	err = client.Call("PbArith.Add", &PbArgs{A: 7, B: 8}, reply)
+	if err != nil {
+		t.Errorf("Add: expected no error but got %q", err.Error())
+	}
+	if reply.C != 15 {
+		t.Errorf("Add: expected 15 got %d", reply.C)
+	}
+
+	hb := new(HeartBeatReuslt)
+	err = client.Call("InnerResponse.HeartBeat", struct{}{}, hb)
+	if err != nil || hb.Result != InnerResponseContent {
+		t.Errorf("HeartBeat: expected %d got %d, err %v", InnerResponseContent, hb.Result, err)
+	}
+
+	// Non-protobuf arguments are rejected on the client side.
+	err = client.Call("Arith.Add", &Args{7, 8}, new(Reply))
+	if err == nil || !strings.Contains(err.Error(), "proto.Message") {
+		t.Errorf("expected proto.Message error; got %v", err)
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	server, addr := startCodecServer(t)
+	if _, err := NewTcpClientWithCodec("tcp", addr, 200); err == nil {
+		t.Error("expected error dialing with unregistered codec")
+	}
+	if _, err := GetCodecByName("json"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec; got %v", err)
+	}
+
+	// A codec registered globally but not on this server is refused by the handshake.
+	server.codecs = newCodecRegistry(GobCodec{})
+	client, err := NewTcpClientWithCodec("tcp", addr, CODEC_TYPE_MSGPACK)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	err = client.Call("Arith.Add", &Args{1, 2}, new(Reply))
+	if err == nil {
+		t.Error("expected error calling through a refused codec")
+	}
+	client.Close()
+}
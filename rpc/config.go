@@ -10,4 +10,6 @@ var (
 	CallTimeout   = time.Millisecond * 5000 //rpc call timeout
 	Timeout       = time.Millisecond * 9000 //rpc timeout
 	DebugMode     = true                    // is debug model
+	DefaultCodec  = CODEC_TYPE_GOB          //codec used by new clients
+	StreamWindow  = 16                      //flow control window of streams, in messages
 )
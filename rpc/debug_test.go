@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type PanicArith int
+
+func (t *PanicArith) Add(args Args, reply *Reply) error {
+	if args.B == 0 {
+		panic("b is zero")
+	}
+	reply.C = args.A + args.B
+	return nil
+}
+
+func TestMethodPanic(t *testing.T) {
+	server, addr := startCodecServer(t)
+	if err := server.Register(new(PanicArith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		err = client.Call("PanicArith.Add", &Args{1, 0}, new(Reply))
+		if _, ok := err.(ServerError); !ok || !strings.Contains(err.Error(), "PanicArith.Add") {
+			t.Errorf("expected ServerError naming the method; got %#v", err)
+		}
+	}
+
+	// The server survives and keeps serving the same connection.
+	reply := new(Reply)
+	if err = client.Call("PanicArith.Add", &Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3 got %d, err %v", reply.C, err)
+	}
+
+	svc, _ := server.serviceMap.Load("PanicArith")
+	if n := svc.(*service).method["Add"].NumPanics(); n != 2 {
+		t.Errorf("expected 2 panics recorded, got %d", n)
+	}
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	if body := w.Body.String(); !strings.Contains(body, "Panics") || !strings.Contains(body, "<td align=center>2</td>") {
+		t.Errorf("debug page does not show the panic count:\n%s", body)
+	}
+}
@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+)
+
+/*
+	调用拦截器
+	服务端拦截器在参数解码之后、方法执行之前被调用，可用于鉴权、日志、指标统计等；
+	客户端拦截器包裹每一次同步调用，可用于注入trace id、重试等。
+	拦截器按注册顺序由外向内执行，调用next/invoker进入下一层。
+*/
+
+// Handler runs the remaining server interceptors and finally the method itself.
+// args and reply are the decoded argument and the reply value of the method.
+type Handler func(ctx context.Context, args interface{}, reply interface{}) error
+
+// ServerInterceptor intercepts a call on the server. serviceMethod has the
+// form "Service.Method"; reply is nil for calls that expect no reply.
+// An interceptor that returns without calling next skips the method, and its
+// error is sent back to the caller. next may be called with other args and reply
+// of the same types; the method runs on them and that reply is sent back.
+type ServerInterceptor func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next Handler) error
+
+// Invoker sends the call over the connection, or runs the remaining client interceptors.
+type Invoker func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
+
+// ClientInterceptor intercepts CallContext (and therefore Call) on a TcpClient.
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, invoker Invoker) error
+
+// Metadata is a set of string pairs sent with a request. It is carried in the
+// request header and is available to server interceptors through the context.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying key=value in addition to any
+// metadata already attached to ctx.
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	old := MetadataFromContext(ctx)
+	md := make(Metadata, len(old)+1)
+	for k, v := range old {
+		md[k] = v
+	}
+	md[key] = value
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the metadata attached to ctx, or nil.
+// On the server it holds the metadata sent by the client.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+//组装服务端拦截器链，最内层为方法调用
+func chainServerInterceptors(interceptors []ServerInterceptor, serviceMethod string, handler Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, args interface{}, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return handler
+}
+
+//组装客户端拦截器链，最内层为实际发送
+func chainClientInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestServerInterceptor(t *testing.T) {
+	server, addr := startCodecServer(t)
+	var (
+		mu    sync.Mutex
+		trace []string
+	)
+	record := func(s string) {
+		mu.Lock()
+		trace = append(trace, s)
+		mu.Unlock()
+	}
+	server.Use(func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next Handler) error {
+		record("outer:" + serviceMethod)
+		err := next(ctx, args, reply)
+		if r, ok := reply.(*Reply); ok && serviceMethod == "Arith.Add" {
+			record("reply")
+			if r.C != 3 {
+				t.Errorf("interceptor: expected reply 3 got %d", r.C)
+			}
+		}
+		return err
+	}, func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next Handler) error {
+		record("inner")
+		if serviceMethod == "Arith.Div" {
+			return errors.New("denied")
+		}
+		if a, ok := args.(Args); !ok || a.A != 1 {
+			t.Errorf("interceptor: unexpected args %#v", args)
+		}
+		return next(ctx, args, reply)
+	})
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err = client.Call("Arith.Add", &Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3 got %d, err %v", reply.C, err)
+	}
+	mu.Lock()
+	got := append([]string(nil), trace...)
+	trace = nil
+	mu.Unlock()
+	want := []string{"outer:Arith.Add", "inner", "reply"}
+	if len(got) != len(want) {
+		t.Fatalf("expected trace %v got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected trace %v got %v", want, got)
+			break
+		}
+	}
+
+	// An interceptor returning without calling next skips the method.
+	if err = client.Call("Arith.Div", &Args{1, 0}, new(Reply)); err == nil || err.Error() != "denied" {
+		t.Errorf("Div: expected denied; got %v", err)
+	}
+}
+
+func TestServerInterceptorReplace(t *testing.T) {
+	server, addr := startCodecServer(t)
+	server.Use(func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next Handler) error {
+		switch serviceMethod {
+		case "Arith.Add":
+			// The method runs on the replaced values and the replaced reply is sent back.
+			return next(ctx, Args{10, 20}, new(Reply))
+		case "Arith.Mul":
+			return next(ctx, Args{10, 20}, reply)
+		}
+		return next(ctx, args, reply)
+	})
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err = client.Call("Arith.Add", &Args{1, 2}, reply); err != nil || reply.C != 30 {
+		t.Errorf("Add: expected 30 got %d, err %v", reply.C, err)
+	}
+	// Values of another type are rejected.
+	if err = client.Call("Arith.Mul", &Args{1, 2}, new(Reply)); err == nil {
+		t.Error("Mul: expected an error for args of the wrong type")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	server, addr := startCodecServer(t)
+	got := make(chan Metadata, 1)
+	server.Use(func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next Handler) error {
+		if serviceMethod != "InnerResponse.HeartBeat" {
+			got <- MetadataFromContext(ctx)
+		}
+		return next(ctx, args, reply)
+	})
+
+	for _, typ := range []CodecType{CODEC_TYPE_GOB, CODEC_TYPE_MSGPACK, CODEC_TYPE_PROTOBUF} {
+		client, err := NewTcpClientWithCodec("tcp", addr, typ)
+		if err != nil {
+			t.Fatal("dialing", err)
+		}
+		client.Use(func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, invoker Invoker) error {
+			return invoker(WithMetadata(ctx, "trace-id", "abc"), serviceMethod, args, reply)
+		})
+		ctx := WithMetadata(context.Background(), "user", "u1")
+		if typ == CODEC_TYPE_PROTOBUF {
+			err = client.CallContext(ctx, "PbArith.Add", &PbArgs{A: 1, B: 2}, new(PbReply))
+		} else {
+			err = client.CallContext(ctx, "Arith.Add", &Args{1, 2}, new(Reply))
+		}
+		if err != nil {
+			t.Errorf("codec %d: Add: %v", typ, err)
+		}
+		md := <-got
+		if md["trace-id"] != "abc" || md["user"] != "u1" {
+			t.Errorf("codec %d: unexpected metadata %v", typ, md)
+		}
+		client.Close()
+	}
+}
+
+func TestClientInterceptorRetry(t *testing.T) {
+	server, addr := startCodecServer(t)
+	failures := 2
+	var mu sync.Mutex
+	server.Use(func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next Handler) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if serviceMethod == "Arith.Add" && failures > 0 {
+			failures--
+			return errors.New("unavailable")
+		}
+		return next(ctx, args, reply)
+	})
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+	attempts := 0
+	client.Use(func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, invoker Invoker) error {
+		var err error
+		for i := 0; i < 3; i++ {
+			attempts++
+			if err = invoker(ctx, serviceMethod, args, reply); err == nil {
+				return nil
+			}
+		}
+		return err
+	})
+
+	reply := new(Reply)
+	if err = client.Call("Arith.Add", &Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3 got %d, err %v", reply.C, err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
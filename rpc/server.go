@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"bufio"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -10,8 +11,10 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	rtdebug "runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -24,9 +27,13 @@ const (
 	InnerResponseContent = 1
 )
 
+var ErrDeadlineExceeded = errors.New("rpc: deadline exceeded before the call started")
+
 // Precompute the reflect type for error. Can't use error directly
 // because Typeof takes an empty interface value. This is annoying.
 var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+var typeOfStreamSender = reflect.TypeOf((*StreamSender)(nil))
+var typeOfStreamReceiver = reflect.TypeOf((*StreamReceiver)(nil))
 
 type methodType struct {
 	sync.Mutex // protects counters
@@ -34,6 +41,8 @@ type methodType struct {
 	ArgType    reflect.Type
 	ReplyType  reflect.Type
 	numCalls   uint
+	numPanics  uint
+	callType   int // RPC_CALL_TYPE_NORMAL, or the stream type the method serves
 }
 
 type service struct {
@@ -50,7 +59,11 @@ type Request struct {
 	ServiceMethod string // format: "Service.Method"
 	Seq           uint64 // sequence number chosen by client
 	Type          int
-	next          *Request // for free list in ServerNode
+	Timeout       int64             // remaining nanoseconds when sent, 0 means no deadline
+	Meta          map[string]string // metadata attached by client interceptors
+	Credit        uint64            // flow control credit granted, for stream frames
+	deadline      time.Time         // local deadline computed from Timeout on arrival
+	next          *Request          // for free list in ServerNode
 }
 
 // Response is a header written before every RPC return. It is used internally
@@ -60,6 +73,8 @@ type Response struct {
 	ServiceMethod string    // echoes that of the Request
 	Seq           uint64    // echoes that of the request
 	Error         string    // error, if any.
+	Type          int       // stream frame type, 0 for the final response
+	Credit        uint64    // flow control credit granted, for stream frames
 	next          *Response // for free list in ServerNode
 }
 
@@ -70,11 +85,23 @@ type Server struct {
 	freeReq    *Request
 	respLock   sync.Mutex // protects freeResp
 	freeResp   *Response
+	codecs     *codecRegistry
+
+	interceptorLock sync.RWMutex // protects interceptors
+	interceptors    []ServerInterceptor
+
+	inflight int64 //正在执行的普通调用数，不含流式调用
 }
 
 type HeartBeatReuslt struct {
-	Result int
+	Result int32 `protobuf:"varint,1,opt,name=Result"`
 }
+
+//实现proto.Message，使心跳在protobuf编解码器下同样可用
+func (this *HeartBeatReuslt) Reset()         { *this = HeartBeatReuslt{} }
+func (this *HeartBeatReuslt) String() string { return fmt.Sprintf("Result:%d", this.Result) }
+func (*HeartBeatReuslt) ProtoMessage()       {}
+
 type InnerResponse struct {
 }
 
@@ -113,15 +140,21 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 }
 
 func defaultServer() *Server {
-	return &Server{}
+	return &Server{codecs: newCodecRegistry(codecs.all()...)}
+}
+
+// RegisterCodec adds a codec that this server accepts during the connection handshake.
+func (server *Server) RegisterCodec(codec Codec) error {
+	return server.codecs.register(codec)
 }
 
 // RegisterGroup publishes in the server the set of methods of the
 // receiver value that satisfy the following conditions:
-//	- exported method of exported type
-//	- two arguments, both of exported type
-//	- the second argument is a pointer
-//	- one return value, of type error
+//   - exported method of exported type
+//   - two arguments, both of exported type
+//   - the second argument is a pointer
+//   - one return value, of type error
+//
 // It returns an error if the receiver is not an exported type or has
 // no suitable methods. It also logs the error using package log.
 // The client accesses each method using a string of the form "Type.Method",
@@ -237,7 +270,14 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 			//}
 			continue
 		}
-		methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType}
+		callType := RPC_CALL_TYPE_NORMAL
+		switch {
+		case replyType == typeOfStreamSender:
+			callType = RPC_CALL_TYPE_SERVER_STREAM
+		case argType == typeOfStreamReceiver:
+			callType = RPC_CALL_TYPE_CLIENT_STREAM
+		}
+		methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType, callType: callType}
 		logger.Info(fmt.Sprintf("rpc.RegisterGroup: service: [ %s ], method [ %s ] is registed", typ.Elem().Name(), mname))
 	}
 	return methods
@@ -278,33 +318,147 @@ func (m *methodType) NumCalls() (n uint) {
 	return n
 }
 
+func (m *methodType) NumPanics() (n uint) {
+	m.Lock()
+	n = m.numPanics
+	m.Unlock()
+	return n
+}
+
 func (s *service) call(server *Server, sending *sync.Mutex, wg *sync.WaitGroup, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec) {
 	if wg != nil {
 		defer wg.Done()
 	}
+	if req.Type == RPC_CALL_TYPE_NORMAL {
+		atomic.AddInt64(&server.inflight, 1)
+		defer atomic.AddInt64(&server.inflight, -1)
+	}
+	//调用方已经放弃等待，不再执行
+	if !req.deadline.IsZero() && time.Now().After(req.deadline) {
+		server.reply(sending, req, mtype, argv, replyv, codec, ErrDeadlineExceeded.Error())
+		server.freeRequest(req)
+		return
+	}
 	mtype.Lock()
 	mtype.numCalls++
 	mtype.Unlock()
-	function := mtype.method.Func
-	// ParseMessage the method, providing a new value for the reply.
-	args := []reflect.Value{s.rcvr, argv}
+	var reply interface{}
 	if mtype.ReplyType != nil {
-		args = append(args, replyv)
+		reply = replyv.Interface()
 	}
-	returnValues := function.Call(args)
-	// The return value for the method is an error.
-	errInter := returnValues[0].Interface()
-	errmsg := ""
-	if errInter != nil {
-		errmsg = errInter.(error).Error()
+	//拦截器可以替换参数和回复，方法使用传入的值，发送给调用方的是替换后的回复
+	handler := func(ctx context.Context, arg interface{}, reply interface{}) error {
+		in := reflect.ValueOf(arg)
+		if !in.IsValid() || in.Type() != argv.Type() {
+			return fmt.Errorf("rpc: %s: interceptor passed args of type %T, want %s", req.ServiceMethod, arg, argv.Type())
+		}
+		function := mtype.method.Func
+		// ParseMessage the method, providing a new value for the reply.
+		args := []reflect.Value{s.rcvr, in}
+		if mtype.ReplyType != nil {
+			out := reflect.ValueOf(reply)
+			if !out.IsValid() || out.Type() != replyv.Type() {
+				return fmt.Errorf("rpc: %s: interceptor passed reply of type %T, want %s", req.ServiceMethod, reply, replyv.Type())
+			}
+			replyv = out
+			args = append(args, replyv)
+		}
+		returnValues := function.Call(args)
+		// The return value for the method is an error.
+		errInter := returnValues[0].Interface()
+		if errInter != nil {
+			return errInter.(error)
+		}
+		return nil
 	}
-	if req.Type == RPC_CALL_TYPE_NORMAL {
-		reqi := replyv.Interface()
-		server.sendResponse(sending, req, reqi, codec, errmsg)
+	errmsg := ""
+	if err := server.intercept(mtype, req, handler, argv.Interface(), reply); err != nil {
+		errmsg = err.Error()
 	}
+	server.reply(sending, req, mtype, argv, replyv, codec, errmsg)
 	server.freeRequest(req)
 }
 
+// Inflight returns the number of unary calls being executed. Streaming calls
+// are not counted.
+func (server *Server) Inflight() int {
+	return int(atomic.LoadInt64(&server.inflight))
+}
+
+// WaitIdle waits until no unary call is being executed or ctx is done.
+func (server *Server) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for server.Inflight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+//发送调用结果，流式调用先结束对应的流，保证结果在所有数据帧之后
+func (server *Server) reply(sending *sync.Mutex, req *Request, mtype *methodType, argv, replyv reflect.Value, codec ServerCodec, errmsg string) {
+	var reply interface{} = invalidRequest
+	switch req.Type {
+	case RPC_CALL_TYPE_NORMAL:
+		reply = replyv.Interface()
+	case RPC_CALL_TYPE_SERVER_STREAM:
+		replyv.Interface().(*StreamSender).s.finish(nil)
+	case RPC_CALL_TYPE_CLIENT_STREAM:
+		argv.Interface().(*StreamReceiver).s.finish(nil)
+		if mtype.ReplyType != nil {
+			reply = replyv.Interface()
+		}
+	default:
+		return
+	}
+	server.sendResponse(sending, req, reply, codec, errmsg)
+}
+
+//经过拦截器链执行方法，上下文携带调用方的截止时间和元数据
+//方法或拦截器中的panic被捕获并作为错误返回给调用方，避免整个进程崩溃
+func (server *Server) intercept(mtype *methodType, req *Request, handler Handler, args interface{}, reply interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			mtype.Lock()
+			mtype.numPanics++
+			mtype.Unlock()
+			logger.Error(fmt.Sprintf("rpc: panic in %s: %v\n%s", req.ServiceMethod, r, rtdebug.Stack()))
+			err = ServerError(fmt.Sprintf("rpc: %s panic: %v", req.ServiceMethod, r))
+		}
+	}()
+	server.interceptorLock.RLock()
+	interceptors := server.interceptors
+	server.interceptorLock.RUnlock()
+	if len(interceptors) == 0 {
+		return handler(context.Background(), args, reply)
+	}
+	ctx := context.Background()
+	if len(req.Meta) > 0 {
+		ctx = context.WithValue(ctx, metadataKey{}, Metadata(req.Meta))
+	}
+	if !req.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, req.deadline)
+		defer cancel()
+	}
+	return chainServerInterceptors(interceptors, req.ServiceMethod, handler)(ctx, args, reply)
+}
+
+// Use appends interceptors to the server. They run in the order given,
+// around every method call served afterwards.
+func (server *Server) Use(interceptors ...ServerInterceptor) {
+	server.interceptorLock.Lock()
+	defer server.interceptorLock.Unlock()
+	//复制一份，已经开始执行的调用不受影响
+	chain := make([]ServerInterceptor, 0, len(server.interceptors)+len(interceptors))
+	chain = append(chain, server.interceptors...)
+	server.interceptors = append(chain, interceptors...)
+}
+
 type gobServerCodec struct {
 	rwc        net.Conn
 	dec        *gob.Decoder
@@ -358,21 +512,28 @@ func (c *gobServerCodec) Close() error {
 // ServeConn runs the server on a single connection.
 // ServeConn blocks, serving the connection until the client hangs up.
 // The caller typically invokes ServeConn in a go statement.
-// ServeConn uses the gob wire format (see package gob) on the
-// connection. To use an alternate codec, use ServeCodec.
+// ServeConn reads the handshake byte sent by the client and serves the
+// connection with the matching registered codec (see RegisterCodec).
 // See NewClient's comment for information about concurrent access.
 func (server *Server) ServeConn(conn net.Conn) {
-	buf := bufio.NewWriter(conn)
-	srv := &gobServerCodec{
-		rwc:    conn,
-		dec:    gob.NewDecoder(conn),
-		enc:    gob.NewEncoder(buf),
-		encBuf: buf,
-		iocallback: func() {
-			server.UpdateConnTimeout(conn)
-		},
-	}
 	server.UpdateConnTimeout(conn)
+	typ, err := readHandshake(conn)
+	if err != nil {
+		if debugLog && err != io.EOF {
+			logger.Info("rpc: reading handshake:", err)
+		}
+		conn.Close()
+		return
+	}
+	codec, ok := server.codecs.get(typ)
+	if !ok {
+		logger.Error(fmt.Sprintf("rpc: client [ %s ] requested unknown codec: %d", conn.RemoteAddr(), typ))
+		conn.Close()
+		return
+	}
+	srv := codec.NewServerCodec(conn, func() {
+		server.UpdateConnTimeout(conn)
+	})
 	server.ServeCodec(srv)
 }
 
@@ -381,8 +542,9 @@ func (server *Server) ServeConn(conn net.Conn) {
 func (server *Server) ServeCodec(codec ServerCodec) {
 	sending := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
+	streams := newStreamTable(server, codec, sending)
 	for {
-		service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
+		service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec, streams)
 		if err != nil {
 			if debugLog && err != io.EOF {
 				logger.Info("rpc:", err)
@@ -398,11 +560,17 @@ func (server *Server) ServeCodec(codec ServerCodec) {
 			continue
 		}
 		codec.IOCallback()
+		if service == nil {
+			//流数据帧，读取时已交给对应的流
+			server.freeRequest(req)
+			continue
+		}
 		wg.Add(1)
 		go service.call(server, sending, wg, mtype, req, argv, replyv, codec)
 	}
 	// We've seen that there are no more requests.
 	// Wait for responses to be sent before closing codec.
+	streams.closeAll(ErrShutdown)
 	wg.Wait()
 	codec.Close()
 }
@@ -411,7 +579,7 @@ func (server *Server) ServeCodec(codec ServerCodec) {
 // It does not close the codec upon completion.
 func (server *Server) ServeRequest(codec ServerCodec) error {
 	sending := new(sync.Mutex)
-	service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
+	service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec, nil)
 	if err != nil {
 		if !keepReading {
 			return err
@@ -424,6 +592,10 @@ func (server *Server) ServeRequest(codec ServerCodec) error {
 		return err
 	}
 
+	if service == nil {
+		server.freeRequest(req)
+		return nil
+	}
 	service.call(server, sending, nil, mtype, req, argv, replyv, codec)
 	return nil
 }
@@ -468,7 +640,7 @@ func (server *Server) freeResponse(resp *Response) {
 	server.respLock.Unlock()
 }
 
-func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *methodType, req *Request, argv, replyv reflect.Value, keepReading bool, err error) {
+func (server *Server) readRequest(codec ServerCodec, streams *streamTable) (service *service, mtype *methodType, req *Request, argv, replyv reflect.Value, keepReading bool, err error) {
 	service, mtype, req, keepReading, err = server.readRequestHeader(codec)
 	if err != nil {
 		if !keepReading {
@@ -478,6 +650,34 @@ func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *m
 		codec.ReadRequestBody(nil)
 		return
 	}
+	if isStreamFrame(req.Type) {
+		err = streams.receive(req, codec)
+		return
+	}
+	//普通方法只接受普通调用，流式方法只接受对应的流
+	callType := req.Type
+	if callType == RPC_CALL_TYPE_WITHOUTREPLY {
+		callType = RPC_CALL_TYPE_NORMAL
+	}
+	if callType != mtype.callType {
+		codec.ReadRequestBody(nil)
+		err = errors.New(ErrNotStream.Error() + ": " + req.ServiceMethod)
+		return
+	}
+	if req.Type == RPC_CALL_TYPE_CLIENT_STREAM {
+		if err = codec.ReadRequestBody(nil); err != nil {
+			return
+		}
+		var s *stream
+		if s, err = streams.open(req); err != nil {
+			return
+		}
+		argv = reflect.ValueOf(&StreamReceiver{s})
+		if mtype.ReplyType != nil {
+			replyv = newReplyValue(mtype.ReplyType)
+		}
+		return
+	}
 
 	// Decode the argument value.
 	argIsValue := false // if true, need to indirect before calling.
@@ -495,19 +695,32 @@ func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *m
 		argv = argv.Elem()
 	}
 
-	if mtype.ReplyType != nil {
-		replyv = reflect.New(mtype.ReplyType.Elem())
-
-		switch mtype.ReplyType.Elem().Kind() {
-		case reflect.Map:
-			replyv.Elem().Set(reflect.MakeMap(mtype.ReplyType.Elem()))
-		case reflect.Slice:
-			replyv.Elem().Set(reflect.MakeSlice(mtype.ReplyType.Elem(), 0, 0))
+	if req.Type == RPC_CALL_TYPE_SERVER_STREAM {
+		var s *stream
+		if s, err = streams.open(req); err != nil {
+			return
 		}
+		replyv = reflect.ValueOf(&StreamSender{s})
+		return
+	}
+	if mtype.ReplyType != nil {
+		replyv = newReplyValue(mtype.ReplyType)
 	}
 	return
 }
 
+func newReplyValue(replyType reflect.Type) reflect.Value {
+	replyv := reflect.New(replyType.Elem())
+
+	switch replyType.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(replyType.Elem()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(replyType.Elem(), 0, 0))
+	}
+	return replyv
+}
+
 func (server *Server) readRequestHeader(codec ServerCodec) (svc *service, mtype *methodType, req *Request, keepReading bool, err error) {
 	// Grab the request header.
 	req = server.getRequest()
@@ -523,6 +736,12 @@ func (server *Server) readRequestHeader(codec ServerCodec) (svc *service, mtype
 	// We read the header successfully. If we see an error now,
 	// we can still recover and move on to the next request.
 	keepReading = true
+	if req.Timeout > 0 {
+		req.deadline = time.Now().Add(time.Duration(req.Timeout))
+	}
+	if isStreamFrame(req.Type) {
+		return
+	}
 
 	dot := strings.LastIndex(req.ServiceMethod, ".")
 	if dot < 0 {
@@ -598,8 +817,7 @@ type ServerCodec interface {
 // ServeConn runs the DefaultServer on a single connection.
 // ServeConn blocks, serving the connection until the client hangs up.
 // The caller typically invokes ServeConn in a go statement.
-// ServeConn uses the gob wire format (see package gob) on the
-// connection. To use an alternate codec, use ServeCodec.
+// The wire format is chosen by the client's handshake byte.
 // See NewClient's comment for information about concurrent access.
 func ServeConn(conn net.Conn) {
 	DefaultServer.ServeConn(conn)
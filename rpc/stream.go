@@ -0,0 +1,497 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+/*
+	流式调用
+	与普通调用复用同一个连接，以请求序号区分不同的流。
+	服务端流：方法签名为 func (t *T) Method(args A, out *rpc.StreamSender) error，
+		客户端通过 TcpClient.OpenServerStream 发起，逐个 Recv 直到 io.EOF。
+	客户端流：方法签名为 func (t *T) Method(in *rpc.StreamReceiver, reply *R) error，
+		客户端通过 TcpClient.OpenClientStream 发起，逐个 Send 后 CloseAndRecv 取得结果。
+	流量控制：接收方第一次 Recv 时确定元素类型并授予发送方 StreamWindow 个额度，
+		之后每消费一半窗口归还一次额度，发送方额度用完即阻塞，接收方缓存不超过一个窗口。
+*/
+
+var (
+	ErrStreamCanceled = errors.New("rpc: stream canceled")
+	ErrStreamClosed   = errors.New("rpc: send on closed stream")
+	ErrNotStream      = errors.New("rpc: call type does not match the method")
+
+	errStreamFlowControl = errors.New("rpc: stream flow control violated")
+)
+
+//流的一端，发送与接收共用
+type stream struct {
+	seq           uint64
+	serviceMethod string
+	window        uint64
+	reply         interface{} //客户端流的最终结果
+	write         func(s *stream, typ int, credit uint64, body interface{}) error
+	onFinish      func()
+
+	locker sync.Mutex
+	notify chan struct{} //状态变化时关闭并替换，唤醒所有等待者
+	done   chan struct{} //流结束时关闭
+
+	//接收
+	elemType reflect.Type
+	queue    []reflect.Value
+	consumed uint64 //已消费但尚未归还的额度
+	eof      bool   //对方已结束发送
+
+	//发送
+	credit     uint64
+	sendClosed bool
+
+	finished bool
+	err      error //结束状态，done关闭后有效
+}
+
+func newStream(serviceMethod string, write func(s *stream, typ int, credit uint64, body interface{}) error) *stream {
+	window := uint64(StreamWindow)
+	if window == 0 {
+		window = 1
+	}
+	return &stream{
+		serviceMethod: serviceMethod,
+		window:        window,
+		write:         write,
+		notify:        make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (s *stream) wakeLocked() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+//等待状态变化，调用时持有锁，返回时仍持有锁
+func (s *stream) waitLocked() {
+	notify := s.notify
+	s.locker.Unlock()
+	<-notify
+	s.locker.Lock()
+}
+
+func (s *stream) send(v interface{}) error {
+	s.locker.Lock()
+	for s.credit == 0 && !s.finished && !s.sendClosed {
+		s.waitLocked()
+	}
+	switch {
+	case s.finished:
+		err := s.err
+		s.locker.Unlock()
+		if err == nil {
+			return io.EOF
+		}
+		return err
+	case s.sendClosed:
+		s.locker.Unlock()
+		return ErrStreamClosed
+	}
+	s.credit--
+	s.locker.Unlock()
+	return s.write(s, RPC_CALL_TYPE_STREAM_DATA, 0, v)
+}
+
+func (s *stream) recv(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("rpc: Recv needs a non-nil pointer")
+	}
+	s.locker.Lock()
+	if s.elemType == nil {
+		//首次接收，确定元素类型后才开放窗口
+		s.elemType = rv.Type().Elem()
+		if !s.eof && !s.finished {
+			s.locker.Unlock()
+			if err := s.write(s, RPC_CALL_TYPE_STREAM_CREDIT, s.window, invalidRequest); err != nil {
+				s.finish(err)
+			}
+			s.locker.Lock()
+		}
+	} else if rv.Type().Elem() != s.elemType {
+		s.locker.Unlock()
+		return fmt.Errorf("rpc: stream of %s can't receive into %s", s.elemType, rv.Type())
+	}
+	for len(s.queue) == 0 {
+		if s.eof || s.finished {
+			err := s.err
+			s.locker.Unlock()
+			if err == nil {
+				return io.EOF
+			}
+			return err
+		}
+		s.waitLocked()
+	}
+	item := s.queue[0]
+	s.queue[0] = reflect.Value{}
+	s.queue = s.queue[1:]
+	s.consumed++
+	var grant uint64
+	if s.consumed >= (s.window+1)/2 && !s.eof && !s.finished {
+		grant = s.consumed
+		s.consumed = 0
+	}
+	s.locker.Unlock()
+	rv.Elem().Set(item.Elem())
+	if grant > 0 {
+		if err := s.write(s, RPC_CALL_TYPE_STREAM_CREDIT, grant, invalidRequest); err != nil {
+			s.finish(err)
+		}
+	}
+	return nil
+}
+
+//读取协程收到数据帧，read为编解码器的body读取函数
+func (s *stream) receive(read func(body interface{}) error) error {
+	s.locker.Lock()
+	typ, finished := s.elemType, s.finished
+	full := uint64(len(s.queue)) >= s.window
+	s.locker.Unlock()
+	if finished || typ == nil || full {
+		if err := read(nil); err != nil {
+			return err
+		}
+		if !finished {
+			s.finish(errStreamFlowControl)
+		}
+		return nil
+	}
+	v := reflect.New(typ)
+	if err := read(v.Interface()); err != nil {
+		return err
+	}
+	s.locker.Lock()
+	if !s.finished {
+		s.queue = append(s.queue, v)
+		s.wakeLocked()
+	}
+	s.locker.Unlock()
+	return nil
+}
+
+func (s *stream) grant(n uint64) {
+	s.locker.Lock()
+	s.credit += n
+	s.wakeLocked()
+	s.locker.Unlock()
+}
+
+//对方结束发送
+func (s *stream) closeRecv() {
+	s.locker.Lock()
+	s.eof = true
+	s.wakeLocked()
+	s.locker.Unlock()
+}
+
+//本方结束发送
+func (s *stream) closeSend() {
+	s.locker.Lock()
+	if s.sendClosed || s.finished {
+		s.locker.Unlock()
+		return
+	}
+	s.sendClosed = true
+	s.wakeLocked()
+	s.locker.Unlock()
+	if err := s.write(s, RPC_CALL_TYPE_STREAM_END, 0, invalidRequest); err != nil {
+		s.finish(err)
+	}
+}
+
+//结束流，返回是否由本次调用结束
+func (s *stream) finish(err error) bool {
+	s.locker.Lock()
+	if s.finished {
+		s.locker.Unlock()
+		return false
+	}
+	s.finished = true
+	s.err = err
+	close(s.done)
+	s.wakeLocked()
+	s.locker.Unlock()
+	if s.onFinish != nil {
+		s.onFinish()
+	}
+	return true
+}
+
+//本方放弃流，丢弃未读数据并通知对方
+func (s *stream) cancel(err error) {
+	s.locker.Lock()
+	s.queue = nil
+	s.locker.Unlock()
+	if s.finish(err) {
+		s.write(s, RPC_CALL_TYPE_STREAM_CANCEL, 0, invalidRequest)
+	}
+}
+
+// StreamSender sends a sequence of values over a stream. A server-streaming
+// method receives one as its reply argument.
+type StreamSender struct {
+	s *stream
+}
+
+// Send sends v, blocking while the receiver's flow control window is full.
+// It returns io.EOF once the stream has ended normally, or the error that
+// ended it, such as ErrStreamCanceled.
+func (this *StreamSender) Send(v interface{}) error {
+	return this.s.send(v)
+}
+
+// Done is closed when the stream has ended, for example because the receiver
+// canceled it or the connection was lost.
+func (this *StreamSender) Done() <-chan struct{} {
+	return this.s.done
+}
+
+// StreamReceiver receives a sequence of values over a stream. A
+// client-streaming method receives one as its argument.
+type StreamReceiver struct {
+	s *stream
+}
+
+// Recv decodes the next value into v, which must be a pointer to the same
+// type on every call. It returns io.EOF when the sender has finished, or the
+// error that ended the stream.
+func (this *StreamReceiver) Recv(v interface{}) error {
+	return this.s.recv(v)
+}
+
+// ServerStreamCall is the client side of a server-streaming call.
+type ServerStreamCall struct {
+	StreamReceiver
+}
+
+// Cancel abandons the stream; the server's next Send fails with ErrStreamCanceled.
+func (this *ServerStreamCall) Cancel() {
+	this.s.cancel(ErrStreamCanceled)
+}
+
+// ClientStreamCall is the client side of a client-streaming call.
+type ClientStreamCall struct {
+	StreamSender
+}
+
+// CloseAndRecv tells the server that sending is finished and waits for the
+// method to return. On success the reply given to OpenClientStream is filled in.
+func (this *ClientStreamCall) CloseAndRecv() error {
+	this.s.closeSend()
+	<-this.s.done
+	return this.s.err
+}
+
+// Cancel abandons the stream without waiting for the server.
+func (this *ClientStreamCall) Cancel() {
+	this.s.cancel(ErrStreamCanceled)
+}
+
+// OpenServerStream starts a server-streaming call with args. The deadline and
+// metadata of ctx are sent to the server, and the stream is canceled when ctx is done.
+func (client *TcpClient) OpenServerStream(ctx context.Context, serviceMethod string, args interface{}) (*ServerStreamCall, error) {
+	s, err := client.openStream(ctx, serviceMethod, RPC_CALL_TYPE_SERVER_STREAM, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerStreamCall{StreamReceiver{s}}, nil
+}
+
+// OpenClientStream starts a client-streaming call. reply is filled in when
+// CloseAndRecv returns successfully; it may be nil if the method has no reply.
+func (client *TcpClient) OpenClientStream(ctx context.Context, serviceMethod string, reply interface{}) (*ClientStreamCall, error) {
+	s, err := client.openStream(ctx, serviceMethod, RPC_CALL_TYPE_CLIENT_STREAM, invalidRequest, reply)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStreamCall{StreamSender{s}}, nil
+}
+
+func (client *TcpClient) openStream(ctx context.Context, serviceMethod string, typ int, args interface{}, reply interface{}) (*stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := newStream(serviceMethod, client.writeStream)
+	s.reply = reply
+	s.onFinish = func() {
+		client.mutex.Lock()
+		delete(client.streams, s.seq)
+		client.mutex.Unlock()
+	}
+	client.mutex.Lock()
+	if client.shutdown || client.closing {
+		client.mutex.Unlock()
+		return nil, ErrShutdown
+	}
+	s.seq = client.seq
+	client.seq++
+	client.streams[s.seq] = s
+	client.mutex.Unlock()
+
+	req := Request{ServiceMethod: serviceMethod, Seq: s.seq, Type: typ, Meta: MetadataFromContext(ctx)}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = timeoutUntil(deadline)
+	}
+	client.reqMutex.Lock()
+	err := client.codec.WriteRequest(&req, args)
+	client.reqMutex.Unlock()
+	if err != nil {
+		s.finish(err)
+		return nil, err
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.cancel(ctx.Err())
+			case <-s.done:
+			}
+		}()
+	}
+	return s, nil
+}
+
+func (client *TcpClient) writeStream(s *stream, typ int, credit uint64, body interface{}) error {
+	req := Request{ServiceMethod: s.serviceMethod, Seq: s.seq, Type: typ, Credit: credit}
+	client.reqMutex.Lock()
+	defer client.reqMutex.Unlock()
+	if client.IsClosed() {
+		return ErrShutdown
+	}
+	return client.codec.WriteRequest(&req, body)
+}
+
+//客户端读取协程收到流的回复
+func (client *TcpClient) streamResponse(s *stream, response *Response) error {
+	switch response.Type {
+	case RPC_CALL_TYPE_STREAM_DATA:
+		return s.receive(client.codec.ReadResponseBody)
+	case RPC_CALL_TYPE_STREAM_CREDIT:
+		s.grant(response.Credit)
+		return client.codec.ReadResponseBody(nil)
+	}
+	//最终结果，流结束
+	if response.Error != "" {
+		s.finish(ServerError(response.Error))
+		if err := client.codec.ReadResponseBody(nil); err != nil {
+			return ErrErrorBody
+		}
+		return nil
+	}
+	if err := client.codec.ReadResponseBody(s.reply); err != nil {
+		s.finish(errors.New("reading body " + err.Error()))
+		return err
+	}
+	s.finish(nil)
+	return nil
+}
+
+//服务端每个连接上的流
+type streamTable struct {
+	server  *Server
+	codec   ServerCodec
+	sending *sync.Mutex
+
+	locker  sync.Mutex
+	streams map[uint64]*stream
+}
+
+func newStreamTable(server *Server, codec ServerCodec, sending *sync.Mutex) *streamTable {
+	return &streamTable{
+		server:  server,
+		codec:   codec,
+		sending: sending,
+		streams: make(map[uint64]*stream),
+	}
+}
+
+func (this *streamTable) open(req *Request) (*stream, error) {
+	if this == nil {
+		return nil, errors.New("rpc: streaming calls are not supported on this connection")
+	}
+	s := newStream(req.ServiceMethod, this.write)
+	s.seq = req.Seq
+	s.onFinish = func() {
+		this.locker.Lock()
+		delete(this.streams, s.seq)
+		this.locker.Unlock()
+	}
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if _, ok := this.streams[req.Seq]; ok {
+		return nil, fmt.Errorf("rpc: duplicate stream %d", req.Seq)
+	}
+	this.streams[req.Seq] = s
+	return s, nil
+}
+
+//服务端读取协程收到流的数据或控制帧，已结束的流直接丢弃
+func (this *streamTable) receive(req *Request, codec ServerCodec) error {
+	var s *stream
+	if this != nil {
+		this.locker.Lock()
+		s = this.streams[req.Seq]
+		this.locker.Unlock()
+	}
+	if s == nil {
+		return codec.ReadRequestBody(nil)
+	}
+	switch req.Type {
+	case RPC_CALL_TYPE_STREAM_DATA:
+		return s.receive(codec.ReadRequestBody)
+	case RPC_CALL_TYPE_STREAM_END:
+		s.closeRecv()
+	case RPC_CALL_TYPE_STREAM_CREDIT:
+		s.grant(req.Credit)
+	case RPC_CALL_TYPE_STREAM_CANCEL:
+		s.finish(ErrStreamCanceled)
+	}
+	return codec.ReadRequestBody(nil)
+}
+
+func (this *streamTable) write(s *stream, typ int, credit uint64, body interface{}) error {
+	resp := this.server.getResponse()
+	resp.ServiceMethod = s.serviceMethod
+	resp.Seq = s.seq
+	resp.Type = typ
+	resp.Credit = credit
+	this.sending.Lock()
+	err := this.codec.WriteResponse(resp, body)
+	this.sending.Unlock()
+	this.server.freeResponse(resp)
+	return err
+}
+
+//连接断开，结束所有未完成的流
+func (this *streamTable) closeAll(err error) {
+	this.locker.Lock()
+	streams := make([]*stream, 0, len(this.streams))
+	for _, s := range this.streams {
+		streams = append(streams, s)
+	}
+	this.locker.Unlock()
+	for _, s := range streams {
+		s.finish(err)
+	}
+}
+
+func isStreamFrame(typ int) bool {
+	switch typ {
+	case RPC_CALL_TYPE_STREAM_DATA, RPC_CALL_TYPE_STREAM_END, RPC_CALL_TYPE_STREAM_CREDIT, RPC_CALL_TYPE_STREAM_CANCEL:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type StreamArith struct {
+	sent     int32
+	sendErrs chan error
+	watching chan struct{}
+}
+
+func (t *StreamArith) Count(args Args, out *StreamSender) error {
+	for i := 0; i < args.A; i++ {
+		if err := out.Send(i); err != nil {
+			if t.sendErrs != nil {
+				t.sendErrs <- err
+			}
+			return err
+		}
+		atomic.AddInt32(&t.sent, 1)
+	}
+	if args.B != 0 {
+		return errors.New("count failed")
+	}
+	return nil
+}
+
+func (t *StreamArith) Watch(args Args, out *StreamSender) error {
+	if err := out.Send(args.A); err != nil {
+		return err
+	}
+	<-out.Done()
+	t.watching <- struct{}{}
+	return nil
+}
+
+func (t *StreamArith) Sum(in *StreamReceiver, reply *Reply) error {
+	for {
+		var v int
+		err := in.Recv(&v)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply.C += v
+	}
+}
+
+func (t *StreamArith) PbCount(args *PbArgs, out *StreamSender) error {
+	for i := int32(0); i < args.A; i++ {
+		if err := out.Send(&PbReply{C: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startStreamServer(t *testing.T) (*StreamArith, string) {
+	server, addr := startCodecServer(t)
+	svc := &StreamArith{sendErrs: make(chan error, 1), watching: make(chan struct{}, 1)}
+	if err := server.Register(svc); err != nil {
+		t.Fatal(err)
+	}
+	return svc, addr
+}
+
+func TestServerStream(t *testing.T) {
+	_, addr := startStreamServer(t)
+	for _, typ := range []CodecType{CODEC_TYPE_GOB, CODEC_TYPE_MSGPACK} {
+		client, err := NewTcpClientWithCodec("tcp", addr, typ)
+		if err != nil {
+			t.Fatal("dialing", err)
+		}
+		n := StreamWindow*5 + 3
+		stream, err := client.OpenServerStream(context.Background(), "StreamArith.Count", &Args{A: n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := 0
+		for {
+			var v int
+			err = stream.Recv(&v)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("codec %d: Recv: %v", typ, err)
+			}
+			if v != got {
+				t.Fatalf("codec %d: expected %d got %d", typ, got, v)
+			}
+			got++
+		}
+		if got != n {
+			t.Errorf("codec %d: expected %d values, got %d", typ, n, got)
+		}
+
+		// The method error arrives after the values sent before it.
+		stream, err = client.OpenServerStream(context.Background(), "StreamArith.Count", &Args{A: 2, B: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v int
+		for i := 0; i < 2; i++ {
+			if err = stream.Recv(&v); err != nil || v != i {
+				t.Errorf("codec %d: expected %d got %d, err %v", typ, i, v, err)
+			}
+		}
+		if err = stream.Recv(&v); err == nil || err.Error() != "count failed" {
+			t.Errorf("codec %d: expected count failed; got %v", typ, err)
+		}
+		client.Close()
+	}
+}
+
+func TestProtobufStream(t *testing.T) {
+	_, addr := startStreamServer(t)
+	client, err := NewTcpClientWithCodec("tcp", addr, CODEC_TYPE_PROTOBUF)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+	stream, err := client.OpenServerStream(context.Background(), "StreamArith.PbCount", &PbArgs{A: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got int32
+	for {
+		reply := new(PbReply)
+		err = stream.Recv(reply)
+		if err == io.EOF {
+			break
+		}
+		if err != nil || reply.C != got {
+			t.Fatalf("expected %d got %d, err %v", got, reply.C, err)
+		}
+		got++
+	}
+	if got != 40 {
+		t.Errorf("expected 40 values, got %d", got)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	_, addr := startStreamServer(t)
+	for _, typ := range []CodecType{CODEC_TYPE_GOB, CODEC_TYPE_MSGPACK} {
+		client, err := NewTcpClientWithCodec("tcp", addr, typ)
+		if err != nil {
+			t.Fatal("dialing", err)
+		}
+		reply := new(Reply)
+		stream, err := client.OpenClientStream(context.Background(), "StreamArith.Sum", reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		for i := 0; i < StreamWindow*5; i++ {
+			if err = stream.Send(i); err != nil {
+				t.Fatalf("codec %d: Send: %v", typ, err)
+			}
+			want += i
+		}
+		if err = stream.CloseAndRecv(); err != nil {
+			t.Fatalf("codec %d: CloseAndRecv: %v", typ, err)
+		}
+		if reply.C != want {
+			t.Errorf("codec %d: expected %d got %d", typ, want, reply.C)
+		}
+		if err = stream.Send(1); err == nil {
+			t.Errorf("codec %d: expected Send after close to fail", typ)
+		}
+		client.Close()
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	svc, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	stream, err := client.OpenServerStream(context.Background(), "StreamArith.Count", &Args{A: StreamWindow * 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err = stream.Recv(&v); err != nil {
+		t.Fatal(err)
+	}
+	// Without further Recv calls the sender stops at the window.
+	time.Sleep(100 * time.Millisecond)
+	if sent := atomic.LoadInt32(&svc.sent); int(sent) > StreamWindow {
+		t.Errorf("sender exceeded window %d: sent %d", StreamWindow, sent)
+	}
+
+	// Unary calls are multiplexed on the same connection meanwhile.
+	reply := new(Reply)
+	if err = client.Call("Arith.Add", &Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3 got %d, err %v", reply.C, err)
+	}
+
+	stream.Cancel()
+	select {
+	case err = <-svc.sendErrs:
+		if err != ErrStreamCanceled {
+			t.Errorf("expected ErrStreamCanceled on the server; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("server did not notice the cancel")
+	}
+	if err = stream.Recv(&v); err != ErrStreamCanceled {
+		t.Errorf("expected ErrStreamCanceled; got %v", err)
+	}
+}
+
+func TestStreamSenderDone(t *testing.T) {
+	svc, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	for _, cancel := range []bool{true, false} {
+		stream, err := client.OpenServerStream(context.Background(), "StreamArith.Watch", &Args{A: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v int
+		if err = stream.Recv(&v); err != nil || v != 1 {
+			t.Fatalf("expected 1 got %d, err %v", v, err)
+		}
+		// The idle sender notices when the receiver goes away.
+		if cancel {
+			stream.Cancel()
+		} else {
+			client.Close()
+		}
+		select {
+		case <-svc.watching:
+		case <-time.After(time.Second):
+			t.Errorf("cancel %v: sender was not released", cancel)
+		}
+	}
+}
+
+func TestStreamCallTypeMismatch(t *testing.T) {
+	_, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	if err = client.Call("StreamArith.Sum", &Args{}, new(Reply)); err == nil {
+		t.Error("expected error calling a streaming method as unary")
+	}
+	stream, err := client.OpenServerStream(context.Background(), "Arith.Add", &Args{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err = stream.Recv(&v); err == nil || err == io.EOF {
+		t.Errorf("expected error streaming from a unary method; got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+)
+
+/*
+	TLS支持
+	服务端使用tls.NewListener包装监听后调用Accept，客户端使用NewTlsClient连接。
+	配置了CA时启用双向认证：服务端要求并校验客户端证书，客户端校验服务端证书。
+*/
+
+// NewServerTLSConfig loads the server certificate and key. If caFile is not
+// empty, clients must present a certificate signed by that CA (mutual TLS).
+func NewServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// NewClientTLSConfig builds the client side configuration. Servers are
+// verified against caFile, or the system roots if it is empty. certFile and
+// keyFile are the client certificate presented for mutual TLS and may be empty.
+// serverName overrides the name checked in server certificates; by default it
+// is the host of the dialed address.
+func NewClientTLSConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("rpc: no certificates found in " + caFile)
+	}
+	return pool, nil
+}
+
+// NewTlsClient dials remoteAddr over TLS and speaks the codec of the given type.
+// The handshake completes before the client is returned, so an untrusted
+// server certificate is reported here rather than on the first call.
+func NewTlsClient(network string, remoteAddr string, config *tls.Config, codecType CodecType, callback ...func(event string, data ...interface{})) (*TcpClient, error) {
+	codec, err := GetCodec(codecType)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: Timeout}
+	conn, err := tls.DialWithDialer(dialer, network, remoteAddr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithCodec(conn, codec, callback...)
+}
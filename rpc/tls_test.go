@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert              *x509.Certificate
+	key               *ecdsa.PrivateKey
+	certFile, keyFile string
+}
+
+//生成证书，parent为nil时生成自签名CA
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return c
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpctls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", ca)
+	clientCert := newTestCert(t, dir, "client", ca)
+	otherCA := newTestCert(t, dir, "other", nil)
+
+	serverTLS, err := NewServerTLSConfig(serverCert.certFile, serverCert.keyFile, ca.certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	server.Register(new(Arith))
+	l, addr := listenTCP()
+	go server.Accept(tls.NewListener(l, serverTLS))
+
+	clientTLS, err := NewClientTLSConfig(clientCert.certFile, clientCert.keyFile, ca.certFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []CodecType{CODEC_TYPE_GOB, CODEC_TYPE_MSGPACK} {
+		client, err := NewTlsClient("tcp", addr, clientTLS, typ)
+		if err != nil {
+			t.Fatal("dialing", err)
+		}
+		reply := new(Reply)
+		if err = client.Call("Arith.Add", &Args{7, 8}, reply); err != nil || reply.C != 15 {
+			t.Errorf("codec %d: Add: expected 15 got %d, err %v", typ, reply.C, err)
+		}
+		client.Close()
+	}
+
+	// Without a client certificate the server refuses the connection.
+	noCert, _ := NewClientTLSConfig("", "", ca.certFile, "")
+	if client, err := NewTlsClient("tcp", addr, noCert, CODEC_TYPE_GOB); err == nil {
+		if err = client.Call("Arith.Add", &Args{7, 8}, new(Reply)); err == nil {
+			t.Error("expected call without client certificate to fail")
+		}
+		client.Close()
+	}
+
+	// A server certificate from another CA is rejected by the client.
+	untrusted, _ := NewClientTLSConfig(clientCert.certFile, clientCert.keyFile, otherCA.certFile, "")
+	if client, err := NewTlsClient("tcp", addr, untrusted, CODEC_TYPE_GOB); err == nil {
+		client.Close()
+		t.Error("expected dialing a server signed by an untrusted CA to fail")
+	}
+
+	// Plain TCP clients can't talk to a TLS server.
+	client, err := NewTcpClient("tcp", addr)
+	if err == nil {
+		if err = client.Call("Arith.Add", &Args{7, 8}, new(Reply)); err == nil {
+			t.Error("expected plain call to a TLS server to fail")
+		}
+		client.Close()
+	}
+}
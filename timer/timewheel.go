@@ -9,6 +9,14 @@ var (
 	timerMap map[time.Duration]*TimeWheel
 	mapLock  = &sync.Mutex{}
 	accuracy = 20 // means max 1/20 deviation
+
+	sharedWheel     *TimeWheel
+	sharedWheelOnce sync.Once
+)
+
+const (
+	SHARED_WHEEL_TICK = time.Millisecond * 10
+	SHARED_WHEEL_SIZE = 512
 )
 
 func init() {
@@ -27,6 +35,15 @@ func After(t time.Duration) <-chan struct{} {
 	return v.After(t)
 }
 
+//AfterFunc calls f in its own goroutine after at least t on a shared wheel.
+//The wheel ticks every SHARED_WHEEL_TICK, longer timeouts wait for more rounds.
+func AfterFunc(t time.Duration, f func()) *Timer {
+	sharedWheelOnce.Do(func() {
+		sharedWheel = NewTimeWheel(SHARED_WHEEL_TICK, SHARED_WHEEL_SIZE)
+	})
+	return sharedWheel.AfterFunc(t, f)
+}
+
 //SetAccuracy sets the accuracy for the timewheel.
 //low accuracy usually have better performance.
 func SetAccuracy(a int) {
@@ -42,16 +59,28 @@ type TimeWheel struct {
 	ticker *time.Ticker
 
 	timeWheel []chan struct{}
+	tasks     []map[*Timer]struct{}
 	currPos   int
 }
 
+//Timer is a cancellable task on a TimeWheel.
+type Timer struct {
+	tw     *TimeWheel
+	pos    int
+	rounds int
+	f      func()
+	done   bool
+}
+
 //NewTimeWheel new timewheel with size.
 func NewTimeWheel(t time.Duration, size int) *TimeWheel {
 	tw := &TimeWheel{t: t, maxT: t * time.Duration(size)}
 
 	tw.timeWheel = make([]chan struct{}, size)
+	tw.tasks = make([]map[*Timer]struct{}, size)
 	for i := range tw.timeWheel {
 		tw.timeWheel[i] = make(chan struct{})
+		tw.tasks[i] = make(map[*Timer]struct{})
 	}
 	tw.ticker = time.NewTicker(t)
 	go tw.run()
@@ -80,13 +109,55 @@ func (tw *TimeWheel) After(timeout time.Duration) <-chan struct{} {
 	return c
 }
 
+//AfterFunc calls f in its own goroutine after at least timeout.
+//Unlike After, timeout may exceed maxT, the task then waits for more rounds.
+func (tw *TimeWheel) AfterFunc(timeout time.Duration, f func()) *Timer {
+	//round up so that the task never fires early
+	ticks := int((timeout + tw.t - 1) / tw.t)
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	t := &Timer{
+		tw:     tw,
+		pos:    (tw.currPos + ticks) % len(tw.timeWheel),
+		rounds: ticks / len(tw.timeWheel),
+		f:      f,
+	}
+	tw.tasks[t.pos][t] = struct{}{}
+	return t
+}
+
+//Stop cancels the task, it returns false if the task has already run or been stopped.
+func (t *Timer) Stop() bool {
+	t.tw.lock.Lock()
+	defer t.tw.lock.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	delete(t.tw.tasks[t.pos], t)
+	return true
+}
+
 func (tw *TimeWheel) run() {
 	for range tw.ticker.C {
 		tw.lock.Lock()
 		oldestC := tw.timeWheel[tw.currPos]
 		tw.timeWheel[tw.currPos] = make(chan struct{})
+		var expired []*Timer
+		for t := range tw.tasks[tw.currPos] {
+			if t.rounds > 0 {
+				t.rounds--
+				continue
+			}
+			t.done = true
+			delete(tw.tasks[tw.currPos], t)
+			expired = append(expired, t)
+		}
 		tw.currPos = (tw.currPos + 1) % len(tw.timeWheel)
 		tw.lock.Unlock()
 		close(oldestC)
+		for _, t := range expired {
+			go t.f()
+		}
 	}
 }
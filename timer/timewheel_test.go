@@ -34,6 +34,36 @@ func TestTimeWheel(t *testing.T) {
 	fmt.Println(float64(deviation) / float64(num*int64(sleepTime)))
 }
 
+//TestAfterFunc test cancellable tasks, including timeouts longer than one round.
+func TestAfterFunc(t *testing.T) {
+	tw := NewTimeWheel(time.Millisecond*10, 4)
+	defer tw.Stop()
+	fired := make(chan time.Duration, 2)
+	start := time.Now()
+	for _, d := range []time.Duration{time.Millisecond * 25, time.Millisecond * 95} {
+		tw.AfterFunc(d, func() { fired <- time.Since(start) })
+	}
+	stopped := tw.AfterFunc(time.Millisecond*30, func() { fired <- 0 })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("a pending task should be stopped only once")
+	}
+	for _, d := range []time.Duration{time.Millisecond * 25, time.Millisecond * 95} {
+		select {
+		case elapsed := <-fired:
+			if elapsed < d {
+				t.Fatalf("the task should fire after %v, fired after %v", d, elapsed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the task after %v is not fired", d)
+		}
+	}
+	select {
+	case <-fired:
+		t.Fatal("a stopped task should not fire")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
 //BenchmarkTimeWheel benchmarks timewheel.
 func BenchmarkTimeWheel(b *testing.B) {
 	for i := 0; i < b.N; i++ {